@@ -2,16 +2,19 @@ module roboticsproject
 
 go 1.25.1
 
+require (
+	github.com/grab/gosm v0.0.0-20230524134738-2d2586ee4db3
+	github.com/paulmach/osm v0.9.0
+)
+
 require (
 	github.com/DataDog/czlib v0.0.0-20240814115052-86a9592b3985 // indirect
 	github.com/dominikbraun/graph v0.23.0 // indirect
 	github.com/golang/protobuf v1.5.2 // indirect
-	github.com/grab/gosm v0.0.0-20230524134738-2d2586ee4db3 // indirect
 	github.com/mitchellh/copystructure v1.2.0 // indirect
 	github.com/mitchellh/reflectwalk v1.0.2 // indirect
 	github.com/mohae/deepcopy v0.0.0-20170929034955-c48cc78d4826 // indirect
 	github.com/paulmach/orb v0.12.0 // indirect
-	github.com/paulmach/osm v0.9.0 // indirect
 	github.com/paulmach/protoscan v0.2.1 // indirect
 	go.mongodb.org/mongo-driver v1.17.4 // indirect
 	gocv.io/x/gocv v0.42.0 // indirect
@@ -0,0 +1,239 @@
+package osmprocessing
+
+import (
+	"math"
+	"sort"
+)
+
+// Bounds is a latitude and longitude box. When MinLon > MaxLon the box
+// crosses the antimeridian, it covers MinLon to 180 and -180 to MaxLon.
+// MinLon -180 and MaxLon 180 covers all longitudes, as a box around a pole
+// has to.
+type Bounds struct {
+	MinLat, MaxLat float64
+	MinLon, MaxLon float64
+}
+
+func (m *Map) CalculateBounds() Bounds {
+	if len(m.Nodes) == 0 {
+		return Bounds{}
+	}
+
+	bounds := Bounds{
+		MinLat: math.Inf(1),
+		MaxLat: math.Inf(-1),
+	}
+
+	lons := make([]float64, 0, len(m.Nodes))
+	for _, node := range m.Nodes {
+		bounds.MinLat = math.Min(bounds.MinLat, node.Lat)
+		bounds.MaxLat = math.Max(bounds.MaxLat, node.Lat)
+		lons = append(lons, wrapMinLon(node.Lon))
+	}
+	bounds.MinLon, bounds.MaxLon = smallestLonRange(lons)
+
+	return bounds
+}
+
+// smallestLonRange returns the shortest longitude interval holding all of
+// lons, it leaves out the widest gap between two consecutive longitudes.
+func smallestLonRange(lons []float64) (minLon, maxLon float64) {
+	sort.Float64s(lons)
+
+	// the gap across the antimeridian, from the last back to the first
+	minLon, maxLon = lons[0], lons[len(lons)-1]
+	widest := lons[0] + 360 - lons[len(lons)-1]
+
+	for i := 1; i < len(lons); i++ {
+		if gap := lons[i] - lons[i-1]; gap > widest {
+			widest = gap
+			minLon, maxLon = lons[i], lons[i-1]
+		}
+	}
+	return minLon, maxLon
+}
+
+// wrapMinLon and wrapMaxLon bring an edge less than a turn away back into
+// range without touching longitudes that already are. An eastern edge
+// keeps 180 rather than turning it into -180.
+func wrapMinLon(lon float64) float64 {
+	switch {
+	case lon < -180:
+		return lon + 360
+	case lon >= 180:
+		return lon - 360
+	}
+	return lon
+}
+
+func wrapMaxLon(lon float64) float64 {
+	switch {
+	case lon <= -180:
+		return lon + 360
+	case lon > 180:
+		return lon - 360
+	}
+	return lon
+}
+
+func (b Bounds) CrossesAntimeridian() bool {
+	return b.MinLon > b.MaxLon
+}
+
+// LonSpan is the width of the box in degrees of longitude.
+func (b Bounds) LonSpan() float64 {
+	if b.CrossesAntimeridian() {
+		return b.MaxLon + 360 - b.MinLon
+	}
+	return b.MaxLon - b.MinLon
+}
+
+// lonOffset is how far east of MinLon lon is, between 0 and 360.
+func (b Bounds) lonOffset(lon float64) float64 {
+	return math.Mod(math.Mod(lon-b.MinLon, 360)+360, 360)
+}
+
+func (b Bounds) containsLon(lon float64) bool {
+	return b.LonSpan() >= 360 || b.lonOffset(lon) <= b.LonSpan()
+}
+
+func (b Bounds) containsLonRange(o Bounds) bool {
+	if b.LonSpan() >= 360 {
+		return true
+	}
+	return b.lonOffset(o.MinLon)+o.LonSpan() <= b.LonSpan()
+}
+
+func (b Bounds) Contains(lat, lon float64) bool {
+	return lat >= b.MinLat && lat <= b.MaxLat && b.containsLon(lon)
+}
+
+func (b Bounds) GetCenter() (lat, lon float64) {
+	lat = (b.MinLat + b.MaxLat) / 2
+	if !b.CrossesAntimeridian() {
+		return lat, (b.MinLon + b.MaxLon) / 2
+	}
+	return lat, wrapMinLon(b.MinLon + b.LonSpan()/2)
+}
+
+// Union is the smallest box holding both boxes.
+func (b Bounds) Union(o Bounds) Bounds {
+	u := Bounds{
+		MinLat: math.Min(b.MinLat, o.MinLat),
+		MaxLat: math.Max(b.MaxLat, o.MaxLat),
+	}
+
+	// go east from the start of one box to the end of the other, the
+	// shorter of the two ways that covers both wins
+	best := Bounds{MinLon: -180, MaxLon: 180}
+	for _, candidate := range []Bounds{b, o, {MinLon: b.MinLon, MaxLon: o.MaxLon}, {MinLon: o.MinLon, MaxLon: b.MaxLon}} {
+		if candidate.containsLonRange(b) && candidate.containsLonRange(o) && candidate.LonSpan() < best.LonSpan() {
+			best = candidate
+		}
+	}
+	u.MinLon, u.MaxLon = best.MinLon, best.MaxLon
+
+	return u
+}
+
+// Intersection is the part of the box inside o. Two boxes crossing the
+// antimeridian in opposite directions can overlap in two separate parts,
+// the wider one is returned. ok is false when the boxes don't overlap.
+func (b Bounds) Intersection(o Bounds) (Bounds, bool) {
+	i := Bounds{
+		MinLat: math.Max(b.MinLat, o.MinLat),
+		MaxLat: math.Min(b.MaxLat, o.MaxLat),
+	}
+	if i.MinLat > i.MaxLat {
+		return Bounds{}, false
+	}
+
+	switch {
+	case b.LonSpan() >= 360:
+		i.MinLon, i.MaxLon = o.MinLon, o.MaxLon
+		return i, true
+	case o.LonSpan() >= 360:
+		i.MinLon, i.MaxLon = b.MinLon, b.MaxLon
+		return i, true
+	}
+
+	// unwrap both boxes east of b.MinLon and try o a turn either side
+	found := false
+	widest := -1.0
+	bEast := b.MinLon + b.LonSpan()
+	for turn := -1.0; turn <= 1; turn++ {
+		west := math.Max(b.MinLon, o.MinLon+360*turn)
+		east := math.Min(bEast, o.MinLon+360*turn+o.LonSpan())
+		if west <= east && east-west > widest {
+			widest = east - west
+			i.MinLon, i.MaxLon = wrapMinLon(west), wrapMaxLon(east)
+			found = true
+		}
+	}
+
+	if !found {
+		return Bounds{}, false
+	}
+	return i, true
+}
+
+// Buffer grows the box by meters on every side. A box reaching a pole
+// covers all longitudes.
+func (b Bounds) Buffer(meters float64) Bounds {
+	// take degrees no longer than on the ellipsoid or on the sphere of
+	// HaversineDistance, so the buffer holds for both. A degree of latitude
+	// is shortest on the ellipsoid at the equator.
+	latMeters, _ := MetersPerDegree(0)
+	dLat := meters / latMeters
+
+	out := Bounds{
+		MinLat: b.MinLat - dLat,
+		MaxLat: b.MaxLat + dLat,
+	}
+	if out.MinLat <= -90 || out.MaxLat >= 90 {
+		out.MinLat = math.Max(out.MinLat, -90)
+		out.MaxLat = math.Min(out.MaxLat, 90)
+		out.MinLon, out.MaxLon = -180, 180
+		return out
+	}
+
+	// a degree of longitude is shortest on the sphere, on the edge nearest
+	// to a pole
+	lonMeters := R * math.Cos(DegToRad(math.Max(math.Abs(out.MinLat), math.Abs(out.MaxLat)))) * math.Pi / 180
+	dLon := meters / lonMeters
+
+	if b.LonSpan()+2*dLon >= 360 {
+		out.MinLon, out.MaxLon = -180, 180
+		return out
+	}
+	out.MinLon = wrapMinLon(b.MinLon - dLon)
+	out.MaxLon = wrapMaxLon(b.MaxLon + dLon)
+
+	return out
+}
+
+// Split cuts a box crossing the antimeridian into its western and eastern
+// parts, other boxes are returned as they are.
+func (b Bounds) Split() []Bounds {
+	if !b.CrossesAntimeridian() {
+		return []Bounds{b}
+	}
+	return []Bounds{
+		{MinLat: b.MinLat, MaxLat: b.MaxLat, MinLon: b.MinLon, MaxLon: 180},
+		{MinLat: b.MinLat, MaxLat: b.MaxLat, MinLon: -180, MaxLon: b.MaxLon},
+	}
+}
+
+// ToPolygon returns the box as a closed ring, counterclockwise from the
+// south west corner. A box crossing the antimeridian has its eastern edge
+// at MaxLon+360, Split it first for a polygon in plain longitudes.
+func (b Bounds) ToPolygon() Polygon {
+	east := b.MinLon + b.LonSpan()
+	return Polygon{Outer: []LatLon{
+		{b.MinLat, b.MinLon},
+		{b.MinLat, east},
+		{b.MaxLat, east},
+		{b.MaxLat, b.MinLon},
+		{b.MinLat, b.MinLon},
+	}}
+}
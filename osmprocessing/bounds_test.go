@@ -0,0 +1,164 @@
+package osmprocessing
+
+import (
+	"math"
+	"testing"
+
+	"github.com/paulmach/osm"
+)
+
+// a small grid around Taveuni, Fiji, which the antimeridian runs through
+func antimeridianMap(t *testing.T) (*Map, map[string]osm.NodeID) {
+	t.Helper()
+	return GenerateMap(2, 4, 500,
+		MakeCoordinateDecimal(-16.8, Latitude),
+		MakeCoordinateDecimal(179.996, Longitude))
+}
+
+func TestCalculateBoundsAcrossAntimeridian(t *testing.T) {
+	m, _ := antimeridianMap(t)
+	bounds := m.CalculateBounds()
+
+	if !bounds.CrossesAntimeridian() {
+		t.Fatalf("expected bounds crossing the antimeridian, got %+v", bounds)
+	}
+	if math.Abs(bounds.MinLon-179.996) > 1e-9 {
+		t.Errorf("MinLon %.6f, want 179.996", bounds.MinLon)
+	}
+	// 2km east at 16.8S
+	assertWithinPercent(t, bounds.LonSpan(), 2000/(111320*math.Cos(DegToRad(16.8))), 0.5)
+
+	for _, node := range m.Nodes {
+		if !bounds.Contains(node.Lat, node.Lon) {
+			t.Errorf("node %d at %.6f,%.6f outside %+v", node.ID, node.Lat, node.Lon, bounds)
+		}
+	}
+	if bounds.Contains(-16.79, 0) || bounds.Contains(-16.79, 179) || bounds.Contains(-16.79, -179) {
+		t.Error("bounds contain longitudes on the far side of the earth")
+	}
+
+	lat, lon := bounds.GetCenter()
+	if math.Abs(lon) < 179.99 || !bounds.Contains(lat, lon) {
+		t.Errorf("center %.6f,%.6f is not in the box", lat, lon)
+	}
+}
+
+func TestBoundsOperations(t *testing.T) {
+	bordeaux := Bounds{MinLat: 44.8, MaxLat: 44.9, MinLon: -0.7, MaxLon: -0.5}
+	paris := Bounds{MinLat: 48.8, MaxLat: 48.9, MinLon: 2.2, MaxLon: 2.4}
+	fiji := Bounds{MinLat: -17, MaxLat: -16, MinLon: 179.5, MaxLon: -179.5}
+	samoa := Bounds{MinLat: -14.5, MaxLat: -13, MinLon: -172.8, MaxLon: -171}
+
+	t.Run("union", func(t *testing.T) {
+		tests := []struct {
+			name string
+			a, b Bounds
+			want Bounds
+		}{
+			{"disjoint", bordeaux, paris, Bounds{MinLat: 44.8, MaxLat: 48.9, MinLon: -0.7, MaxLon: 2.4}},
+			{"contained", bordeaux, Bounds{MinLat: 44.85, MaxLat: 44.86, MinLon: -0.6, MaxLon: -0.55}, bordeaux},
+			{"across the antimeridian", fiji, samoa, Bounds{MinLat: -17, MaxLat: -13, MinLon: 179.5, MaxLon: -171}},
+			{"shorter way round", Bounds{MinLon: 170, MaxLon: 175}, Bounds{MinLon: -175, MaxLon: -170}, Bounds{MinLon: 170, MaxLon: -170}},
+			{"whole earth", Bounds{MinLon: -180, MaxLon: 0}, Bounds{MinLon: 0, MaxLon: 180}, Bounds{MinLon: -180, MaxLon: 180}},
+		}
+		for _, tt := range tests {
+			if got := tt.a.Union(tt.b); got != tt.want {
+				t.Errorf("%s: got %+v want %+v", tt.name, got, tt.want)
+			}
+		}
+	})
+
+	t.Run("intersection", func(t *testing.T) {
+		if _, ok := bordeaux.Intersection(paris); ok {
+			t.Error("bordeaux and paris overlap")
+		}
+
+		got, ok := fiji.Intersection(Bounds{MinLat: -16.5, MaxLat: 0, MinLon: -179.8, MaxLon: 0})
+		want := Bounds{MinLat: -16.5, MaxLat: -16, MinLon: -179.8, MaxLon: -179.5}
+		if !ok || got != want {
+			t.Errorf("got %+v %v want %+v", got, ok, want)
+		}
+
+		got, ok = fiji.Intersection(Bounds{MinLat: -20, MaxLat: -10, MinLon: 179.8, MaxLon: 180})
+		want = Bounds{MinLat: -17, MaxLat: -16, MinLon: 179.8, MaxLon: 180}
+		if !ok || got != want {
+			t.Errorf("got %+v %v want %+v", got, ok, want)
+		}
+
+		if _, ok := fiji.Intersection(samoa); ok {
+			t.Error("fiji and samoa overlap")
+		}
+	})
+
+	t.Run("buffer", func(t *testing.T) {
+		buffered := bordeaux.Buffer(1000)
+		for _, corner := range bordeaux.ToPolygon().Outer {
+			for _, bearing := range []float64{0, 90, 180, 270} {
+				lat, lon := DestinationPoint(corner.Lat, corner.Lon, bearing, 999)
+				if !buffered.Contains(lat, lon) {
+					t.Errorf("%.6f,%.6f 999m from a corner is outside %+v", lat, lon, buffered)
+				}
+			}
+		}
+
+		buffered = fiji.Buffer(50000)
+		if !buffered.CrossesAntimeridian() || !buffered.Contains(-16.5, 179.1) || !buffered.Contains(-16.5, -179.1) {
+			t.Errorf("got %+v", buffered)
+		}
+
+		buffered = Bounds{MinLat: 89.9, MaxLat: 89.95, MinLon: 10, MaxLon: 11}.Buffer(20000)
+		if buffered.MaxLat != 90 || buffered.LonSpan() != 360 || !buffered.Contains(89.99, -170) {
+			t.Errorf("buffer over the pole got %+v", buffered)
+		}
+	})
+
+	t.Run("polygon", func(t *testing.T) {
+		p := bordeaux.ToPolygon()
+		if !p.Contains(44.85, -0.6) || p.Contains(44.85, -0.4) {
+			t.Error("polygon doesn't match the box")
+		}
+		if p.Bounds() != bordeaux {
+			t.Errorf("polygon bounds %+v", p.Bounds())
+		}
+
+		parts := fiji.Split()
+		if len(parts) != 2 || !parts[0].ToPolygon().Contains(-16.5, 179.8) || !parts[1].ToPolygon().Contains(-16.5, -179.8) {
+			t.Errorf("split gave %+v", parts)
+		}
+		if fiji.ToPolygon().Bounds() != fiji {
+			t.Errorf("polygon bounds %+v", fiji.ToPolygon().Bounds())
+		}
+	})
+}
+
+func TestSpatialIndexAcrossAntimeridian(t *testing.T) {
+	m, grid := antimeridianMap(t)
+	em := NewEnhancedMap(m)
+
+	// the first column is west of the antimeridian, the second east of it
+	west, east := m.Nodes[grid["1,0"]], m.Nodes[grid["1,1"]]
+	if west.Lon < 0 || east.Lon > 0 {
+		t.Fatalf("unexpected grid %.6f %.6f", west.Lon, east.Lon)
+	}
+
+	node, dist := em.FindNearestNodeFast(east.Lat, east.Lon-0.0005, 100)
+	if node == nil || node.ID != east.ID {
+		t.Errorf("nearest node is %v, want %d", node, east.ID)
+	}
+	assertWithinPercent(t, dist, HaversineDistance(east.Lat, east.Lon-0.0005, east.Lat, east.Lon), 0.1)
+
+	lat, lon := DestinationPoint(west.Lat, west.Lon, 0, 20)
+	way, dist := em.FindNearestWayFast(lat, lon, 50)
+	if way == nil || dist > 21 {
+		t.Errorf("no way found across the antimeridian, got %v %.2f", way, dist)
+	}
+
+	nodes := em.SpatialIndex.QueryNodes(west.Lat, 180, 600)
+	found := map[osm.NodeID]bool{}
+	for _, n := range nodes {
+		found[n.ID] = true
+	}
+	if !found[west.ID] || !found[east.ID] {
+		t.Errorf("query at 180 missed nodes on one side, got %d nodes", len(nodes))
+	}
+}
@@ -0,0 +1,301 @@
+package osmprocessing
+
+import (
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/paulmach/osm"
+)
+
+// Building is the footprint of a closed building way or a building
+// multipolygon relation. Height is in metres and 0 when it isn't tagged,
+// Levels is 0 when building:levels isn't tagged.
+type Building struct {
+	ID      osm.FeatureID
+	Polygon Polygon
+	Height  float64
+	Levels  int
+}
+
+// metres per storey used when only building:levels is known
+const levelHeight = 3.0
+
+func (b *Building) EstimatedHeight() float64 {
+	if b.Height > 0 {
+		return b.Height
+	}
+	return float64(b.Levels) * levelHeight
+}
+
+func parseHeight(value string) (float64, bool) {
+	value = strings.TrimSpace(value)
+	value = strings.TrimSuffix(value, "m")
+	value = strings.TrimSpace(value)
+
+	height, err := strconv.ParseFloat(value, 64)
+	if err != nil || height < 0 {
+		return 0, false
+	}
+	return height, true
+}
+
+func newBuilding(id osm.FeatureID, tags osm.Tags, polygon Polygon) *Building {
+	b := &Building{ID: id, Polygon: polygon}
+
+	if height, ok := parseHeight(tags.Find("height")); ok {
+		b.Height = height
+	}
+	if levels, err := strconv.Atoi(strings.TrimSpace(tags.Find("building:levels"))); err == nil && levels > 0 {
+		b.Levels = levels
+	}
+
+	return b
+}
+
+func ringFromNodes(ids []osm.NodeID, nodes map[osm.NodeID]*osm.Node) ([]LatLon, bool) {
+	if len(ids) < 4 || ids[0] != ids[len(ids)-1] {
+		return nil, false
+	}
+
+	ring := make([]LatLon, 0, len(ids))
+	for _, id := range ids {
+		n, ok := nodes[id]
+		if !ok {
+			return nil, false
+		}
+		ring = append(ring, LatLon{Lat: n.Lat, Lon: n.Lon})
+	}
+	return ring, true
+}
+
+func extractBuildingWay(w *osm.Way, nodes map[osm.NodeID]*osm.Node) (*Building, bool) {
+	outer, ok := ringFromNodes(w.Nodes.NodeIDs(), nodes)
+	if !ok {
+		return nil, false
+	}
+	return newBuilding(w.FeatureID(), w.Tags, Polygon{Outer: outer}), true
+}
+
+// extractBuildingRelation builds a multipolygon building. Each outer ring
+// becomes its own building sharing the relation tags, inner rings are
+// assigned to the outer ring that contains them.
+func extractBuildingRelation(r *osm.Relation, wayNodes map[osm.WayID][]osm.NodeID,
+	nodes map[osm.NodeID]*osm.Node) []*Building {
+
+	var outerParts, innerParts [][]osm.NodeID
+
+	for _, member := range r.Members {
+		if member.Type != osm.TypeWay {
+			continue
+		}
+		ids := wayNodes[osm.WayID(member.Ref)]
+		if len(ids) == 0 {
+			continue
+		}
+		if member.Role == "inner" {
+			innerParts = append(innerParts, ids)
+		} else {
+			outerParts = append(outerParts, ids)
+		}
+	}
+
+	var holes [][]LatLon
+	for _, ids := range assembleRings(innerParts) {
+		if ring, ok := ringFromNodes(ids, nodes); ok {
+			holes = append(holes, ring)
+		}
+	}
+
+	var buildings []*Building
+	for _, ids := range assembleRings(outerParts) {
+		outer, ok := ringFromNodes(ids, nodes)
+		if !ok {
+			continue
+		}
+
+		polygon := Polygon{Outer: outer}
+		for _, hole := range holes {
+			if ringContains(outer, hole[0].Lat, hole[0].Lon) {
+				polygon.Holes = append(polygon.Holes, hole)
+			}
+		}
+		buildings = append(buildings, newBuilding(r.FeatureID(), r.Tags, polygon))
+	}
+
+	return buildings
+}
+
+// assembleRings joins way fragments that share end nodes into closed rings.
+// Fragments that can't be closed are dropped.
+func assembleRings(parts [][]osm.NodeID) [][]osm.NodeID {
+	remaining := make([][]osm.NodeID, 0, len(parts))
+	for _, p := range parts {
+		if len(p) > 1 {
+			remaining = append(remaining, p)
+		}
+	}
+
+	var rings [][]osm.NodeID
+
+	for len(remaining) > 0 {
+		ring := append([]osm.NodeID(nil), remaining[0]...)
+		remaining = remaining[1:]
+
+		for ring[0] != ring[len(ring)-1] {
+			joined := false
+			for i, p := range remaining {
+				last := ring[len(ring)-1]
+
+				switch last {
+				case p[0]:
+					ring = append(ring, p[1:]...)
+				case p[len(p)-1]:
+					for j := len(p) - 2; j >= 0; j-- {
+						ring = append(ring, p[j])
+					}
+				default:
+					continue
+				}
+
+				remaining = append(remaining[:i], remaining[i+1:]...)
+				joined = true
+				break
+			}
+			if !joined {
+				break
+			}
+		}
+
+		if ring[0] == ring[len(ring)-1] {
+			rings = append(rings, ring)
+		}
+	}
+
+	return rings
+}
+
+type BuildingIndex struct {
+	grid     map[GridCell][]*Building
+	cellSize float64 // in degrees
+}
+
+func NewBuildingIndex(cellSize float64) *BuildingIndex {
+	return &BuildingIndex{
+		grid:     make(map[GridCell][]*Building),
+		cellSize: cellSize,
+	}
+}
+
+func (bi *BuildingIndex) getCell(lat, lon float64) GridCell {
+	return GridCell{
+		LatIdx: int(math.Floor(lat / bi.cellSize)),
+		LonIdx: wrapLonIdx(int(math.Floor(lon/bi.cellSize)), bi.cellSize),
+	}
+}
+
+// Insert adds the building to every cell its bounding box overlaps, so long
+// facades are found even when no vertex lies near the query point.
+func (bi *BuildingIndex) Insert(b *Building) {
+	for _, cell := range bi.cells(b) {
+		bi.grid[cell] = append(bi.grid[cell], b)
+	}
+}
+
+func (bi *BuildingIndex) remove(b *Building) {
+	for _, cell := range bi.cells(b) {
+		buildings := slices.DeleteFunc(slices.Clone(bi.grid[cell]), func(o *Building) bool { return o == b })
+		if len(buildings) == 0 {
+			delete(bi.grid, cell)
+		} else {
+			bi.grid[cell] = buildings
+		}
+	}
+}
+
+func (bi *BuildingIndex) cells(b *Building) []GridCell {
+	bounds := b.Polygon.Bounds()
+	minCell := bi.getCell(bounds.MinLat, bounds.MinLon)
+	maxCell := bi.getCell(bounds.MaxLat, bounds.MaxLon)
+
+	// count the longitude cells eastwards, the box may cross the antimeridian
+	n := int(math.Round(360 / bi.cellSize))
+	lonCells := ((maxCell.LonIdx-minCell.LonIdx)%n + n) % n
+
+	var cells []GridCell
+	for latIdx := minCell.LatIdx; latIdx <= maxCell.LatIdx; latIdx++ {
+		for k := 0; k <= lonCells; k++ {
+			cells = append(cells, GridCell{LatIdx: latIdx, LonIdx: wrapLonIdx(minCell.LonIdx+k, bi.cellSize)})
+		}
+	}
+	return cells
+}
+
+func (bi *BuildingIndex) Query(lat, lon, radius float64) []*Building {
+	centerCell := bi.getCell(lat, lon)
+
+	latCells, lonCells := cellRadius(lat, radius, bi.cellSize)
+
+	seen := make(map[*Building]bool)
+	var results []*Building
+
+	for dLat := -latCells; dLat <= latCells; dLat++ {
+		for dLon := -lonCells; dLon <= lonCells; dLon++ {
+			cell := GridCell{
+				LatIdx: centerCell.LatIdx + dLat,
+				LonIdx: wrapLonIdx(centerCell.LonIdx+dLon, bi.cellSize),
+			}
+
+			for _, b := range bi.grid[cell] {
+				if !seen[b] {
+					results = append(results, b)
+					seen[b] = true
+				}
+			}
+		}
+	}
+
+	return results
+}
+
+// NearestFacade returns the building with the closest wall within maxDist,
+// the distance to that wall and the bearing from the point towards it.
+func (bi *BuildingIndex) NearestFacade(lat, lon, maxDist float64) (*Building, float64, float64) {
+	var nearest *Building
+	minDist := math.Inf(1)
+	bearing := 0.0
+
+	for _, b := range bi.Query(lat, lon, maxDist) {
+		for _, ring := range b.Polygon.Rings() {
+			for i := 0; i < len(ring)-1; i++ {
+				cLat, cLon := ClosestPointOnSegment(lat, lon, ring[i].Lat, ring[i].Lon, ring[i+1].Lat, ring[i+1].Lon)
+				dist := HaversineDistance(lat, lon, cLat, cLon)
+				if dist < minDist && dist <= maxDist {
+					minDist = dist
+					nearest = b
+					bearing = CalculateBearing(lat, lon, cLat, cLon)
+				}
+			}
+		}
+	}
+
+	return nearest, minDist, bearing
+}
+
+func (bi *BuildingIndex) BuildingAt(lat, lon float64) *Building {
+	for _, b := range bi.grid[bi.getCell(lat, lon)] {
+		if b.Polygon.Contains(lat, lon) {
+			return b
+		}
+	}
+	return nil
+}
+
+func (m *Map) BuildBuildingIndex(cellSize float64) *BuildingIndex {
+	index := NewBuildingIndex(cellSize)
+	for _, b := range m.Buildings {
+		index.Insert(b)
+	}
+	return index
+}
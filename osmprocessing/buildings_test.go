@@ -0,0 +1,209 @@
+package osmprocessing
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"testing"
+
+	"github.com/paulmach/osm"
+	"github.com/paulmach/osm/osmxml"
+)
+
+// square returns the node ids of a closed square ring around (lat, lon)
+// with the given half size in degrees, adding its nodes to the map.
+func square(nodes map[osm.NodeID]*osm.Node, firstID osm.NodeID, lat, lon, half float64) []osm.NodeID {
+	corners := []LatLon{
+		{lat - half, lon - half},
+		{lat - half, lon + half},
+		{lat + half, lon + half},
+		{lat + half, lon - half},
+	}
+
+	ids := make([]osm.NodeID, 0, 5)
+	for i, c := range corners {
+		id := firstID + osm.NodeID(i)
+		nodes[id] = &osm.Node{ID: id, Lat: c.Lat, Lon: c.Lon}
+		ids = append(ids, id)
+	}
+	return append(ids, firstID)
+}
+
+func TestParseHeight(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected float64
+		ok       bool
+	}{
+		{"12", 12, true},
+		{"12.5 m", 12.5, true},
+		{"7m", 7, true},
+		{"tall", 0, false},
+		{"", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, ok := parseHeight(tt.value)
+			if ok != tt.ok || got != tt.expected {
+				t.Errorf("got %v,%v want %v,%v", got, ok, tt.expected, tt.ok)
+			}
+		})
+	}
+}
+
+func TestAssembleRings(t *testing.T) {
+	parts := [][]osm.NodeID{
+		{1, 2, 3},
+		{5, 4, 3},
+		{5, 6, 1},
+		{10, 11, 12},
+	}
+
+	rings := assembleRings(parts)
+	if len(rings) != 1 {
+		t.Fatalf("expected 1 closed ring, got %d", len(rings))
+	}
+
+	want := []osm.NodeID{1, 2, 3, 4, 5, 6, 1}
+	if len(rings[0]) != len(want) {
+		t.Fatalf("got ring %v, want %v", rings[0], want)
+	}
+	for i := range want {
+		if rings[0][i] != want[i] {
+			t.Fatalf("got ring %v, want %v", rings[0], want)
+		}
+	}
+}
+
+func TestExtractBuildingRelation(t *testing.T) {
+	nodes := make(map[osm.NodeID]*osm.Node)
+	outer := square(nodes, 100, 46, 7, 0.001)
+	inner := square(nodes, 200, 46, 7, 0.0002)
+
+	wayNodes := map[osm.WayID][]osm.NodeID{
+		1: outer[:3],
+		2: outer[2:],
+		3: inner,
+	}
+
+	r := &osm.Relation{
+		ID: 42,
+		Tags: osm.Tags{
+			{Key: "type", Value: "multipolygon"},
+			{Key: "building", Value: "yes"},
+			{Key: "building:levels", Value: "4"},
+		},
+		Members: osm.Members{
+			{Type: osm.TypeWay, Ref: 1, Role: "outer"},
+			{Type: osm.TypeWay, Ref: 2, Role: "outer"},
+			{Type: osm.TypeWay, Ref: 3, Role: "inner"},
+		},
+	}
+
+	buildings := extractBuildingRelation(r, wayNodes, nodes)
+	if len(buildings) != 1 {
+		t.Fatalf("expected 1 building, got %d", len(buildings))
+	}
+
+	b := buildings[0]
+	if len(b.Polygon.Holes) != 1 {
+		t.Errorf("expected 1 hole, got %d", len(b.Polygon.Holes))
+	}
+	if b.EstimatedHeight() != 12 {
+		t.Errorf("expected estimated height 12, got %v", b.EstimatedHeight())
+	}
+	if b.Polygon.Contains(46, 7) {
+		t.Error("courtyard should not be inside the building")
+	}
+	if !b.Polygon.Contains(46.0005, 7) {
+		t.Error("point between rings should be inside the building")
+	}
+}
+
+func TestExtractObjectsBuildingRelation(t *testing.T) {
+	nodes := make(map[osm.NodeID]*osm.Node)
+	outer := square(nodes, 100, 46, 7, 0.001)
+	inner := square(nodes, 200, 46, 7, 0.0002)
+
+	data := &osm.OSM{}
+	for _, id := range append(outer[:4], inner[:4]...) {
+		data.Nodes = append(data.Nodes, nodes[id])
+	}
+	wayOf := func(id osm.WayID, ids []osm.NodeID) *osm.Way {
+		way := &osm.Way{ID: id}
+		for _, n := range ids {
+			way.Nodes = append(way.Nodes, osm.WayNode{ID: n})
+		}
+		return way
+	}
+	// members as mappers draw them, without tags
+	data.Ways = osm.Ways{wayOf(1, outer[:3]), wayOf(2, outer[2:]), wayOf(3, inner)}
+	data.Relations = osm.Relations{{
+		ID:   42,
+		Tags: osm.Tags{{Key: "type", Value: "multipolygon"}, {Key: "building", Value: "yes"}},
+		Members: osm.Members{
+			{Type: osm.TypeWay, Ref: 1, Role: "outer"},
+			{Type: osm.TypeWay, Ref: 2, Role: "outer"},
+			{Type: osm.TypeWay, Ref: 3, Role: "inner"},
+			{Type: osm.TypeWay, Ref: 4, Role: "outer"}, // not in the extract
+		},
+	}}
+	encoded, err := xml.Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	passes := 0
+	m, err := extractObjects(func(waysOnly bool) (osm.Scanner, error) {
+		passes++
+		return osmxml.New(context.Background(), bytes.NewReader(encoded)), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if passes != 2 || len(m.Buildings) != 1 {
+		t.Fatalf("%d buildings in %d passes", len(m.Buildings), passes)
+	}
+	if b := m.Buildings[0]; len(b.Polygon.Holes) != 1 || b.Polygon.Contains(46, 7) || !b.Polygon.Contains(46.0005, 7) {
+		t.Errorf("got %+v", b.Polygon)
+	}
+}
+
+func TestNearestFacade(t *testing.T) {
+	nodes := make(map[osm.NodeID]*osm.Node)
+	w := &osm.Way{
+		ID:    1,
+		Tags:  osm.Tags{{Key: "building", Value: "yes"}, {Key: "height", Value: "20"}},
+		Nodes: osm.WayNodes{},
+	}
+	for _, id := range square(nodes, 1, 46, 7, 0.0005) {
+		w.Nodes = append(w.Nodes, osm.WayNode{ID: id})
+	}
+
+	b, ok := extractBuildingWay(w, nodes)
+	if !ok {
+		t.Fatal("expected closed way to become a building")
+	}
+
+	m := &Map{Nodes: map[osm.NodeID]*osm.Node{}, Buildings: []*Building{b}}
+	em := NewEnhancedMap(m)
+
+	// 0.0005 degrees (~55m) south of the south wall
+	lat, lon := 46-0.001, 7.0
+
+	got, dist, bearing := em.FindNearestFacade(lat, lon, 100)
+	if got != b {
+		t.Fatal("expected to find the building")
+	}
+	assertWithinPercent(t, dist, 55.6, 1.0)
+	assertWithinPercent(t, BearingDifference(bearing, 0), 0, 1.0)
+
+	if got, _, _ := em.FindNearestFacade(lat, lon, 30); got != nil {
+		t.Error("expected no facade within 30m")
+	}
+
+	if em.BuildingIndex.BuildingAt(46, 7) != b {
+		t.Error("expected building at its centre")
+	}
+}
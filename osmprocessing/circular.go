@@ -0,0 +1,126 @@
+package osmprocessing
+
+import (
+	"math"
+)
+
+// resultant sums the unit vectors of the bearings, weights may be nil for
+// equal weights. It returns the bearing of the sum and its length divided
+// by the total weight.
+func resultant(bearings []BearingDecimal, weights []float64) (mean, length float64) {
+	var sumSin, sumCos, total float64
+
+	for i, b := range bearings {
+		w := 1.0
+		if weights != nil {
+			w = weights[i]
+		}
+		sin, cos := math.Sincos(DegToRad(float64(b)))
+		sumSin += w * sin
+		sumCos += w * cos
+		total += w
+	}
+
+	if total <= 0 {
+		return 0, 0
+	}
+	return NormalizeBearing(math.Atan2(sumSin, sumCos) * 180 / math.Pi), math.Hypot(sumSin, sumCos) / total
+}
+
+// CircularMean is the direction of the weighted sum of the bearings as unit
+// vectors, so 359° and 1° average to 0°. weights may be nil. The mean is
+// meaningless when the resultant length is near 0, as for 0° and 180°.
+func CircularMean(bearings []BearingDecimal, weights []float64) (mean BearingDecimal, resultantLength float64) {
+	m, r := resultant(bearings, weights)
+	return BearingDecimal(m), r
+}
+
+// ResultantLength is between 0 for bearings spread evenly and 1 when they
+// are all the same.
+func ResultantLength(bearings []BearingDecimal, weights []float64) float64 {
+	_, r := resultant(bearings, weights)
+	return r
+}
+
+func CircularVariance(bearings []BearingDecimal, weights []float64) float64 {
+	return 1 - ResultantLength(bearings, weights)
+}
+
+// CircularStdDev is sqrt(-2 ln R) in degrees, close to the usual standard
+// deviation for concentrated bearings.
+func CircularStdDev(bearings []BearingDecimal, weights []float64) float64 {
+	r := ResultantLength(bearings, weights)
+	if r <= 0 {
+		return math.Inf(1)
+	}
+	return math.Sqrt(-2*math.Log(r)) * 180 / math.Pi
+}
+
+// VonMises is the circular counterpart of the normal distribution, with
+// mean direction Mu and concentration Kappa. Kappa 0 is uniform, a large
+// Kappa is close to a normal of variance 1/Kappa in radians.
+type VonMises struct {
+	Mu    BearingDecimal
+	Kappa float64
+}
+
+// NewVonMisesFromStdDev approximates a normal distribution of sigma
+// degrees around mu.
+func NewVonMisesFromStdDev(mu BearingDecimal, sigma float64) VonMises {
+	s := DegToRad(sigma)
+	return VonMises{Mu: mu, Kappa: 1 / (s * s)}
+}
+
+// FitVonMises estimates the distribution of the bearings by maximum
+// likelihood, inverting A1(kappa) = R with the approximation of Best and
+// Fisher (1981).
+func FitVonMises(bearings []BearingDecimal, weights []float64) VonMises {
+	mean, r := CircularMean(bearings, weights)
+
+	var kappa float64
+	switch {
+	case r < 0.53:
+		kappa = 2*r + r*r*r + 5*math.Pow(r, 5)/6
+	case r < 0.85:
+		kappa = -0.4 + 1.39*r + 0.43/(1-r)
+	case r < 1:
+		kappa = 1 / (r*r*r - 4*r*r + 3*r)
+	default:
+		kappa = math.Inf(1)
+	}
+
+	return VonMises{Mu: mean, Kappa: kappa}
+}
+
+// logBesselI0e is ln(exp(-x) I0(x)), from the polynomial approximations of
+// Abramowitz and Stegun 9.8.1 and 9.8.2, relative error below 2e-7.
+func logBesselI0e(x float64) float64 {
+	if x < 3.75 {
+		t := x / 3.75
+		t *= t
+		i0 := 1 + t*(3.5156229+t*(3.0899424+t*(1.2067492+t*(0.2659732+t*(0.0360768+t*0.0045813)))))
+		return math.Log(i0) - x
+	}
+
+	t := 3.75 / x
+	p := 0.39894228 + t*(0.01328592+t*(0.00225319+t*(-0.00157565+t*(0.00916281+
+		t*(-0.02057706+t*(0.02635537+t*(-0.01647633+t*0.00392377)))))))
+	return math.Log(p) - 0.5*math.Log(x)
+}
+
+// LogPDF is the log density at the bearing, per radian.
+func (v VonMises) LogPDF(bearing BearingDecimal) float64 {
+	delta := DegToRad(float64(bearing - v.Mu))
+	return v.Kappa*(math.Cos(delta)-1) - math.Log(2*math.Pi) - logBesselI0e(v.Kappa)
+}
+
+// PDF is the density at the bearing, per radian.
+func (v VonMises) PDF(bearing BearingDecimal) float64 {
+	return math.Exp(v.LogPDF(bearing))
+}
+
+// Likelihood is the density scaled to 1 at the mean, like an unnormalised
+// gaussian, so it can stand in for one when weighting particles.
+func (v VonMises) Likelihood(bearing BearingDecimal) float64 {
+	return math.Exp(v.Kappa * (math.Cos(DegToRad(float64(bearing-v.Mu))) - 1))
+}
@@ -0,0 +1,95 @@
+package osmprocessing
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestCircularMean(t *testing.T) {
+	tests := []struct {
+		name     string
+		bearings []BearingDecimal
+		weights  []float64
+		mean     float64
+		length   float64
+	}{
+		{"across north", []BearingDecimal{359, 1}, nil, 0, math.Cos(DegToRad(1))},
+		{"three", []BearingDecimal{350, 10, 30}, nil, 10, (1 + 2*math.Cos(DegToRad(20))) / 3},
+		{"weighted", []BearingDecimal{350, 20}, []float64{2, 1}, 359.896, 0},
+		{"single", []BearingDecimal{123}, nil, 123, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mean, r := CircularMean(tt.bearings, tt.weights)
+			if math.Abs(BearingDifference(float64(mean), tt.mean)) > 0.01 {
+				t.Errorf("mean %.4f, want %.4f", mean, tt.mean)
+			}
+			if tt.length > 0 && math.Abs(r-tt.length) > 1e-9 {
+				t.Errorf("resultant length %.9f, want %.9f", r, tt.length)
+			}
+		})
+	}
+
+	if r := ResultantLength([]BearingDecimal{0, 90, 180, 270}, nil); r > 1e-12 {
+		t.Errorf("evenly spread bearings have resultant %v", r)
+	}
+	if v := CircularVariance([]BearingDecimal{0, 180}, nil); math.Abs(v-1) > 1e-12 {
+		t.Errorf("opposite bearings have variance %v", v)
+	}
+	if v := CircularVariance([]BearingDecimal{42, 42, 42}, nil); math.Abs(v) > 1e-12 {
+		t.Errorf("equal bearings have variance %v", v)
+	}
+}
+
+func TestFitVonMises(t *testing.T) {
+	rng := rand.New(rand.NewSource(7))
+
+	for _, sigma := range []float64{5, 20, 45} {
+		bearings := make([]BearingDecimal, 20000)
+		for i := range bearings {
+			bearings[i] = BearingDecimal(NormalizeBearing(355 + rng.NormFloat64()*sigma))
+		}
+
+		fit := FitVonMises(bearings, nil)
+		if math.Abs(BearingDifference(float64(fit.Mu), 355)) > 1 {
+			t.Errorf("sigma %v: mean %.2f, want 355", sigma, fit.Mu)
+		}
+		// a wrapped normal and a von Mises of the same resultant length
+		// are close, kappa is about 1/sigma^2 for small sigma
+		want := NewVonMisesFromStdDev(355, sigma).Kappa
+		if sigma < 30 {
+			assertWithinPercent(t, fit.Kappa, want, 10)
+		}
+		assertWithinPercent(t, CircularStdDev(bearings, nil), sigma, 10)
+	}
+}
+
+func TestVonMisesDensity(t *testing.T) {
+	for _, kappa := range []float64{0, 0.5, 3, 14.6, 100, 2000} {
+		v := VonMises{Mu: 10, Kappa: kappa}
+
+		// integrate over the circle in radians
+		total := 0.0
+		steps := 36000
+		for i := 0; i < steps; i++ {
+			total += v.PDF(BearingDecimal(float64(i)*360/float64(steps))) * 2 * math.Pi / float64(steps)
+		}
+		if math.Abs(total-1) > 1e-5 {
+			t.Errorf("kappa %v: density integrates to %.7f", kappa, total)
+		}
+
+		if l := v.Likelihood(10); l != 1 {
+			t.Errorf("kappa %v: likelihood at the mean %v", kappa, l)
+		}
+	}
+
+	v := NewVonMisesFromStdDev(0, 15)
+	if math.Abs(v.Likelihood(359)-v.Likelihood(1)) > 1e-12 {
+		t.Error("likelihood is not symmetric across north")
+	}
+	// close to the gaussian it replaces for small differences
+	gaussian := math.Exp(-10 * 10 / (2 * 15.0 * 15.0))
+	assertWithinPercent(t, v.Likelihood(10), gaussian, 1)
+}
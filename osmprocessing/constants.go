@@ -1,7 +1,6 @@
 package osmprocessing
 
 import (
-	"fmt"
 	"math"
 )
 
@@ -25,7 +24,7 @@ const (
 type Coordinate struct {
 	Deg       int
 	Minute    int
-	Second    int
+	Second    float64
 	Direction int
 }
 type CoordinateDecimal struct {
@@ -45,21 +44,14 @@ func MakeCoordinateDecimal(decimalDegree float64, coordType int) CoordinateDecim
 }
 
 func (c CoordinateDecimal) String() string {
-	coordinate := c.ToCoordinate()
-	var direction rune
-
-	switch coordinate.Direction {
-	case South:
-		direction = 'S'
-	case North:
-		direction = 'N'
-	case West:
-		direction = 'W'
-	case East:
-		direction = 'E'
-	}
+	return c.Format(0)
+}
 
-	return fmt.Sprintf("%d°%d'%d\" %c", coordinate.Deg, coordinate.Minute, coordinate.Second, direction)
+// Rounded returns the coordinate rounded to the given number of decimal
+// places, for display. 4 places is about 11m, 7 places about 1cm.
+func (c CoordinateDecimal) Rounded(places int) CoordinateDecimal {
+	scale := math.Pow(10, float64(places))
+	return CoordinateDecimal{DecimalDegree: math.Round(c.DecimalDegree*scale) / scale, CoordType: c.CoordType}
 }
 
 func normalizeLon(lon float64) float64 {
@@ -71,7 +63,7 @@ func ToDecimalBearing(deg, min, sec int) BearingDecimal {
 }
 
 func ToDecimalCoord(deg, min, sec, direction int) CoordinateDecimal {
-	return Coordinate{Deg: deg, Minute: min, Second: sec, Direction: direction}.ToDecimalCoord()
+	return Coordinate{Deg: deg, Minute: min, Second: float64(sec), Direction: direction}.ToDecimalCoord()
 }
 
 func (c CoordinateDecimal) ToCoordinate() Coordinate {
@@ -92,30 +84,20 @@ func (c CoordinateDecimal) ToCoordinate() Coordinate {
 		}
 	}
 
-	absDegree := math.Abs(float64(c.DecimalDegree))
+	absDegree := math.Abs(c.DecimalDegree)
 	degree := int(absDegree)
 
 	decMinute := (absDegree - float64(degree)) * 60
 	minute := int(decMinute)
 
-	decSeconds := (decMinute - float64(minute)) * 60
-	seconds := int(math.Round(decSeconds))
-
-	if seconds == 60 {
-		minute++
-		seconds = 0
-	}
-	if minute == 60 {
-		degree++
-		minute = 0
-	}
+	seconds := (decMinute - float64(minute)) * 60
 
 	return Coordinate{Deg: degree, Minute: minute, Second: seconds, Direction: direction}
 }
 
 func (c Coordinate) ToDecimalCoord() CoordinateDecimal {
 
-	decimalDegree := float64(c.Deg) + float64(c.Minute)/60.0 + float64(c.Second)/3600.0
+	decimalDegree := float64(c.Deg) + float64(c.Minute)/60.0 + c.Second/3600.0
 	coordType := Latitude
 	if c.Direction == West || c.Direction == South {
 		decimalDegree *= -1
@@ -0,0 +1,366 @@
+package osmprocessing
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidCoordinate = errors.New("invalid coordinate")
+
+func invalidCoordinate(input, reason string, args ...any) error {
+	return fmt.Errorf("failed to parse coordinate %q %w: %s", input, ErrInvalidCoordinate, fmt.Sprintf(reason, args...))
+}
+
+var directionLetters = map[int]byte{North: 'N', South: 'S', East: 'E', West: 'W'}
+
+// Format writes the coordinate as degrees, minutes and seconds with
+// precision decimals on the seconds, 44°50'14.20" N for precision 2.
+func (c CoordinateDecimal) Format(precision int) string {
+	scale := math.Pow(10, float64(precision))
+	total := math.Round(math.Abs(c.DecimalDegree)*3600*scale) / scale
+
+	// work on the rounded total so 59.999" carries into the minutes
+	degree := math.Floor(total / 3600)
+	minute := math.Floor((total - degree*3600) / 60)
+	second := total - degree*3600 - minute*60
+
+	return fmt.Sprintf("%d°%d'%.*f\" %c", int(degree), int(minute), precision, second, directionLetters[c.ToCoordinate().Direction])
+}
+
+// FormatDecimal writes the signed decimal degrees, 7 decimals is about 1cm.
+func (c CoordinateDecimal) FormatDecimal(precision int) string {
+	return strconv.FormatFloat(c.DecimalDegree, 'f', precision, 64)
+}
+
+// Validate checks the ranges of the fields, the degrees are checked against
+// 90 or 180 depending on the direction.
+func (c Coordinate) Validate() error {
+	maxDeg := 90
+	switch c.Direction {
+	case North, South:
+	case East, West:
+		maxDeg = 180
+	default:
+		return fmt.Errorf("%w: unknown direction %d", ErrInvalidCoordinate, c.Direction)
+	}
+
+	if c.Deg < 0 || c.Minute < 0 || c.Second < 0 {
+		return fmt.Errorf("%w: negative field in %d°%d'%v\"", ErrInvalidCoordinate, c.Deg, c.Minute, c.Second)
+	}
+	if c.Minute >= 60 || c.Second >= 60 {
+		return fmt.Errorf("%w: minutes and seconds must be below 60, got %d'%v\"", ErrInvalidCoordinate, c.Minute, c.Second)
+	}
+	if total := float64(c.Deg) + float64(c.Minute)/60 + c.Second/3600; total > float64(maxDeg) {
+		return fmt.Errorf("%w: %v° is beyond %d°", ErrInvalidCoordinate, total, maxDeg)
+	}
+	return nil
+}
+
+// FormatGeoURI writes an RFC 5870 geo URI, geo:44.8373,-0.5792.
+func FormatGeoURI(lat, lon CoordinateDecimal, precision int) string {
+	return "geo:" + lat.FormatDecimal(precision) + "," + lon.FormatDecimal(precision)
+}
+
+// FormatNMEA writes the coordinate the way NMEA 0183 sentences do, degrees
+// and decimal minutes, ddmm.mmmm for latitudes and dddmm.mmmm for longitudes,
+// followed by the hemisphere.
+func FormatNMEA(c CoordinateDecimal, precision int) (value, hemisphere string) {
+	scale := math.Pow(10, float64(precision))
+	minutes := math.Round(math.Abs(c.DecimalDegree)*60*scale) / scale
+	degree := math.Floor(minutes / 60)
+	minutes -= degree * 60
+
+	width := 2
+	if c.CoordType == Longitude {
+		width = 3
+	}
+	minuteWidth := 2
+	if precision > 0 {
+		minuteWidth += precision + 1
+	}
+
+	value = fmt.Sprintf("%0*d%0*.*f", width, int(degree), minuteWidth, precision, minutes)
+	return value, string(directionLetters[c.ToCoordinate().Direction])
+}
+
+// ParseNMEA reads a ddmm.mmmm or dddmm.mmmm value with its N, S, E or W
+// hemisphere field.
+func ParseNMEA(value, hemisphere string) (CoordinateDecimal, error) {
+	input := value + "," + hemisphere
+
+	direction, ok := directionOf(strings.TrimSpace(hemisphere))
+	if !ok {
+		return CoordinateDecimal{}, invalidCoordinate(input, "hemisphere must be N, S, E or W")
+	}
+
+	value = strings.TrimSpace(value)
+	dot := strings.IndexByte(value, '.')
+	if dot == -1 {
+		dot = len(value)
+	}
+	if dot < 3 {
+		return CoordinateDecimal{}, invalidCoordinate(input, "expected degrees followed by two digits of minutes")
+	}
+
+	degree, err := strconv.Atoi(value[:dot-2])
+	if err != nil {
+		return CoordinateDecimal{}, invalidCoordinate(input, "degrees are not a number")
+	}
+	minutes, err := strconv.ParseFloat(value[dot-2:], 64)
+	if err != nil {
+		return CoordinateDecimal{}, invalidCoordinate(input, "minutes are not a number")
+	}
+	if minutes >= 60 {
+		return CoordinateDecimal{}, invalidCoordinate(input, "minutes must be below 60")
+	}
+
+	return decimalFromParts(input, float64(degree)+minutes/60, direction)
+}
+
+// ParseGeoURI reads an RFC 5870 geo URI. The altitude and the uncertainty
+// are ignored, a crs parameter other than wgs84 is refused.
+func ParseGeoURI(uri string) (lat, lon CoordinateDecimal, err error) {
+	trimmed := strings.TrimSpace(uri)
+	if len(trimmed) < 4 || !strings.EqualFold(trimmed[:4], "geo:") {
+		return lat, lon, invalidCoordinate(uri, "missing geo: scheme")
+	}
+
+	params := strings.Split(trimmed[4:], ";")
+	for _, param := range params[1:] {
+		key, value, _ := strings.Cut(param, "=")
+		if strings.EqualFold(key, "crs") && !strings.EqualFold(value, "wgs84") {
+			return lat, lon, invalidCoordinate(uri, "unsupported crs %q", value)
+		}
+	}
+
+	coords := strings.Split(params[0], ",")
+	if len(coords) < 2 || len(coords) > 3 {
+		return lat, lon, invalidCoordinate(uri, "expected lat,lon[,alt]")
+	}
+
+	latDeg, err1 := strconv.ParseFloat(coords[0], 64)
+	lonDeg, err2 := strconv.ParseFloat(coords[1], 64)
+	if err1 != nil || err2 != nil {
+		return lat, lon, invalidCoordinate(uri, "coordinates are not numbers")
+	}
+	return checkedPosition(uri, latDeg, lonDeg)
+}
+
+// ParsePosition reads a latitude and longitude written by a human:
+//
+//	44°50'14.2"N 0°34'45"W
+//	N 44 50.237 W 0 34.75
+//	44.8373,-0.5792
+//	geo:44.8373,-0.5792
+//	4450.2367,N,00034.7500,W
+//
+// Without hemisphere letters the latitude comes first. With them the two
+// can be given in any order.
+func ParsePosition(s string) (lat, lon CoordinateDecimal, err error) {
+	input := strings.TrimSpace(s)
+	if len(input) >= 4 && strings.EqualFold(input[:4], "geo:") {
+		return ParseGeoURI(input)
+	}
+
+	normalized := normalizeCoordinate(input)
+
+	if fields := strings.Split(normalized, ","); len(fields) == 4 {
+		first, err := ParseNMEA(fields[0], fields[1])
+		if err != nil {
+			return lat, lon, err
+		}
+		second, err := ParseNMEA(fields[2], fields[3])
+		if err != nil {
+			return lat, lon, err
+		}
+		return orderPosition(input, first, second)
+	}
+
+	parts, err := splitPosition(input, normalized)
+	if err != nil {
+		return lat, lon, err
+	}
+
+	first, firstDir, err := parseCoordinatePart(input, parts[0])
+	if err != nil {
+		return lat, lon, err
+	}
+	second, secondDir, err := parseCoordinatePart(input, parts[1])
+	if err != nil {
+		return lat, lon, err
+	}
+
+	if firstDir == -1 && secondDir == -1 {
+		return checkedPosition(input, first, second)
+	}
+	if firstDir == -1 || secondDir == -1 {
+		return lat, lon, invalidCoordinate(input, "hemisphere given for only one coordinate")
+	}
+
+	a, err := decimalFromParts(input, first, firstDir)
+	if err != nil {
+		return lat, lon, err
+	}
+	b, err := decimalFromParts(input, second, secondDir)
+	if err != nil {
+		return lat, lon, err
+	}
+	return orderPosition(input, a, b)
+}
+
+// ParseCoordinate reads a single coordinate with its hemisphere, such as
+// 44°50'14.2"N or W 0.5792. Without a hemisphere the type is unknown and
+// an error is returned.
+func ParseCoordinate(s string) (CoordinateDecimal, error) {
+	value, direction, err := parseCoordinatePart(s, normalizeCoordinate(strings.TrimSpace(s)))
+	if err != nil {
+		return CoordinateDecimal{}, err
+	}
+	if direction == -1 {
+		return CoordinateDecimal{}, invalidCoordinate(s, "missing hemisphere N, S, E or W")
+	}
+	return decimalFromParts(s, value, direction)
+}
+
+func normalizeCoordinate(s string) string {
+	return strings.NewReplacer("º", "°", "′", "'", "’", "'", "″", "\"", "”", "\"", "''", "\"").Replace(strings.ToUpper(s))
+}
+
+func directionOf(letter string) (int, bool) {
+	switch letter {
+	case "N", "n":
+		return North, true
+	case "S", "s":
+		return South, true
+	case "E", "e":
+		return East, true
+	case "W", "w":
+		return West, true
+	}
+	return 0, false
+}
+
+// splitPosition cuts a normalized pair in two, on the comma if there is one,
+// else around the hemisphere letters, else on the whitespace.
+func splitPosition(input, s string) ([2]string, error) {
+	if first, second, found := strings.Cut(s, ","); found {
+		if strings.Contains(second, ",") {
+			return [2]string{}, invalidCoordinate(input, "too many commas")
+		}
+		return [2]string{first, second}, nil
+	}
+
+	letters := []int{}
+	for i := 0; i < len(s); i++ {
+		if strings.IndexByte("NSEW", s[i]) != -1 {
+			letters = append(letters, i)
+		}
+	}
+
+	switch {
+	case len(letters) == 2 && letters[0] == 0:
+		// N 44 50 W 0 34
+		return [2]string{s[:letters[1]], s[letters[1]:]}, nil
+	case len(letters) == 2:
+		// 44 50 N 0 34 W
+		return [2]string{s[:letters[0]+1], s[letters[0]+1:]}, nil
+	case len(letters) == 0:
+		fields := strings.Fields(s)
+		if len(fields) == 2 {
+			return [2]string{fields[0], fields[1]}, nil
+		}
+	}
+	return [2]string{}, invalidCoordinate(input, "expected two coordinates")
+}
+
+var dmsPattern = regexp.MustCompile(`^(\d+(?:\.\d+)?)(?:\s*°\s*|\s+)?(?:(\d+(?:\.\d+)?)(?:\s*'\s*|\s+)?)?(?:(\d+(?:\.\d+)?)\s*"?)?$`)
+
+// parseCoordinatePart reads degrees with optional minutes and seconds, and
+// a sign or a hemisphere letter in front or behind. direction is -1 when no
+// letter was given, the value is then signed.
+func parseCoordinatePart(input, part string) (value float64, direction int, err error) {
+	part = strings.TrimSpace(part)
+	direction = -1
+
+	if part != "" {
+		if dir, ok := directionOf(part[:1]); ok {
+			direction, part = dir, strings.TrimSpace(part[1:])
+		} else if dir, ok := directionOf(part[len(part)-1:]); ok {
+			direction, part = dir, strings.TrimSpace(part[:len(part)-1])
+		}
+	}
+
+	sign := 1.0
+	if strings.HasPrefix(part, "-") || strings.HasPrefix(part, "+") {
+		if direction != -1 {
+			return 0, 0, invalidCoordinate(input, "both a sign and a hemisphere in %q", part)
+		}
+		if part[0] == '-' {
+			sign = -1
+		}
+		part = strings.TrimSpace(part[1:])
+	}
+
+	match := dmsPattern.FindStringSubmatch(part)
+	if match == nil {
+		return 0, 0, invalidCoordinate(input, "cannot read %q as degrees, minutes and seconds", part)
+	}
+
+	degrees, _ := strconv.ParseFloat(match[1], 64)
+	minutes, seconds := 0.0, 0.0
+	if match[2] != "" {
+		if strings.Contains(match[1], ".") {
+			return 0, 0, invalidCoordinate(input, "fractional degrees followed by minutes")
+		}
+		minutes, _ = strconv.ParseFloat(match[2], 64)
+	}
+	if match[3] != "" {
+		if strings.Contains(match[2], ".") {
+			return 0, 0, invalidCoordinate(input, "fractional minutes followed by seconds")
+		}
+		seconds, _ = strconv.ParseFloat(match[3], 64)
+	}
+	if minutes >= 60 || seconds >= 60 {
+		return 0, 0, invalidCoordinate(input, "minutes and seconds must be below 60")
+	}
+
+	return sign * (degrees + minutes/60 + seconds/3600), direction, nil
+}
+
+func decimalFromParts(input string, degrees float64, direction int) (CoordinateDecimal, error) {
+	coordType, limit := Latitude, 90.0
+	if direction == East || direction == West {
+		coordType, limit = Longitude, 180
+	}
+	if degrees > limit {
+		return CoordinateDecimal{}, invalidCoordinate(input, "%v° is beyond %v°", degrees, limit)
+	}
+	if direction == South || direction == West {
+		degrees = -degrees
+	}
+	return MakeCoordinateDecimal(degrees, coordType), nil
+}
+
+func checkedPosition(input string, latDeg, lonDeg float64) (lat, lon CoordinateDecimal, err error) {
+	if math.Abs(latDeg) > 90 {
+		return lat, lon, invalidCoordinate(input, "latitude %v beyond 90°", latDeg)
+	}
+	if math.Abs(lonDeg) > 180 {
+		return lat, lon, invalidCoordinate(input, "longitude %v beyond 180°", lonDeg)
+	}
+	return MakeCoordinateDecimal(latDeg, Latitude), MakeCoordinateDecimal(lonDeg, Longitude), nil
+}
+
+func orderPosition(input string, a, b CoordinateDecimal) (lat, lon CoordinateDecimal, err error) {
+	if a.CoordType == b.CoordType {
+		return lat, lon, invalidCoordinate(input, "expected one latitude and one longitude")
+	}
+	if a.CoordType == Longitude {
+		a, b = b, a
+	}
+	return a, b, nil
+}
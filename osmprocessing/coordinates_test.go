@@ -0,0 +1,171 @@
+package osmprocessing
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestParsePosition(t *testing.T) {
+	const bordeauxLat, bordeauxLon = 44.8372777777778, -0.5791666666667
+
+	tests := []struct {
+		name     string
+		input    string
+		lat, lon float64
+	}{
+		{"dms", `44°50'14.2"N 0°34'45"W`, bordeauxLat, bordeauxLon},
+		{"dms with spaces and comma", `44° 50' 14.2" N, 0° 34' 45" W`, bordeauxLat, bordeauxLon},
+		{"typographic marks", `44°50′14.2″N 0°34′45″W`, bordeauxLat, bordeauxLon},
+		{"hemisphere first", `N 44 50 14.2 W 0 34 45`, bordeauxLat, bordeauxLon},
+		{"longitude first", `0°34'45"W 44°50'14.2"N`, bordeauxLat, bordeauxLon},
+		{"decimal minutes", `N44 50.2367 W0 34.75`, 44 + 50.2367/60, -(34.75 / 60)},
+		{"lower case", `44.8373n 0.5792w`, 44.8373, -0.5792},
+		{"decimal", `44.8373,-0.5792`, 44.8373, -0.5792},
+		{"decimal with spaces", ` 44.8373  -0.5792 `, 44.8373, -0.5792},
+		{"geo uri", `geo:44.8373,-0.5792`, 44.8373, -0.5792},
+		{"geo uri with altitude and parameters", `GEO:44.8373,-0.5792,12;crs=wgs84;u=35`, 44.8373, -0.5792},
+		{"nmea", `4450.2367,N,00034.7500,W`, 44 + 50.2367/60, -(34.75 / 60)},
+		{"southern hemisphere", `33°52'7.68"S 151°12'33.48"E`, -(33 + 52/60.0 + 7.68/3600), 151 + 12/60.0 + 33.48/3600},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lat, lon, err := ParsePosition(tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if lat.CoordType != Latitude || lon.CoordType != Longitude {
+				t.Fatalf("got types %d,%d", lat.CoordType, lon.CoordType)
+			}
+			if math.Abs(lat.DecimalDegree-tt.lat) > 1e-9 || math.Abs(lon.DecimalDegree-tt.lon) > 1e-9 {
+				t.Errorf("got %.10f,%.10f want %.10f,%.10f", lat.DecimalDegree, lon.DecimalDegree, tt.lat, tt.lon)
+			}
+		})
+	}
+}
+
+func TestParsePositionErrors(t *testing.T) {
+	inputs := []string{
+		``,
+		`44.8373`,
+		`91,0`,
+		`0,181`,
+		`44°61'0"N 0°34'45"W`,
+		`44°50'60"N 0°34'45"W`,
+		`44.5°30'N 0°34'45"W`,
+		`44°50'N 0.5792`,
+		`44°50'N 1°0'S`,
+		`-44°50'N 0°34'W`,
+		`44;0`,
+		`1,2,3`,
+		`geo:44.8,abc`,
+		`geo:44.8,-0.5;crs=epsg2154`,
+		`4450.2367,X,00034.7500,W`,
+		`4460.0000,N,00034.7500,W`,
+	}
+
+	for _, input := range inputs {
+		if lat, lon, err := ParsePosition(input); !errors.Is(err, ErrInvalidCoordinate) {
+			t.Errorf("%q: expected ErrInvalidCoordinate, got %v,%v %v", input, lat, lon, err)
+		}
+	}
+}
+
+func TestParseCoordinate(t *testing.T) {
+	c, err := ParseCoordinate(`W 0°34'45.5"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.CoordType != Longitude || math.Abs(c.DecimalDegree+(34/60.0+45.5/3600)) > 1e-12 {
+		t.Errorf("got %v %v", c.DecimalDegree, c.CoordType)
+	}
+
+	if _, err := ParseCoordinate(`44.8`); !errors.Is(err, ErrInvalidCoordinate) {
+		t.Errorf("expected an error without hemisphere, got %v", err)
+	}
+}
+
+func TestFormatCoordinate(t *testing.T) {
+	lat := MakeCoordinateDecimal(44+50/60.0+14.2/3600, Latitude)
+	lon := MakeCoordinateDecimal(-(34/60.0 + 45/3600.0), Longitude)
+
+	tests := []struct {
+		got, want string
+	}{
+		{lat.Format(0), `44°50'14" N`},
+		{lat.Format(2), `44°50'14.20" N`},
+		{lon.Format(1), `0°34'45.0" W`},
+		{lon.String(), `0°34'45" W`},
+		{MakeCoordinateDecimal(-(10 + 59/60.0 + 59.9994/3600), Latitude).Format(3), `10°59'59.999" S`},
+		{MakeCoordinateDecimal(10+59/60.0+59.9996/3600, Longitude).Format(2), `11°0'0.00" E`},
+		{lat.FormatDecimal(4), `44.8373`},
+		{FormatGeoURI(lat, lon, 5), `geo:44.83728,-0.57917`},
+	}
+
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("got %s want %s", tt.got, tt.want)
+		}
+	}
+
+	value, hemisphere := FormatNMEA(lon, 4)
+	if value != "00034.7500" || hemisphere != "W" {
+		t.Errorf("got %s,%s want 00034.7500,W", value, hemisphere)
+	}
+	value, hemisphere = FormatNMEA(lat, 4)
+	if value != "4450.2367" || hemisphere != "N" {
+		t.Errorf("got %s,%s want 4450.2367,N", value, hemisphere)
+	}
+}
+
+func TestFormatParseRoundTrip(t *testing.T) {
+	for _, deg := range []float64{0, 1e-7, 12.3456789, -45.0000001, 89.9999999} {
+		lat := MakeCoordinateDecimal(deg, Latitude)
+		lon := MakeCoordinateDecimal(deg*2, Longitude)
+
+		gotLat, gotLon, err := ParsePosition(lat.Format(4) + " " + lon.Format(4))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 1e-4 seconds is 3mm
+		if math.Abs(gotLat.DecimalDegree-deg) > 1e-4/3600 || math.Abs(gotLon.DecimalDegree-deg*2) > 1e-4/3600 {
+			t.Errorf("%v came back as %v,%v", deg, gotLat.DecimalDegree, gotLon.DecimalDegree)
+		}
+
+		value, hemisphere := FormatNMEA(lat, 6)
+		nmea, err := ParseNMEA(value, hemisphere)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(nmea.DecimalDegree-deg) > 1e-6/60 {
+			t.Errorf("nmea %s,%s came back as %v", value, hemisphere, nmea.DecimalDegree)
+		}
+	}
+}
+
+func TestCoordinateValidate(t *testing.T) {
+	valid := []Coordinate{
+		{Deg: 44, Minute: 50, Second: 14.2, Direction: North},
+		{Deg: 180, Direction: West},
+	}
+	for _, c := range valid {
+		if err := c.Validate(); err != nil {
+			t.Errorf("%+v: %v", c, err)
+		}
+	}
+
+	invalid := []Coordinate{
+		{Deg: 91, Direction: North},
+		{Deg: 90, Second: 0.1, Direction: South},
+		{Deg: 10, Minute: 60, Direction: East},
+		{Deg: 10, Second: 60, Direction: East},
+		{Deg: -1, Direction: East},
+		{Deg: 10, Direction: 42},
+	}
+	for _, c := range invalid {
+		if err := c.Validate(); !errors.Is(err, ErrInvalidCoordinate) {
+			t.Errorf("%+v: expected ErrInvalidCoordinate, got %v", c, err)
+		}
+	}
+}
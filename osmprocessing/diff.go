@@ -0,0 +1,307 @@
+package osmprocessing
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+
+	"github.com/paulmach/osm"
+)
+
+// DiffOption configures DiffMaps.
+type DiffOption func(*diffOptions)
+
+type diffOptions struct {
+	sampleStep    float64 // metres
+	matchDistance float64 // metres
+}
+
+// WithDiffSampleStep sets how far apart, in metres, the points along a way
+// compared with the other map are, 5m by default. A change shorter than the
+// step may go unseen, a shorter step costs a search for every point. It
+// panics on a step that is not positive and finite.
+func WithDiffSampleStep(metres float64) DiffOption {
+	if !isPositive(metres) {
+		panic(fmt.Sprintf("diff sample step of %vm", metres))
+	}
+	return func(o *diffOptions) { o.sampleStep = metres }
+}
+
+// WithDiffMatchDistance sets how close, in metres, half of a way must lie
+// to the other map for it to be changed rather than added or removed, 20m
+// by default, about the width of a street with its pavements. A road moved
+// further is taken for another road. The tolerance of DiffMaps is the
+// least it can be. It panics on a distance that is not positive and finite.
+func WithDiffMatchDistance(metres float64) DiffOption {
+	if !isPositive(metres) {
+		panic(fmt.Sprintf("diff match distance of %vm", metres))
+	}
+	return func(o *diffOptions) { o.matchDistance = metres }
+}
+
+type DiffStatus string
+
+const (
+	DiffAdded   DiffStatus = "added"
+	DiffRemoved DiffStatus = "removed"
+	DiffChanged DiffStatus = "changed"
+)
+
+// WayDiff is a way of one of the two maps that the other map lacks, in
+// part or whole.
+type WayDiff struct {
+	Status DiffStatus `json:"status"`
+	// Side is "before" for a way of the old map, "after" for the new one
+	Side string    `json:"side"`
+	ID   osm.WayID `json:"id"`
+	// Matched are the ways of the other map nearest the parts of the way
+	// that differ from it
+	Matched []osm.WayID `json:"matched,omitempty"`
+	Highway string      `json:"highway"`
+	Name    string      `json:"name,omitempty"`
+	Length  float64     `json:"length"` // metres
+	// Covered is the share of the way within tolerance of the other map
+	Covered float64 `json:"covered"`
+	// Deviation is how far, in metres, the matched part strays at most
+	Deviation float64  `json:"deviation"`
+	Geometry  []LatLon `json:"-"`
+}
+
+// MapDiff is what changed on the roads from one map to the next.
+type MapDiff struct {
+	Added   []*WayDiff `json:"added"`
+	Removed []*WayDiff `json:"removed"`
+	Changed []*WayDiff `json:"changed"`
+	// Unchanged counts the ways of the new map found in the old one
+	Unchanged int `json:"unchanged"`
+	// LengthDelta is the length of road gained, in metres, per highway
+	// class, negative for a loss
+	LengthDelta map[string]float64 `json:"length_delta"`
+}
+
+// DiffMaps compares the roads of two versions of a map. Ways are matched
+// by geometry, not by ID, since splitting ways numbers them again: a way
+// lying within tolerance metres of the other map all along is unchanged,
+// whatever its ID or however the other map cuts it. A way of which less
+// than half lies within the match distance of the other map is added or
+// removed, any other is changed.
+//
+// Changed ways are reported from the new map, with the old ways they
+// replace in Matched. A way of the old map is only reported as changed
+// when it differs from more than the changed new ways, when a road was
+// shortened for instance.
+func DiffMaps(before, after *Map, tolerance float64, opts ...DiffOption) *MapDiff {
+	o := diffOptions{sampleStep: 5, matchDistance: 20}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	o.matchDistance = math.Max(o.matchDistance, tolerance)
+
+	d := &MapDiff{LengthDelta: make(map[string]float64)}
+	beforeTree, afterTree := before.BuildSegmentRTree(), after.BuildSegmentRTree()
+
+	changed := make(map[osm.WayID]bool)
+	for _, way := range after.Ways {
+		wd := diffWay(way, after.Nodes, beforeTree, tolerance, o)
+		d.LengthDelta[highwayClass(way)] += GetWayLength(way, after.Nodes)
+
+		switch {
+		case wd == nil:
+			d.Unchanged++
+		case wd.Status == DiffChanged:
+			changed[way.ID] = true
+			fallthrough
+		default:
+			wd.Side = "after"
+			d.add(wd)
+		}
+	}
+
+	for _, way := range before.Ways {
+		wd := diffWay(way, before.Nodes, afterTree, tolerance, o)
+		d.LengthDelta[highwayClass(way)] -= GetWayLength(way, before.Nodes)
+
+		if wd == nil || wd.Status == DiffChanged && len(wd.Matched) > 0 &&
+			!slices.ContainsFunc(wd.Matched, func(id osm.WayID) bool { return !changed[id] }) {
+			continue
+		}
+		if wd.Status == DiffAdded {
+			wd.Status = DiffRemoved
+		}
+		wd.Side = "before"
+		d.add(wd)
+	}
+	return d
+}
+
+func (d *MapDiff) add(wd *WayDiff) {
+	switch wd.Status {
+	case DiffAdded:
+		d.Added = append(d.Added, wd)
+	case DiffRemoved:
+		d.Removed = append(d.Removed, wd)
+	default:
+		d.Changed = append(d.Changed, wd)
+	}
+}
+
+func highwayClass(way *osm.Way) string {
+	if class := way.Tags.Find("highway"); class != "" {
+		return class
+	}
+	return "none"
+}
+
+// diffWay measures the way against the ways of the other map, it returns
+// nil for a way the other map has too, and DiffAdded for one it mostly
+// lacks.
+func diffWay(way *osm.Way, nodes map[osm.NodeID]*osm.Node, other *SegmentRTree, tolerance float64, o diffOptions) *WayDiff {
+	geometry := wayGeometry(way, nodes)
+	samples := sampleLine(geometry, o.sampleStep)
+	if len(samples) == 0 {
+		return nil
+	}
+
+	wd := &WayDiff{
+		Status:   DiffChanged,
+		ID:       way.ID,
+		Highway:  way.Tags.Find("highway"),
+		Name:     way.Tags.Find("name"),
+		Length:   GetWayLength(way, nodes),
+		Geometry: geometry,
+	}
+
+	// at a junction the nearest way may cross this one, the part of the
+	// way lying along this one is the first running alongside
+	covered, near := 0, 0
+	for _, p := range samples {
+		matches := other.WaysWithin(p.Lat, p.Lon, o.matchDistance)
+		isCovered := len(matches) > 0 && matches[0].Distance <= tolerance
+		if isCovered {
+			covered++
+		}
+
+		i := slices.IndexFunc(matches, func(m WayMatch) bool {
+			turn := math.Abs(BearingDifference(p.Bearing, m.Bearing))
+			return turn <= 45 || turn >= 135
+		})
+		if i < 0 {
+			continue
+		}
+		near++
+		wd.Deviation = math.Max(wd.Deviation, matches[i].Distance)
+		if !isCovered && !slices.Contains(wd.Matched, matches[i].Way.ID) {
+			wd.Matched = append(wd.Matched, matches[i].Way.ID)
+		}
+	}
+	wd.Covered = float64(covered) / float64(len(samples))
+
+	switch {
+	case covered == len(samples):
+		return nil
+	case 2*near < len(samples):
+		wd.Status = DiffAdded
+	}
+	slices.Sort(wd.Matched)
+	return wd
+}
+
+func wayGeometry(way *osm.Way, nodes map[osm.NodeID]*osm.Node) []LatLon {
+	var line []LatLon
+	for _, wn := range way.Nodes {
+		if node, ok := nodes[wn.ID]; ok {
+			line = append(line, LatLon{node.Lat, node.Lon})
+		}
+	}
+	return line
+}
+
+type lineSample struct {
+	LatLon
+	Bearing float64 // of the line there
+}
+
+// sampleLine returns the points of the line and points between them, no
+// more than step metres apart.
+func sampleLine(line []LatLon, step float64) []lineSample {
+	var samples []lineSample
+	for i := 1; i < len(line); i++ {
+		a, b := line[i-1], line[i]
+		bearing := CalculateBearing(a.Lat, a.Lon, b.Lat, b.Lon)
+		if i == 1 {
+			samples = append(samples, lineSample{a, bearing})
+		}
+		n := int(math.Ceil(HaversineDistance(a.Lat, a.Lon, b.Lat, b.Lon) / step))
+		for k := 1; k <= n; k++ {
+			f := float64(k) / float64(n)
+			samples = append(samples, lineSample{LatLon{a.Lat + f*(b.Lat-a.Lat), a.Lon + f*(b.Lon-a.Lon)}, bearing})
+		}
+	}
+	return samples
+}
+
+func (d *MapDiff) WriteJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(d)
+}
+
+type geoJSONFeature struct {
+	Type       string          `json:"type"`
+	Geometry   geoJSONGeometry `json:"geometry"`
+	Properties *WayDiff        `json:"properties"`
+}
+
+type geoJSONGeometry struct {
+	Type        string       `json:"type"`
+	Coordinates [][2]float64 `json:"coordinates"`
+}
+
+// WriteGeoJSON writes the ways of the diff as a FeatureCollection of
+// LineStrings, to lay over a map of either version. Their properties are
+// those of the JSON report.
+func (d *MapDiff) WriteGeoJSON(w io.Writer) error {
+	collection := struct {
+		Type     string           `json:"type"`
+		Features []geoJSONFeature `json:"features"`
+	}{Type: "FeatureCollection", Features: []geoJSONFeature{}}
+
+	for _, wd := range slices.Concat(d.Added, d.Removed, d.Changed) {
+		coords := make([][2]float64, len(wd.Geometry))
+		for i, p := range wd.Geometry {
+			coords[i] = [2]float64{p.Lon, p.Lat}
+		}
+		collection.Features = append(collection.Features, geoJSONFeature{
+			Type:       "Feature",
+			Geometry:   geoJSONGeometry{Type: "LineString", Coordinates: coords},
+			Properties: wd,
+		})
+	}
+	return json.NewEncoder(w).Encode(collection)
+}
+
+// Save writes the JSON report to fname.json and the overlay to
+// fname.geojson.
+func (d *MapDiff) Save(fname string) error {
+	for _, out := range []struct {
+		ext   string
+		write func(io.Writer) error
+	}{{"json", d.WriteJSON}, {"geojson", d.WriteGeoJSON}} {
+		outputFileName := fmt.Sprintf("%s.%s", fname, out.ext)
+		file, err := os.Create(outputFileName)
+		if err != nil {
+			return fmt.Errorf("failed to create %q %w", outputFileName, err)
+		}
+		if err := out.write(file); err != nil {
+			file.Close()
+			return fmt.Errorf("failed to write %q %w", outputFileName, err)
+		}
+		if err := file.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package osmprocessing
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/paulmach/osm"
+)
+
+func TestDiffMaps(t *testing.T) {
+	lat0, lon0 := ToDecimalCoord(44, 50, 0, North), ToDecimalCoord(0, 35, 0, West)
+	before, _ := GenerateMap(3, 3, 200, lat0, lon0)
+	after, grid := GenerateMap(3, 3, 200, lat0, lon0)
+
+	// every way numbered again, the first two of row 0 as a single way
+	ways := after.Ways
+	after.Ways = nil
+	for _, way := range ways {
+		way.ID += 100
+		switch {
+		case way.ID == 101:
+			way.Nodes = append(way.Nodes, ways[1].Nodes[1])
+		case way.ID == 102:
+			continue
+		// row 3 loses its first block
+		case way.Nodes[0].ID == grid["3,0"] && way.Nodes[1].ID == grid["3,1"]:
+			continue
+		}
+		after.Ways = append(after.Ways, way)
+	}
+
+	// a crossing moved 8m north bends the street, the avenue through it
+	// stays where it was
+	moved := after.Nodes[grid["2,2"]]
+	moved.Lat, moved.Lon = DestinationPoint(moved.Lat, moved.Lon, 0, 8)
+
+	// a driveway in the middle of a block
+	corner := after.Nodes[grid["0,0"]]
+	lat1, lon1 := DestinationPoint(corner.Lat, corner.Lon, 45, 100)
+	lat2, lon2 := DestinationPoint(corner.Lat, corner.Lon, 45, 180)
+	after.Nodes[1000] = &osm.Node{ID: 1000, Lat: lat1, Lon: lon1}
+	after.Nodes[1001] = &osm.Node{ID: 1001, Lat: lat2, Lon: lon2}
+	after.Ways = append(after.Ways, &osm.Way{ID: 500, Tags: osm.Tags{{Key: "highway", Value: "service"}},
+		Nodes: osm.WayNodes{{ID: 1000}, {ID: 1001}}})
+
+	d := DiffMaps(before, after, 1)
+
+	if len(d.Added) != 1 || d.Added[0].ID != 500 || d.Added[0].Side != "after" {
+		t.Errorf("added %+v", d.Added)
+	}
+	if len(d.Removed) != 1 || d.Removed[0].Side != "before" || d.Removed[0].Name != "Street 3" {
+		t.Errorf("removed %+v", d.Removed)
+	}
+	if len(d.Changed) != 2 {
+		t.Fatalf("changed %d ways", len(d.Changed))
+	}
+	for _, wd := range d.Changed {
+		if wd.Side != "after" || len(wd.Matched) == 0 || wd.Name != "Street 2" || wd.Deviation < 7.5 || wd.Deviation > 8.5 || wd.Covered >= 0.5 {
+			t.Errorf("changed %+v", wd)
+		}
+	}
+	if d.Unchanged != len(after.Ways)-1-2 {
+		t.Errorf("%d ways unchanged", d.Unchanged)
+	}
+
+	if got := d.LengthDelta["service"]; math.Abs(got-80) > 0.5 {
+		t.Errorf("%.2fm of service road", got)
+	}
+	// the lost block, and the bent street a little longer
+	if got := d.LengthDelta["residential"]; got > -199 || got < -200 {
+		t.Errorf("%.2fm of residential road", got)
+	}
+}
+
+func TestDiffOptions(t *testing.T) {
+	lat0, lon0 := ToDecimalCoord(44, 50, 0, North), ToDecimalCoord(0, 35, 0, West)
+	before, _ := GenerateMap(1, 1, 200, lat0, lon0)
+	after, _ := GenerateMap(1, 1, 200, lat0, lon0)
+
+	// the whole block 30m further north
+	for _, node := range after.Nodes {
+		node.Lat, node.Lon = DestinationPoint(node.Lat, node.Lon, 0, 30)
+	}
+
+	// the avenues moved along themselves still lie on the old ones, the
+	// streets are 30m off
+	if d := DiffMaps(before, after, 1); len(d.Added) != 2 || len(d.Removed) != 2 {
+		t.Errorf("within 20m: %d added, %d removed", len(d.Added), len(d.Removed))
+	}
+	if d := DiffMaps(before, after, 1, WithDiffMatchDistance(40)); len(d.Added) != 0 || len(d.Removed) != 0 || len(d.Changed) != 4 {
+		t.Errorf("within 40m: %d added, %d removed, %d changed", len(d.Added), len(d.Removed), len(d.Changed))
+	}
+	// the tolerance widens the match distance
+	if d := DiffMaps(before, after, 35, WithDiffSampleStep(50)); len(d.Added)+len(d.Removed)+len(d.Changed) != 0 {
+		t.Errorf("within a tolerance of 35m: %+v", d)
+	}
+
+	for _, opt := range []func(){
+		func() { WithDiffSampleStep(0) },
+		func() { WithDiffMatchDistance(math.Inf(1)) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Error("no panic on an invalid option")
+				}
+			}()
+			opt()
+		}()
+	}
+}
+
+func TestDiffGeoJSON(t *testing.T) {
+	before, _ := GenerateMap(1, 2, 200, ToDecimalCoord(44, 50, 0, North), ToDecimalCoord(0, 35, 0, West))
+	after := &Map{Nodes: before.Nodes, Ways: before.Ways[1:]}
+
+	d := DiffMaps(before, after, 1)
+	var buf bytes.Buffer
+	if err := d.WriteGeoJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var collection struct {
+		Type     string
+		Features []struct {
+			Geometry struct {
+				Type        string
+				Coordinates [][2]float64
+			}
+			Properties struct {
+				Status DiffStatus
+				ID     osm.WayID
+			}
+		}
+	}
+	if err := json.Unmarshal(buf.Bytes(), &collection); err != nil {
+		t.Fatal(err)
+	}
+	if collection.Type != "FeatureCollection" || len(collection.Features) != 1 {
+		t.Fatalf("got %s", buf.String())
+	}
+
+	f := collection.Features[0]
+	node := before.Nodes[before.Ways[0].Nodes[0].ID]
+	if f.Properties.Status != DiffRemoved || f.Properties.ID != before.Ways[0].ID || f.Geometry.Type != "LineString" ||
+		f.Geometry.Coordinates[0] != [2]float64{node.Lon, node.Lat} {
+		t.Errorf("got %+v", f)
+	}
+}
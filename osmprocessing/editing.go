@@ -0,0 +1,632 @@
+package osmprocessing
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+
+	"github.com/paulmach/osm"
+)
+
+// The editing methods change the map in place and keep WaysByID,
+// NodeToWays, the spatial index, the junctions, the street names, the
+// bounds and the landmarks and buildings with their indexes up to date.
+// They replace an edited node, way, landmark or building with a copy rather
+// than changing it, but they must not run concurrently with queries on the
+// map. After an edit the ways are looked up in the grid rather than an
+// R-tree way index until RebuildWayIndex, and a likelihood field is
+// dropped, BuildLikelihoodField makes a new one.
+
+var (
+	ErrUnknownNode = errors.New("unknown node")
+	ErrUnknownWay  = errors.New("unknown way")
+)
+
+// EditAction is what an edit did to a node or a way, as in osmChange.
+type EditAction int
+
+const (
+	EditCreate EditAction = iota
+	EditModify
+	EditDelete
+)
+
+func (a EditAction) String() string {
+	switch a {
+	case EditCreate:
+		return "create"
+	case EditModify:
+		return "modify"
+	case EditDelete:
+		return "delete"
+	}
+	return "unknown"
+}
+
+// MapEdit is an entry of the edit log, Node or Way is the element after
+// the edit, or as it was before its deletion.
+type MapEdit struct {
+	Action EditAction
+	Node   *osm.Node
+	Way    *osm.Way
+}
+
+// Edits returns the edit log, oldest first.
+func (em *EnhancedMap) Edits() []MapEdit {
+	return slices.Clone(em.edits)
+}
+
+// AddNode creates a node with a new negative ID, as editors number the
+// elements they create.
+func (em *EnhancedMap) AddNode(lat, lon float64, tags osm.Tags) *osm.Node {
+	if em.nextNodeID == 0 {
+		em.nextNodeID = -1
+		for id := range em.Nodes {
+			em.nextNodeID = min(em.nextNodeID, id-1)
+		}
+	}
+	node := &osm.Node{ID: em.nextNodeID, Lat: lat, Lon: lon, Tags: tags, Visible: true}
+	em.nextNodeID--
+
+	if em.Nodes == nil {
+		em.Nodes = make(map[osm.NodeID]*osm.Node)
+	}
+	em.Nodes[node.ID] = node
+	em.SpatialIndex.InsertNode(node)
+	em.updateBounds(nil, node)
+
+	em.edited(EditCreate, node, nil)
+	return node
+}
+
+// MoveNode puts the node at a new position, with the ways through it, the
+// landmark it is and the building corners at its old position, as
+// buildings keep positions rather than nodes.
+func (em *EnhancedMap) MoveNode(id osm.NodeID, lat, lon float64) error {
+	old, ok := em.Nodes[id]
+	if !ok {
+		return fmt.Errorf("failed to move node %d %w", id, ErrUnknownNode)
+	}
+	node := *old
+	node.Lat, node.Lon = lat, lon
+
+	em.Nodes[id] = &node
+	em.SpatialIndex.UpdateNode(&node)
+	for _, way := range em.NodeToWays[id] {
+		em.SpatialIndex.UpdateWay(way, em.Nodes)
+	}
+	em.updateBounds(old, &node)
+
+	for i, lm := range em.Landmarks {
+		if lm.ID == id {
+			moved := *lm
+			moved.Lat, moved.Lon = lat, lon
+			em.replaceLandmark(i, &moved)
+		}
+	}
+	from, to := LatLon{old.Lat, old.Lon}, LatLon{lat, lon}
+	for i, b := range em.Buildings {
+		if moved, ok := moveCorners(b, from, to); ok {
+			em.replaceBuilding(i, moved)
+		}
+	}
+
+	// the arms of the neighbours turn too
+	affected := []osm.NodeID{id}
+	for _, way := range em.NodeToWays[id] {
+		for i, wn := range way.Nodes {
+			if wn.ID != id {
+				continue
+			}
+			if i > 0 {
+				affected = append(affected, way.Nodes[i-1].ID)
+			}
+			if i < len(way.Nodes)-1 {
+				affected = append(affected, way.Nodes[i+1].ID)
+			}
+		}
+	}
+	em.updateJunctions(affected)
+
+	em.edited(EditModify, &node, nil)
+	return nil
+}
+
+// RemoveNode deletes the node and takes it out of its ways. A closed way
+// stays closed, a way left with fewer than two nodes is deleted, and so
+// are the turn restrictions through the node.
+func (em *EnhancedMap) RemoveNode(id osm.NodeID) error {
+	node, ok := em.Nodes[id]
+	if !ok {
+		return fmt.Errorf("failed to remove node %d %w", id, ErrUnknownNode)
+	}
+
+	for _, way := range slices.Clone(em.NodeToWays[id]) {
+		if em.WaysByID[way.ID] != way {
+			continue // seen twice through a closed way
+		}
+
+		edited := cloneWay(way)
+		edited.Nodes = edited.Nodes[:0]
+		for _, wn := range way.Nodes {
+			if wn.ID != id && (len(edited.Nodes) == 0 || edited.Nodes[len(edited.Nodes)-1].ID != wn.ID) {
+				edited.Nodes = append(edited.Nodes, wn)
+			}
+		}
+		closed := len(way.Nodes) > 2 && way.Nodes[0].ID == way.Nodes[len(way.Nodes)-1].ID
+		if n := len(edited.Nodes); closed && n >= 2 && edited.Nodes[0].ID != edited.Nodes[n-1].ID {
+			edited.Nodes = append(edited.Nodes, edited.Nodes[0])
+		}
+
+		if len(edited.Nodes) < 2 {
+			em.removeWay(way)
+			em.edited(EditDelete, nil, way)
+		} else {
+			em.replaceWay(way, edited)
+			em.edited(EditModify, nil, edited)
+		}
+	}
+
+	delete(em.Nodes, id)
+	delete(em.NodeToWays, id)
+	em.SpatialIndex.RemoveNode(id)
+	delete(em.Junctions, id)
+	em.updateBounds(node, nil)
+
+	em.dropRestrictions(func(r *TurnRestriction) bool { return r.Via == id })
+	em.edited(EditDelete, node, nil)
+	return nil
+}
+
+// AddWay creates a way with a new negative ID through existing nodes.
+func (em *EnhancedMap) AddWay(nodeIDs []osm.NodeID, tags osm.Tags) (*osm.Way, error) {
+	if len(nodeIDs) < 2 {
+		return nil, fmt.Errorf("failed to add a way of %d nodes, it needs two", len(nodeIDs))
+	}
+	for _, id := range nodeIDs {
+		if _, ok := em.Nodes[id]; !ok {
+			return nil, fmt.Errorf("failed to add a way through node %d %w", id, ErrUnknownNode)
+		}
+	}
+
+	way := &osm.Way{ID: em.newWayID(), Tags: tags, Visible: true}
+	for _, id := range nodeIDs {
+		way.Nodes = append(way.Nodes, osm.WayNode{ID: id})
+	}
+
+	em.insertWay(way)
+	em.edited(EditCreate, nil, way)
+	return way, nil
+}
+
+// SplitWay cuts the way in two at one of its inner nodes. The first piece
+// keeps the ID, the second gets a new one. Turn restrictions from or to
+// the way move onto the pieces touching their via node.
+func (em *EnhancedMap) SplitWay(id osm.WayID, at osm.NodeID) (*osm.Way, *osm.Way, error) {
+	way, ok := em.WaysByID[id]
+	if !ok {
+		return nil, nil, fmt.Errorf("failed to split way %d %w", id, ErrUnknownWay)
+	}
+	i := slices.IndexFunc(way.Nodes, func(wn osm.WayNode) bool { return wn.ID == at })
+	if i == 0 && len(way.Nodes) > 2 {
+		// the first node of a closed way is also its last, look inside
+		if j := slices.IndexFunc(way.Nodes[1:len(way.Nodes)-1], func(wn osm.WayNode) bool { return wn.ID == at }); j >= 0 {
+			i = j + 1
+		}
+	}
+	if i <= 0 || i >= len(way.Nodes)-1 {
+		return nil, nil, fmt.Errorf("failed to split way %d, node %d is not one of its inner nodes", id, at)
+	}
+
+	first := cloneWay(way)
+	first.Nodes = first.Nodes[:i+1]
+	second := cloneWay(way)
+	second.ID = em.newWayID()
+	second.Nodes = second.Nodes[i:]
+
+	em.replaceWay(way, first)
+	em.insertWay(second)
+
+	var restrictions []*TurnRestriction
+	touches := func(w *osm.Way, node osm.NodeID) bool {
+		return slices.ContainsFunc(w.Nodes, func(wn osm.WayNode) bool { return wn.ID == node })
+	}
+	pieces := func(wayID osm.WayID, via osm.NodeID) []osm.WayID {
+		if wayID != id {
+			return []osm.WayID{wayID}
+		}
+		var ids []osm.WayID
+		for _, w := range [2]*osm.Way{first, second} {
+			if touches(w, via) {
+				ids = append(ids, w.ID)
+			}
+		}
+		return ids
+	}
+	for _, r := range em.Restrictions {
+		if r.From != id && r.To != id {
+			restrictions = append(restrictions, r)
+			continue
+		}
+		for _, from := range pieces(r.From, r.Via) {
+			for _, to := range pieces(r.To, r.Via) {
+				moved := *r
+				moved.From, moved.To = from, to
+				restrictions = append(restrictions, &moved)
+			}
+		}
+	}
+	em.Restrictions = restrictions
+	em.buildRestrictions()
+
+	em.edited(EditModify, nil, first)
+	em.edited(EditCreate, nil, second)
+	return first, second, nil
+}
+
+// DeleteWay deletes the way, leaving its nodes, and the turn restrictions
+// from or to it.
+func (em *EnhancedMap) DeleteWay(id osm.WayID) error {
+	way, ok := em.WaysByID[id]
+	if !ok {
+		return fmt.Errorf("failed to delete way %d %w", id, ErrUnknownWay)
+	}
+	em.removeWay(way)
+	em.edited(EditDelete, nil, way)
+	return nil
+}
+
+// RetagWay replaces the tags of the way.
+func (em *EnhancedMap) RetagWay(id osm.WayID, tags osm.Tags) error {
+	way, ok := em.WaysByID[id]
+	if !ok {
+		return fmt.Errorf("failed to retag way %d %w", id, ErrUnknownWay)
+	}
+	edited := cloneWay(way)
+	edited.Tags = tags
+
+	em.replaceWay(way, edited)
+	em.edited(EditModify, nil, edited)
+	return nil
+}
+
+func cloneWay(way *osm.Way) *osm.Way {
+	c := *way
+	c.Nodes = slices.Clone(way.Nodes)
+	c.Tags = slices.Clone(way.Tags)
+	return &c
+}
+
+func (em *EnhancedMap) newWayID() osm.WayID {
+	if em.nextWayID == 0 {
+		em.nextWayID = -1
+		for _, way := range em.Ways {
+			em.nextWayID = min(em.nextWayID, way.ID-1)
+		}
+	}
+	id := em.nextWayID
+	em.nextWayID--
+	return id
+}
+
+func (em *EnhancedMap) insertWay(way *osm.Way) {
+	em.Ways = append(em.Ways, way)
+	em.WaysByID[way.ID] = way
+	for _, wn := range way.Nodes {
+		em.NodeToWays[wn.ID] = append(em.NodeToWays[wn.ID], way)
+	}
+	em.SpatialIndex.InsertWay(way, em.Nodes)
+	em.updateJunctions(wayNodeIDs(way))
+	if em.options.aux&AuxStreetNames != 0 {
+		em.StreetIndex.insert(way)
+	}
+}
+
+// replaceWay puts edited, with the same ID, in the place of old.
+func (em *EnhancedMap) replaceWay(old, edited *osm.Way) {
+	if i := slices.Index(em.Ways, old); i >= 0 {
+		em.Ways[i] = edited
+	}
+	em.WaysByID[edited.ID] = edited
+	em.unlinkWay(old)
+	for _, wn := range edited.Nodes {
+		em.NodeToWays[wn.ID] = append(em.NodeToWays[wn.ID], edited)
+	}
+	em.SpatialIndex.UpdateWay(edited, em.Nodes)
+	em.updateJunctions(append(wayNodeIDs(old), wayNodeIDs(edited)...))
+	if em.options.aux&AuxStreetNames != 0 {
+		em.StreetIndex.remove(old)
+		em.StreetIndex.insert(edited)
+	}
+}
+
+func (em *EnhancedMap) removeWay(way *osm.Way) {
+	em.Ways = slices.DeleteFunc(em.Ways, func(w *osm.Way) bool { return w == way })
+	delete(em.WaysByID, way.ID)
+	em.unlinkWay(way)
+	em.SpatialIndex.RemoveWay(way.ID)
+	em.updateJunctions(wayNodeIDs(way))
+	if em.options.aux&AuxStreetNames != 0 {
+		em.StreetIndex.remove(way)
+	}
+	em.dropRestrictions(func(r *TurnRestriction) bool { return r.From == way.ID || r.To == way.ID })
+}
+
+// unlinkWay takes the way out of NodeToWays, a node left on no way keeps
+// no entry.
+func (em *EnhancedMap) unlinkWay(way *osm.Way) {
+	for _, wn := range way.Nodes {
+		ways := slices.DeleteFunc(slices.Clone(em.NodeToWays[wn.ID]), func(w *osm.Way) bool { return w == way })
+		if len(ways) == 0 {
+			delete(em.NodeToWays, wn.ID)
+		} else {
+			em.NodeToWays[wn.ID] = ways
+		}
+	}
+}
+
+func wayNodeIDs(way *osm.Way) []osm.NodeID {
+	ids := make([]osm.NodeID, len(way.Nodes))
+	for i, wn := range way.Nodes {
+		ids[i] = wn.ID
+	}
+	return ids
+}
+
+func (em *EnhancedMap) updateJunctions(ids []osm.NodeID) {
+	if em.options.aux&AuxJunctions == 0 {
+		return
+	}
+	for _, id := range ids {
+		em.updateJunction(id)
+	}
+}
+
+func (em *EnhancedMap) dropRestrictions(match func(*TurnRestriction) bool) {
+	if !slices.ContainsFunc(em.Restrictions, match) {
+		return
+	}
+	em.Restrictions = slices.DeleteFunc(slices.Clone(em.Restrictions), match)
+	em.buildRestrictions()
+}
+
+// updateBounds follows a node from old to moved, either may be nil. The
+// bounds are only computed again when they may have shrunk or the node
+// left them.
+func (em *EnhancedMap) updateBounds(old, moved *osm.Node) {
+	b := em.Bounds
+	onEdge := old != nil &&
+		(old.Lat == b.MinLat || old.Lat == b.MaxLat || old.Lon == b.MinLon || old.Lon == b.MaxLon)
+	if onEdge || moved != nil && (len(em.Nodes) == 1 || !b.Contains(moved.Lat, moved.Lon)) {
+		em.Bounds = em.Map.CalculateBounds()
+	}
+}
+
+// replaceLandmark puts lm in the place of the i-th landmark.
+func (em *EnhancedMap) replaceLandmark(i int, lm *Landmark) {
+	if em.options.aux&AuxLandmarks != 0 {
+		em.LandmarkIndex.remove(em.Landmarks[i])
+		em.LandmarkIndex.Insert(lm)
+	}
+	em.Landmarks[i] = lm
+}
+
+// replaceBuilding puts b in the place of the i-th building.
+func (em *EnhancedMap) replaceBuilding(i int, b *Building) {
+	if em.options.aux&AuxBuildings != 0 {
+		em.BuildingIndex.remove(em.Buildings[i])
+		em.BuildingIndex.Insert(b)
+	}
+	em.Buildings[i] = b
+}
+
+// moveCorners returns a copy of the building with its corners at from moved
+// to to, or false when it has none there.
+func moveCorners(b *Building, from, to LatLon) (*Building, bool) {
+	if !slices.ContainsFunc(b.Polygon.Rings(), func(ring []LatLon) bool { return slices.Contains(ring, from) }) {
+		return nil, false
+	}
+	moveRing := func(ring []LatLon) []LatLon {
+		ring = slices.Clone(ring)
+		for i := range ring {
+			if ring[i] == from {
+				ring[i] = to
+			}
+		}
+		return ring
+	}
+
+	moved := *b
+	moved.Polygon = Polygon{Outer: moveRing(b.Polygon.Outer)}
+	for _, hole := range b.Polygon.Holes {
+		moved.Polygon.Holes = append(moved.Polygon.Holes, moveRing(hole))
+	}
+	return &moved, true
+}
+
+// reattachLandmarks attaches again the landmarks that were attached to one
+// of the ways, lie close enough to attach to one, or are the node.
+func (em *EnhancedMap) reattachLandmarks(ways []*osm.Way, node osm.NodeID) {
+	ids := make(map[osm.WayID]bool)
+	var near []Bounds
+	for _, way := range ways {
+		ids[way.ID] = true
+		if line := wayGeometry(way, em.Nodes); len(line) > 0 {
+			near = append(near, Polygon{Outer: line}.Bounds().Buffer(landmarkAttachDistance))
+		}
+	}
+
+	index := em.wayIndex()
+	for i, lm := range em.Landmarks {
+		if lm.ID != node && !ids[lm.WayID] && !slices.ContainsFunc(near, func(b Bounds) bool { return b.Contains(lm.Lat, lm.Lon) }) {
+			continue
+		}
+		var way *osm.Way
+		if ways := em.NodeToWays[lm.ID]; len(ways) > 0 {
+			way = ways[0]
+		}
+		attached := *lm
+		attachLandmark(&attached, way, landmarkAttachDistance, index, em.Nodes)
+		if attached != *lm {
+			em.replaceLandmark(i, &attached)
+		}
+	}
+}
+
+// edited logs the edit, attaches again the landmarks it may have moved off
+// their way and marks what cannot follow it as stale.
+func (em *EnhancedMap) edited(action EditAction, node *osm.Node, way *osm.Way) {
+	em.edits = append(em.edits, MapEdit{Action: action, Node: node, Way: way})
+
+	em.LikelihoodField = nil
+	if _, ok := em.WayIndex.(*SegmentRTree); ok {
+		em.wayIndexStale = true
+		if em.staleWayIndex == nil {
+			em.staleWayIndex = NewGridWayIndex(em.Map, em.SpatialIndex)
+		}
+	}
+
+	if node != nil {
+		em.reattachLandmarks(em.NodeToWays[node.ID], node.ID)
+	} else {
+		em.reattachLandmarks([]*osm.Way{way}, 0)
+	}
+}
+
+// Changes collapses the edit log into the final state of each element: an
+// element created then deleted is left out, one created is in Create, and
+// one of the original map is in Modify or Delete.
+//
+// The changes are in the numbering of this map, not of OpenStreetMap.
+// ExtractObjects cuts the ways at junctions and numbers the pieces from 1,
+// with no version, so the ways are only meaningful against a map extracted
+// the same way. Nodes keep their OSM IDs and the version they were read
+// with. The output is for replaying edits on a local copy, it is not an
+// upload to the OSM API.
+func (em *EnhancedMap) Changes() *osm.Change {
+	// ObjectID, and so osm.Change.Append, does not take the negative IDs
+	// of created elements
+	type key struct {
+		typ osm.Type
+		id  int64
+	}
+	type change struct {
+		created, deleted bool
+		last             MapEdit
+	}
+	var order []key
+	changes := make(map[key]*change)
+
+	for _, e := range em.edits {
+		var k key
+		if e.Node != nil {
+			k = key{osm.TypeNode, int64(e.Node.ID)}
+		} else {
+			k = key{osm.TypeWay, int64(e.Way.ID)}
+		}
+		c, ok := changes[k]
+		if !ok {
+			c = &change{}
+			changes[k] = c
+			order = append(order, k)
+		}
+		c.created = c.created || e.Action == EditCreate
+		c.deleted = c.deleted || e.Action == EditDelete
+		c.last = e
+	}
+
+	out := &osm.Change{Create: &osm.OSM{}, Modify: &osm.OSM{}, Delete: &osm.OSM{}}
+	for _, k := range order {
+		var o *osm.OSM
+		switch c := changes[k]; {
+		case c.created && c.deleted:
+			continue
+		case c.created:
+			o = out.Create
+		case c.deleted:
+			o = out.Delete
+		default:
+			o = out.Modify
+		}
+		if e := changes[k].last; e.Node != nil {
+			o.Nodes = append(o.Nodes, e.Node)
+		} else {
+			o.Ways = append(o.Ways, e.Way)
+		}
+	}
+	return out
+}
+
+// WriteOsmChange writes Changes as an osmChange document. Deleted ways
+// come before deleted nodes, so a node is no longer used when it goes. Like
+// Changes, the document refers to the pieces of ways of this map and is
+// not fit for upload.
+func (em *EnhancedMap) WriteOsmChange(w io.Writer) error {
+	c := em.Changes()
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	e := xml.NewEncoder(w)
+	e.Indent("", "  ")
+
+	root := xml.StartElement{Name: xml.Name{Local: "osmChange"},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "version"}, Value: "0.6"}}}
+	if err := e.EncodeToken(root); err != nil {
+		return err
+	}
+
+	type block struct {
+		name string
+		o    *osm.OSM
+	}
+	blocks := []block{{"create", c.Create}, {"modify", c.Modify}}
+	if c.Delete != nil {
+		blocks = append(blocks, block{"delete", &osm.OSM{Ways: c.Delete.Ways}}, block{"delete", &osm.OSM{Nodes: c.Delete.Nodes}})
+	}
+
+	for _, b := range blocks {
+		if b.o == nil || len(b.o.Nodes) == 0 && len(b.o.Ways) == 0 {
+			continue
+		}
+		start := xml.StartElement{Name: xml.Name{Local: b.name}}
+		if err := e.EncodeToken(start); err != nil {
+			return err
+		}
+		for _, n := range b.o.Nodes {
+			if err := e.EncodeElement(n, xml.StartElement{Name: xml.Name{Local: "node"}}); err != nil {
+				return err
+			}
+		}
+		for _, way := range b.o.Ways {
+			if err := e.EncodeElement(way, xml.StartElement{Name: xml.Name{Local: "way"}}); err != nil {
+				return err
+			}
+		}
+		if err := e.EncodeToken(start.End()); err != nil {
+			return err
+		}
+	}
+
+	if err := e.EncodeToken(root.End()); err != nil {
+		return err
+	}
+	return e.Flush()
+}
+
+// SaveOsmChange writes the edits to an .osc file.
+func (em *EnhancedMap) SaveOsmChange(fname string) error {
+	file, err := os.Create(fname)
+	if err != nil {
+		return fmt.Errorf("failed to create %q %w", fname, err)
+	}
+	defer file.Close()
+
+	if err := em.WriteOsmChange(file); err != nil {
+		return fmt.Errorf("failed to write %q %w", fname, err)
+	}
+	return file.Close()
+}
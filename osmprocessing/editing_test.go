@@ -0,0 +1,340 @@
+package osmprocessing
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"math/rand"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/paulmach/osm"
+)
+
+// randomEdits makes count edits of every kind on ways and nodes picked at
+// random.
+func randomEdits(t *testing.T, em *EnhancedMap, rng *rand.Rand, count int) {
+	t.Helper()
+	randomNode := func() osm.NodeID {
+		way := em.Ways[rng.Intn(len(em.Ways))]
+		return way.Nodes[rng.Intn(len(way.Nodes))].ID
+	}
+
+	for i := 0; i < count; i++ {
+		var err error
+		switch rng.Intn(6) {
+		case 0:
+			node := em.Nodes[randomNode()]
+			lat, lon := DestinationPoint(node.Lat, node.Lon, rng.Float64()*360, rng.Float64()*300)
+			err = em.MoveNode(node.ID, lat, lon)
+		case 1:
+			err = em.RemoveNode(randomNode())
+		case 2:
+			node := em.Nodes[randomNode()]
+			lat, lon := DestinationPoint(node.Lat, node.Lon, rng.Float64()*360, rng.Float64()*300)
+			added := em.AddNode(lat, lon, nil)
+			_, err = em.AddWay([]osm.NodeID{node.ID, added.ID, randomNode()}, osm.Tags{{Key: "highway", Value: "service"}})
+		case 3:
+			way := em.Ways[rng.Intn(len(em.Ways))]
+			if len(way.Nodes) > 2 {
+				_, _, err = em.SplitWay(way.ID, way.Nodes[1+rng.Intn(len(way.Nodes)-2)].ID)
+			}
+		case 4:
+			err = em.DeleteWay(em.Ways[rng.Intn(len(em.Ways))].ID)
+		case 5:
+			err = em.RetagWay(em.Ways[rng.Intn(len(em.Ways))].ID, osm.Tags{{Key: "highway", Value: "primary"}})
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestEditingKeepsIndexes(t *testing.T) {
+	for _, index := range []IndexType{RTreeIndex, GridIndex} {
+		t.Run(index.String(), func(t *testing.T) {
+			rng := rand.New(rand.NewSource(31))
+			m := randomWayMap(rng, 200, 44.84, -0.58, 1500)
+			// on their own source, the edits stay the same
+			landmarkRng := rand.New(rand.NewSource(32))
+			for i := 0; i < 200; i++ {
+				lat, lon := DestinationPoint(44.84, -0.58, landmarkRng.Float64()*360, landmarkRng.Float64()*1500)
+				m.Landmarks = append(m.Landmarks, &Landmark{ID: osm.NodeID(1_000_000 + i), Type: BusStop, Lat: lat, Lon: lon})
+			}
+			em := NewEnhancedMap(m, WithWayIndex(index))
+			randomEdits(t, em, rng, 300)
+
+			if em.wayIndex() != em.wayIndex() {
+				t.Fatal("a new grid way index for every query")
+			}
+			attached := make([]Landmark, len(em.Landmarks))
+			for i, lm := range em.Landmarks {
+				attached[i] = *lm
+				if !slices.Contains(em.LandmarkIndex.Query(lm.Lat, lm.Lon, 1), lm) {
+					t.Fatalf("landmark %d is not in the index", lm.ID)
+				}
+			}
+
+			built := NewEnhancedMap(em.Map, WithWayIndex(index))
+			for i, lm := range em.Landmarks {
+				if attached[i] != *lm {
+					t.Fatalf("landmark %d: edited on way %d at %.3fm, built on %d at %.3fm",
+						lm.ID, attached[i].WayID, attached[i].Offset, lm.WayID, lm.Offset)
+				}
+			}
+			if len(em.WaysByID) != len(em.Ways) || len(em.WaysByID) != len(built.WaysByID) ||
+				len(em.NodeToWays) != len(built.NodeToWays) || em.Bounds != built.Bounds {
+				t.Fatalf("edited %d ways, %d nodes in %v, built %d, %d in %v",
+					len(em.WaysByID), len(em.NodeToWays), em.Bounds, len(built.WaysByID), len(built.NodeToWays), built.Bounds)
+			}
+			for id, ways := range built.NodeToWays {
+				if got, want := wayIDs(em.NodeToWays[id]), wayIDs(ways); !slices.Equal(got, want) {
+					t.Fatalf("node %d: edited on ways %v, built %v", id, got, want)
+				}
+				for _, way := range em.NodeToWays[id] {
+					if em.WaysByID[way.ID] != way {
+						t.Fatalf("node %d: way %d is not the map's way", id, way.ID)
+					}
+				}
+			}
+			if len(em.Junctions) != len(built.Junctions) {
+				t.Fatalf("edited %d junctions, built %d", len(em.Junctions), len(built.Junctions))
+			}
+			for id, j := range built.Junctions {
+				if got := em.Junctions[id]; got == nil || !slices.Equal(got.Arms, j.Arms) {
+					t.Fatalf("junction %d: edited %v, built %v", id, got, j)
+				}
+			}
+
+			for i := 0; i < 300; i++ {
+				lat, lon := DestinationPoint(44.84, -0.58, rng.Float64()*360, rng.Float64()*1600)
+
+				wantWay, wantDist := built.FindNearestWayFast(lat, lon, 100)
+				gotWay, gotDist := em.FindNearestWayFast(lat, lon, 100)
+				if gotWay != wantWay || gotDist != wantDist {
+					t.Fatalf("%.6f,%.6f: edited finds %v at %.3f, built %v at %.3f", lat, lon, gotWay, gotDist, wantWay, wantDist)
+				}
+				if got, want := len(em.WaysWithin(lat, lon, 150)), len(built.WaysWithin(lat, lon, 150)); got != want {
+					t.Fatalf("%.6f,%.6f: %d ways within 150m, built %d", lat, lon, got, want)
+				}
+				// the grid of the edited map keeps its origin, it files
+				// nodes in other cells but finds the same ones
+				gotNode, _ := em.FindNearestNodeFast(lat, lon, 150)
+				wantNode, _ := built.FindNearestNodeFast(lat, lon, 150)
+				if gotNode != wantNode {
+					t.Fatalf("%.6f,%.6f: edited finds node %v, built %v", lat, lon, gotNode, wantNode)
+				}
+			}
+
+			// the queries went to the grid, the R-tree waits for the rebuild
+			if em.wayIndexStale != (index == RTreeIndex) {
+				t.Fatalf("stale R-tree: %v", em.wayIndexStale)
+			}
+			em.RebuildWayIndex()
+			if tree, ok := em.WayIndex.(*SegmentRTree); ok && tree.Len() != built.WayIndex.(*SegmentRTree).Len() {
+				t.Fatalf("rebuilt %d segments, built %d", tree.Len(), built.WayIndex.(*SegmentRTree).Len())
+			}
+			for i := 0; i < 100; i++ {
+				lat, lon := DestinationPoint(44.84, -0.58, rng.Float64()*360, rng.Float64()*1600)
+				wantWay, wantDist := built.FindNearestWayFast(lat, lon, 100)
+				if gotWay, gotDist := em.FindNearestWayFast(lat, lon, 100); gotWay != wantWay || gotDist != wantDist {
+					t.Fatalf("%.6f,%.6f: rebuilt finds %v at %.3f, built %v at %.3f", lat, lon, gotWay, gotDist, wantWay, wantDist)
+				}
+			}
+		})
+	}
+}
+
+func TestEditingErrors(t *testing.T) {
+	em, arms := crossroads(nil)
+	if err := em.MoveNode(99, 0, 0); !errors.Is(err, ErrUnknownNode) {
+		t.Errorf("got %v", err)
+	}
+	if err := em.RetagWay(99, nil); !errors.Is(err, ErrUnknownWay) {
+		t.Errorf("got %v", err)
+	}
+	if _, err := em.AddWay([]osm.NodeID{1, 99}, nil); !errors.Is(err, ErrUnknownNode) {
+		t.Errorf("got %v", err)
+	}
+	if _, _, err := em.SplitWay(arms["north"].ID, 1); err == nil {
+		t.Error("split a way at its end")
+	}
+	if len(em.Edits()) != 0 {
+		t.Errorf("logged %d edits", len(em.Edits()))
+	}
+}
+
+func TestSplitWayRestrictions(t *testing.T) {
+	// the west and east arms as a single way through the junction, no
+	// right turn from it onto the south arm
+	em, arms := crossroads(nil)
+	if err := em.DeleteWay(arms["east"].ID); err != nil {
+		t.Fatal(err)
+	}
+	through, err := em.AddWay([]osm.NodeID{5, 1, 3}, osm.Tags{{Key: "highway", Value: "residential"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := em.DeleteWay(arms["west"].ID); err != nil {
+		t.Fatal(err)
+	}
+	em.Restrictions = []*TurnRestriction{{Type: "no_right_turn", From: through.ID, Via: 1, To: arms["south"].ID}}
+	em.buildRestrictions()
+
+	west, east, err := em.SplitWay(through.ID, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if west.ID != through.ID || len(west.Nodes) != 2 || len(east.Nodes) != 2 || em.WaysByID[east.ID] != east {
+		t.Fatalf("split into %v and %v", west, east)
+	}
+	if len(em.Restrictions) != 2 {
+		t.Fatalf("got %d restrictions", len(em.Restrictions))
+	}
+
+	turns := moveTurns(em.NextWays(west, true))
+	if _, ok := turns[arms["south"].ID]; ok || len(turns) != 2 {
+		t.Errorf("got %v", turns)
+	}
+	if j := em.Junctions[1]; j == nil || len(j.Arms) != 4 {
+		t.Errorf("junction %v", j)
+	}
+}
+
+func TestMoveNodeCarriesLandmarkAndBuilding(t *testing.T) {
+	em, arms := crossroads(nil)
+	north, east := em.Nodes[2], em.Nodes[3]
+	em.Landmarks = []*Landmark{{ID: north.ID, Type: StopSign, Lat: north.Lat, Lon: north.Lon}}
+	corner := LatLon{east.Lat, east.Lon}
+	building := &Building{ID: osm.WayID(100).FeatureID(), Polygon: Polygon{Outer: []LatLon{
+		corner, {east.Lat + 0.0002, east.Lon}, {east.Lat + 0.0002, east.Lon + 0.0002}, {east.Lat, east.Lon + 0.0002}, corner,
+	}}}
+	em.Buildings = []*Building{building}
+	em = NewEnhancedMap(em.Map)
+
+	lat, lon := DestinationPoint(north.Lat, north.Lon, 0, 20)
+	if err := em.MoveNode(north.ID, lat, lon); err != nil {
+		t.Fatal(err)
+	}
+	lm := em.Landmarks[0]
+	if lm.Lat != lat || lm.Lon != lon || lm.WayID != arms["north"].ID {
+		t.Fatalf("landmark at %.6f,%.6f on way %d", lm.Lat, lm.Lon, lm.WayID)
+	}
+	assertWithinPercent(t, lm.Offset, 120, 0.1)
+	if got := em.LandmarkIndex.Query(lat, lon, 1); len(got) != 1 || got[0] != lm {
+		t.Errorf("found %v at the new position", got)
+	}
+	if got := em.LandmarkIndex.Query(north.Lat, north.Lon, 1); len(got) != 0 {
+		t.Errorf("found %v at the old position", got)
+	}
+
+	lat, lon = DestinationPoint(east.Lat, east.Lon, 180, 10)
+	if err := em.MoveNode(east.ID, lat, lon); err != nil {
+		t.Fatal(err)
+	}
+	moved := em.Buildings[0]
+	if moved == building || building.Polygon.Outer[0] != corner {
+		t.Fatal("the building was changed in place")
+	}
+	if want := (LatLon{lat, lon}); moved.Polygon.Outer[0] != want || moved.Polygon.Outer[4] != want {
+		t.Fatalf("outline %v", moved.Polygon.Outer)
+	}
+	if got := em.BuildingIndex.Query(lat, lon, 1); !slices.Contains(got, moved) || slices.Contains(got, building) {
+		t.Errorf("found %v at the new corner", got)
+	}
+}
+
+func TestRemoveNodeKeepsWayClosed(t *testing.T) {
+	m := &Map{Nodes: make(map[osm.NodeID]*osm.Node)}
+	ring := &osm.Way{ID: 1, Tags: osm.Tags{{Key: "highway", Value: "residential"}}}
+	for i, bearing := range []float64{0, 90, 180, 270} {
+		lat, lon := DestinationPoint(44.84, -0.58, bearing, 100)
+		m.Nodes[osm.NodeID(i+1)] = &osm.Node{ID: osm.NodeID(i + 1), Lat: lat, Lon: lon}
+		ring.Nodes = append(ring.Nodes, osm.WayNode{ID: osm.NodeID(i + 1)})
+	}
+	ring.Nodes = append(ring.Nodes, osm.WayNode{ID: 1})
+	m.Ways = []*osm.Way{ring}
+	em := NewEnhancedMap(m)
+
+	if err := em.RemoveNode(1); err != nil {
+		t.Fatal(err)
+	}
+	if got := wayNodeIDs(em.WaysByID[1]); !slices.Equal(got, []osm.NodeID{2, 3, 4, 2}) {
+		t.Fatalf("first node removed, got %v", got)
+	}
+	if err := em.RemoveNode(2); err != nil {
+		t.Fatal(err)
+	}
+	if got := wayNodeIDs(em.WaysByID[1]); !slices.Equal(got, []osm.NodeID{3, 4, 3}) {
+		t.Fatalf("first node removed again, got %v", got)
+	}
+	if err := em.RemoveNode(4); err != nil {
+		t.Fatal(err)
+	}
+	if em.WaysByID[1] != nil {
+		t.Fatalf("kept %v", wayNodeIDs(em.WaysByID[1]))
+	}
+}
+
+func TestWriteOsmChange(t *testing.T) {
+	em, arms := crossroads(nil)
+
+	added := em.AddNode(44.841, -0.581, nil)
+	way, err := em.AddWay([]osm.NodeID{added.ID, 2}, osm.Tags{{Key: "highway", Value: "service"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added.ID >= 0 || way.ID >= 0 {
+		t.Errorf("created node %d and way %d", added.ID, way.ID)
+	}
+
+	// a node both created and removed is left out
+	gone := em.AddNode(44.842, -0.582, nil)
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	must(em.RemoveNode(gone.ID))
+
+	must(em.RetagWay(arms["east"].ID, osm.Tags{{Key: "highway", Value: "primary"}}))
+	must(em.MoveNode(4, 44.8395, -0.58))
+	must(em.RemoveNode(5))
+
+	if len(em.Edits()) != 8 {
+		t.Errorf("logged %d edits", len(em.Edits()))
+	}
+
+	c := em.Changes()
+	if len(c.Create.Nodes) != 1 || len(c.Create.Ways) != 1 || len(c.Modify.Nodes) != 1 || len(c.Modify.Ways) != 1 ||
+		len(c.Delete.Nodes) != 1 || len(c.Delete.Ways) != 1 {
+		t.Fatalf("got %+v %+v %+v", c.Create, c.Modify, c.Delete)
+	}
+	if c.Modify.Ways[0].Tags.Find("highway") != "primary" || c.Delete.Ways[0].ID != arms["west"].ID {
+		t.Errorf("modified %v, deleted %v", c.Modify.Ways[0], c.Delete.Ways[0])
+	}
+
+	var buf bytes.Buffer
+	if err := em.WriteOsmChange(&buf); err != nil {
+		t.Fatal(err)
+	}
+	doc := buf.String()
+	if w, n := strings.Index(doc, `<way id="5"`), strings.Index(doc, `<node id="5"`); w < 0 || n < w {
+		t.Errorf("way 5 deleted at %d, its node at %d:\n%s", w, n, doc)
+	}
+
+	var read struct {
+		Create []osm.OSM `xml:"create"`
+		Modify []osm.OSM `xml:"modify"`
+		Delete []osm.OSM `xml:"delete"`
+	}
+	if err := xml.Unmarshal(buf.Bytes(), &read); err != nil {
+		t.Fatal(err)
+	}
+	if len(read.Create) != 1 || len(read.Modify) != 1 || len(read.Delete) != 2 ||
+		read.Create[0].Nodes[0].ID != added.ID || read.Modify[0].Nodes[0].Lat != 44.8395 {
+		t.Errorf("read back %+v", read)
+	}
+}
@@ -1,28 +1,66 @@
 package osmprocessing
 
 import (
+	"iter"
+	"slices"
+
 	"github.com/paulmach/osm"
 )
 
 type EnhancedMap struct {
 	*Map
-	SpatialIndex *SpatialIndex
-	WaysByID     map[osm.WayID]*osm.Way
-	NodeToWays   map[osm.NodeID][]*osm.Way
-	Bounds       Bounds
-}
+	SpatialIndex  *SpatialIndex
+	WayIndex      WayIndex
+	LandmarkIndex *LandmarkIndex
+	BuildingIndex *BuildingIndex
+	StreetIndex   *StreetIndex
+	WaysByID      map[osm.WayID]*osm.Way
+	NodeToWays    map[osm.NodeID][]*osm.Way
+	Junctions     map[osm.NodeID]*Junction
+	Bounds        Bounds
+	Geodesy       Geodesy // nil uses GeodesicBackend()
+	// LikelihoodField, when set, answers NearestRoad without a search
+	LikelihoodField *LikelihoodField
+	// SearchRadius is how far queries without a radius of their own look
+	// for a road, in metres
+	SearchRadius float64
 
-func NewEnhancedMap(m *Map) *EnhancedMap {
-	em := &EnhancedMap{
-		Map:        m,
-		WaysByID:   make(map[osm.WayID]*osm.Way),
-		NodeToWays: make(map[osm.NodeID][]*osm.Way),
-	}
+	options      enhancedMapOptions
+	restrictions map[osm.NodeID][]*TurnRestriction // by via node
 
+	// edits leave an R-tree behind, queries go to the grid in
+	// staleWayIndex until RebuildWayIndex
+	wayIndexStale bool
+	staleWayIndex WayIndex
+	edits         []MapEdit
+	nextNodeID    osm.NodeID
+	nextWayID     osm.WayID
+}
+
+// NewEnhancedMap indexes the map. Without options it builds a grid of 100m
+// cells, which answers the way queries too, and every auxiliary index.
+func NewEnhancedMap(m *Map, opts ...Option) *EnhancedMap {
+	em := newEnhancedMap(m, opts)
 	em.BuildIndexes()
 	return em
 }
 
+func newEnhancedMap(m *Map, opts []Option) *EnhancedMap {
+	o := defaultEnhancedMapOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return &EnhancedMap{
+		Map:          m,
+		WaysByID:     make(map[osm.WayID]*osm.Way),
+		NodeToWays:   make(map[osm.NodeID][]*osm.Way),
+		Geodesy:      o.geodesy,
+		SearchRadius: o.searchRadius,
+		options:      o,
+	}
+}
+
 func (em *EnhancedMap) BuildIndexes() {
 
 	for _, way := range em.Ways {
@@ -35,9 +73,60 @@ func (em *EnhancedMap) BuildIndexes() {
 		}
 	}
 
-	// 0.001 degrees = 100m cell
-	em.SpatialIndex = em.Map.BuildSpatialIndex(0.001)
+	o := em.options
+	em.SpatialIndex = em.Map.BuildSpatialIndex(o.cellSize)
+	switch o.wayIndex {
+	case GridIndex:
+		em.WayIndex = NewGridWayIndex(em.Map, em.SpatialIndex)
+	default:
+		em.WayIndex = em.Map.BuildSegmentRTree()
+	}
+	em.Map.AttachLandmarks(landmarkAttachDistance, em.WayIndex)
+
 	em.Bounds = em.Map.CalculateBounds()
+	lat0, _ := em.Bounds.GetCenter()
+	degreeCell := o.degreeCellSize(lat0)
+	em.LandmarkIndex = NewLandmarkIndex(degreeCell)
+	if o.aux&AuxLandmarks != 0 {
+		em.LandmarkIndex = em.Map.BuildLandmarkIndex(degreeCell)
+	}
+	em.BuildingIndex = NewBuildingIndex(degreeCell)
+	if o.aux&AuxBuildings != 0 {
+		em.BuildingIndex = em.Map.BuildBuildingIndex(degreeCell)
+	}
+	em.StreetIndex = NewStreetIndex()
+	if o.aux&AuxStreetNames != 0 {
+		em.StreetIndex = em.Map.BuildStreetIndex()
+	}
+	em.Junctions = make(map[osm.NodeID]*Junction)
+	if o.aux&AuxJunctions != 0 {
+		em.buildJunctions()
+	}
+	em.buildRestrictions()
+
+	if o.fieldResolution > 0 {
+		em.BuildLikelihoodField(o.fieldResolution, o.fieldMaxDistance)
+	}
+}
+
+// wayIndex is WayIndex, or the grid, which edits keep up to date, while
+// they left an R-tree behind.
+func (em *EnhancedMap) wayIndex() WayIndex {
+	if em.wayIndexStale {
+		return em.staleWayIndex
+	}
+	return em.WayIndex
+}
+
+// RebuildWayIndex builds the R-tree way index again once a batch of edits
+// is done, queries are answered from the grid until then. It does nothing
+// when the index is up to date, and like an edit it must not run
+// concurrently with queries.
+func (em *EnhancedMap) RebuildWayIndex() {
+	if em.wayIndexStale {
+		em.WayIndex = em.Map.BuildSegmentRTree()
+		em.wayIndexStale = false
+	}
 }
 
 func (em *EnhancedMap) GetConnectedWays(nodeID osm.NodeID) []*osm.Way {
@@ -45,22 +134,78 @@ func (em *EnhancedMap) GetConnectedWays(nodeID osm.NodeID) []*osm.Way {
 }
 
 func (em *EnhancedMap) IsValidPosition(lat, lon, tolerance float64) bool {
-	way, dist := em.Map.FindNearestWay(lat, lon, tolerance, em.SpatialIndex)
+	way, dist := em.wayIndex().FindNearestWay(lat, lon, tolerance)
 	return way != nil && dist <= tolerance
 }
 
 func (em *EnhancedMap) FindNearestWayFast(lat, lon, maxDist float64) (*osm.Way, float64) {
-	return em.Map.FindNearestWay(lat, lon, maxDist, em.SpatialIndex)
+	return em.wayIndex().FindNearestWay(lat, lon, maxDist)
 }
 
 func (em *EnhancedMap) FindNearestNodeFast(lat, lon, maxDist float64) (*osm.Node, float64) {
 	return em.Map.FindNearestNodeIndexed(lat, lon, maxDist, em.SpatialIndex)
 }
 
+// BuildLikelihoodField rasterizes the roads every resolution metres out to
+// maxDistance and uses the raster for NearestRoad from then on.
+func (em *EnhancedMap) BuildLikelihoodField(resolution, maxDistance float64) *LikelihoodField {
+	em.LikelihoodField = NewLikelihoodField(em.Map, resolution, maxDistance)
+	return em.LikelihoodField
+}
+
+// NearestRoad is the distance to the closest way within maxDist and the
+// bearing of its segment there. It reads the likelihood field when there
+// is one and searches the ways where the field has no answer.
+func (em *EnhancedMap) NearestRoad(lat, lon, maxDist float64) (distance, bearing float64, ok bool) {
+	if f := em.LikelihoodField; f != nil && maxDist <= f.MaxDistance {
+		distance, bearing, exact := f.Lookup(lat, lon)
+		if !exact {
+			return distance, bearing, distance <= maxDist
+		}
+	}
+
+	way, _ := em.FindNearestWayFast(lat, lon, maxDist)
+	if way == nil {
+		return 0, 0, false
+	}
+	proj, ok := ProjectOnWay(lat, lon, way, em.Nodes)
+	return proj.Distance, proj.Bearing, ok
+}
+
 func (em *EnhancedMap) GetWayHeadingAtPosition(lat, lon float64) float64 {
-	way, _ := em.FindNearestWayFast(lat, lon, 50.0)
+	radius := em.SearchRadius
+	if radius <= 0 {
+		radius = DefaultSearchRadius
+	}
+	way, _ := em.FindNearestWayFast(lat, lon, radius)
 	if way == nil {
 		return 0
 	}
 	return GetWayHeadingAtPoint(way, lat, lon, em.Nodes)
 }
+
+func (em *EnhancedMap) FindNearestLandmark(lat, lon, maxDist float64, typ LandmarkType) (*Landmark, float64) {
+	return em.LandmarkIndex.Nearest(lat, lon, maxDist, typ)
+}
+
+func (em *EnhancedMap) FindNearestFacade(lat, lon, maxDist float64) (*Building, float64, float64) {
+	return em.BuildingIndex.NearestFacade(lat, lon, maxDist)
+}
+
+func (em *EnhancedMap) RangeWays(p Polygon) iter.Seq[*osm.Way] {
+	return em.Map.WaysInPolygon(p, em.SpatialIndex)
+}
+
+func (em *EnhancedMap) RangeNodes(p Polygon) iter.Seq[*osm.Node] {
+	return em.Map.NodesInPolygon(p, em.SpatialIndex)
+}
+
+// WaysInBounds returns the ways with a part inside the box, which may cross
+// the antimeridian.
+func (em *EnhancedMap) WaysInBounds(b Bounds) []*osm.Way {
+	return slices.Collect(em.RangeWays(b.ToPolygon()))
+}
+
+func (em *EnhancedMap) NodesInBounds(b Bounds) []*osm.Node {
+	return slices.Collect(em.RangeNodes(b.ToPolygon()))
+}
@@ -0,0 +1,126 @@
+package osmprocessing
+
+import (
+	"fmt"
+	"math"
+)
+
+// IndexType is the structure behind WayIndex, which FindNearestWayFast and
+// the other way queries search.
+type IndexType int
+
+const (
+	// RTreeIndex measures segments. It only beats the grid on dense maps
+	// where most queries find a road, see BenchmarkWayIndex.
+	RTreeIndex IndexType = iota
+	// GridIndex reuses the SpatialIndex grid, the default.
+	GridIndex
+)
+
+func (t IndexType) String() string {
+	switch t {
+	case RTreeIndex:
+		return "rtree"
+	case GridIndex:
+		return "grid"
+	}
+	return "unknown"
+}
+
+// AuxIndexes picks the indexes built besides the way and node ones. Those
+// left out are empty, so their queries find nothing.
+type AuxIndexes int
+
+const (
+	AuxLandmarks AuxIndexes = 1 << iota
+	AuxBuildings
+	AuxJunctions
+	AuxStreetNames
+
+	AuxAll = AuxLandmarks | AuxBuildings | AuxJunctions | AuxStreetNames
+)
+
+// DefaultSearchRadius is SearchRadius unless WithSearchRadius sets it, in
+// metres, and what it stands for on a map built without NewEnhancedMap.
+const DefaultSearchRadius = 50.0
+
+// Option configures NewEnhancedMap.
+type Option func(*enhancedMapOptions)
+
+type enhancedMapOptions struct {
+	wayIndex     IndexType
+	cellSize     float64 // metres
+	searchRadius float64 // metres
+	geodesy      Geodesy
+	aux          AuxIndexes
+
+	// 0 builds no likelihood field
+	fieldResolution, fieldMaxDistance float64
+}
+
+func defaultEnhancedMapOptions() enhancedMapOptions {
+	return enhancedMapOptions{
+		wayIndex:     GridIndex,
+		cellSize:     100,
+		searchRadius: DefaultSearchRadius,
+		aux:          AuxAll,
+	}
+}
+
+func WithWayIndex(t IndexType) Option {
+	return func(o *enhancedMapOptions) { o.wayIndex = t }
+}
+
+// WithCellSize sets the SpatialIndex cell in metres, 100 by default. The
+// landmark and building grids follow in degrees, about 0.0009 degrees for
+// 100m. It panics on a size out of [minCellMeters, maxCellMeters], as
+// NewSpatialIndex does.
+func WithCellSize(metres float64) Option {
+	checkCellMeters(metres)
+	return func(o *enhancedMapOptions) { o.cellSize = metres }
+}
+
+// WithSearchRadius sets SearchRadius, how far queries without a radius of
+// their own look for a road, 50m by default. It panics on a radius that is
+// not positive and finite.
+func WithSearchRadius(metres float64) Option {
+	if !isPositive(metres) {
+		panic(fmt.Sprintf("search radius of %vm", metres))
+	}
+	return func(o *enhancedMapOptions) { o.searchRadius = metres }
+}
+
+// WithGeodesicBackend sets the backend of the Distance, Bearing and
+// Destination methods of the map instead of GeodesicBackend(). Its queries
+// and indexes don't use it.
+func WithGeodesicBackend(g Geodesy) Option {
+	return func(o *enhancedMapOptions) { o.geodesy = g }
+}
+
+func WithAuxIndexes(aux AuxIndexes) Option {
+	return func(o *enhancedMapOptions) { o.aux = aux }
+}
+
+// WithLikelihoodField builds a likelihood field with BuildLikelihoodField,
+// so NearestRoad reads a raster instead of searching. It panics unless
+// both the resolution and the distance are positive and finite.
+func WithLikelihoodField(resolution, maxDistance float64) Option {
+	if !isPositive(resolution) || !isPositive(maxDistance) {
+		panic(fmt.Sprintf("likelihood field of %vm pixels out to %vm", resolution, maxDistance))
+	}
+	return func(o *enhancedMapOptions) {
+		o.fieldResolution, o.fieldMaxDistance = resolution, maxDistance
+	}
+}
+
+func isPositive(v float64) bool {
+	return v > 0 && !math.IsInf(v, 1)
+}
+
+// degreeCellSize is the cell of the landmark and building grids, cellSize
+// metres from south to north at the latitude of the map. It spans fewer
+// metres from west to east away from the equator.
+func (o enhancedMapOptions) degreeCellSize(lat float64) float64 {
+	latMeters, _ := MetersPerDegree(lat)
+	return o.cellSize / latMeters
+}
@@ -0,0 +1,138 @@
+package osmprocessing
+
+import (
+	"errors"
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+func TestEnhancedMapOptions(t *testing.T) {
+	m, grid := GenerateMap(3, 3, 200,
+		ToDecimalCoord(46, 0, 0, North),
+		ToDecimalCoord(7, 0, 0, East))
+	node := m.Nodes[grid["1,1"]]
+	m.Landmarks = []*Landmark{{ID: -1, Type: TrafficSignals, Lat: node.Lat, Lon: node.Lon}}
+
+	// metres per degree of latitude at the map
+	lat0, _ := m.CalculateBounds().GetCenter()
+	latMeters, _ := MetersPerDegree(lat0)
+
+	// 30m off the crossing, diagonally
+	lat, lon := DestinationPoint(node.Lat, node.Lon, 45, 30)
+
+	t.Run("defaults", func(t *testing.T) {
+		em := NewEnhancedMap(m)
+		if _, ok := em.WayIndex.(*gridWayIndex); !ok {
+			t.Errorf("WayIndex is %T", em.WayIndex)
+		}
+		if em.SpatialIndex.cellSize != 100 || em.LandmarkIndex.cellSize != 100/latMeters || em.SearchRadius != 50 {
+			t.Errorf("cells of %vm and %v degrees, search radius %v", em.SpatialIndex.cellSize, em.LandmarkIndex.cellSize, em.SearchRadius)
+		}
+		if len(em.Junctions) == 0 || em.LikelihoodField != nil {
+			t.Errorf("%d junctions, likelihood field %v", len(em.Junctions), em.LikelihoodField != nil)
+		}
+	})
+
+	t.Run("grid", func(t *testing.T) {
+		em := NewEnhancedMap(m, WithWayIndex(GridIndex), WithCellSize(250))
+		if _, ok := em.WayIndex.(*gridWayIndex); !ok {
+			t.Errorf("WayIndex is %T", em.WayIndex)
+		}
+		if em.SpatialIndex.cellSize != 250 || em.BuildingIndex.cellSize != 250/latMeters {
+			t.Errorf("cells of %vm and %v degrees", em.SpatialIndex.cellSize, em.BuildingIndex.cellSize)
+		}
+		if way, dist := em.FindNearestWayFast(lat, lon, 50); way == nil || math.Abs(dist-30*math.Sqrt2/2) > 0.1 {
+			t.Errorf("got %v at %.3f", way, dist)
+		}
+	})
+
+	t.Run("search radius", func(t *testing.T) {
+		// 20m off an east-west street
+		lat, lon := DestinationPoint(node.Lat, node.Lon, 90, 50)
+		lat, lon = DestinationPoint(lat, lon, 0, 20)
+
+		if heading := NewEnhancedMap(m).GetWayHeadingAtPosition(lat, lon); math.Abs(math.Sin(DegToRad(heading))) < 0.99 {
+			t.Errorf("heading %v along an east-west street", heading)
+		}
+		if heading := NewEnhancedMap(m, WithSearchRadius(10)).GetWayHeadingAtPosition(lat, lon); heading != 0 {
+			t.Errorf("heading %v, searching 10m", heading)
+		}
+	})
+
+	t.Run("auxiliary indexes", func(t *testing.T) {
+		em := NewEnhancedMap(m, WithAuxIndexes(AuxBuildings))
+		if len(em.Junctions) != 0 {
+			t.Errorf("%d junctions", len(em.Junctions))
+		}
+		if lm, _ := em.FindNearestLandmark(node.Lat, node.Lon, 10, TrafficSignals); lm != nil {
+			t.Errorf("found %v without a landmark index", lm)
+		}
+
+		em = NewEnhancedMap(m, WithAuxIndexes(AuxLandmarks))
+		if lm, _ := em.FindNearestLandmark(node.Lat, node.Lon, 10, TrafficSignals); lm == nil {
+			t.Error("landmark not found")
+		}
+	})
+
+	t.Run("geodesy and likelihood field", func(t *testing.T) {
+		em := NewEnhancedMap(m, WithGeodesicBackend(NewVincentyGeodesy()), WithLikelihoodField(2, 50))
+		if _, ok := em.Geodesy.(VincentyGeodesy); !ok {
+			t.Errorf("Geodesy is %T", em.Geodesy)
+		}
+		if em.LikelihoodField == nil || em.LikelihoodField.Resolution != 2 {
+			t.Fatal("no likelihood field of 2m")
+		}
+	})
+
+	t.Run("invalid values", func(t *testing.T) {
+		// 0.001 is a cell size in degrees, as it once was
+		for name, option := range map[string]func(){
+			"cell size":         func() { WithCellSize(0.001) },
+			"negative cells":    func() { WithCellSize(-100) },
+			"search radius":     func() { WithSearchRadius(0) },
+			"NaN radius":        func() { WithSearchRadius(math.NaN()) },
+			"field resolution":  func() { WithLikelihoodField(0, 50) },
+			"infinite distance": func() { WithLikelihoodField(2, math.Inf(1)) },
+		} {
+			func() {
+				defer func() {
+					if recover() == nil {
+						t.Errorf("%s accepted", name)
+					}
+				}()
+				option()
+			}()
+		}
+	})
+}
+
+func TestLoadEnhancedMapOptions(t *testing.T) {
+	m := cacheTestMap()
+	fname := filepath.Join(t.TempDir(), "bordeaux.idx")
+
+	if _, err := LoadEnhancedMap(m, fname, WithWayIndex(GridIndex)); err != nil {
+		t.Fatal(err)
+	}
+	em, err := loadIndexCache(m, fname, []Option{WithWayIndex(GridIndex), WithSearchRadius(20)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := em.WayIndex.(*gridWayIndex); !ok || em.SearchRadius != 20 {
+		t.Errorf("loaded a %T searching %vm", em.WayIndex, em.SearchRadius)
+	}
+
+	// a cache built with other indexes is built again
+	for _, opts := range [][]Option{{WithWayIndex(RTreeIndex)}, {WithWayIndex(GridIndex), WithCellSize(50)}, {WithWayIndex(GridIndex), WithAuxIndexes(AuxJunctions)}} {
+		if _, err := loadIndexCache(m, fname, opts); !errors.Is(err, errIndexCacheStale) {
+			t.Errorf("got %v, want a stale cache", err)
+		}
+	}
+	em, err = LoadEnhancedMap(m, fname, WithWayIndex(RTreeIndex), WithCellSize(50))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := em.WayIndex.(*SegmentRTree); !ok || em.SpatialIndex.cellSize != 50 {
+		t.Errorf("rebuilt a %T of %vm cells", em.WayIndex, em.SpatialIndex.cellSize)
+	}
+}
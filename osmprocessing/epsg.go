@@ -0,0 +1,202 @@
+package osmprocessing
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+)
+
+// GRS80 is the ellipsoid of RGF93, the datum behind Lambert-93 and the
+// conic conformal zones. It differs from WGS84 by 0.1mm in semi-minor axis.
+var GRS80 = Ellipsoid{A: 6378137.0, F: 1 / 298.257222101}
+
+// isometricLatitude is IGN algorithm ALG0001, phi in radians.
+func isometricLatitude(phi, e float64) float64 {
+	s := e * math.Sin(phi)
+	return math.Log(math.Tan(math.Pi/4+phi/2) * math.Pow((1-s)/(1+s), e/2))
+}
+
+// latitudeFromIsometric is IGN algorithm ALG0002.
+func latitudeFromIsometric(l, e float64) float64 {
+	phi := 2*math.Atan(math.Exp(l)) - math.Pi/2
+	for i := 0; i < 20; i++ {
+		s := e * math.Sin(phi)
+		next := 2*math.Atan(math.Pow((1+s)/(1-s), e/2)*math.Exp(l)) - math.Pi/2
+		if math.Abs(next-phi) < 1e-12 {
+			return next
+		}
+		phi = next
+	}
+	return phi
+}
+
+// LambertConformalConic follows the IGN formulation (NTG_71): exponent n,
+// projection constant C and the projected pole (Xs, Ys) on the central
+// meridian Lon0.
+type LambertConformalConic struct {
+	Ellipsoid Ellipsoid
+	Lon0      float64 // central meridian in degrees
+
+	n, c, xs, ys float64
+}
+
+// NewLambertConformalConic2SP builds a secant cone from its two standard
+// parallels, IGN algorithm ALG0054. All angles are in degrees.
+func NewLambertConformalConic2SP(e Ellipsoid, lat0, lon0, lat1, lat2, falseEasting, falseNorthing float64) *LambertConformalConic {
+	ecc := math.Sqrt(e.E2())
+	phi0, phi1, phi2 := DegToRad(lat0), DegToRad(lat1), DegToRad(lat2)
+
+	l1 := isometricLatitude(phi1, ecc)
+	l2 := isometricLatitude(phi2, ecc)
+
+	n := math.Log((e.N(phi2)*math.Cos(phi2))/(e.N(phi1)*math.Cos(phi1))) / (l1 - l2)
+	c := e.N(phi1) * math.Cos(phi1) / n * math.Exp(n*l1)
+
+	return &LambertConformalConic{
+		Ellipsoid: e,
+		Lon0:      lon0,
+		n:         n,
+		c:         c,
+		xs:        falseEasting,
+		ys:        falseNorthing + c*math.Exp(-n*isometricLatitude(phi0, ecc)),
+	}
+}
+
+func (p *LambertConformalConic) Forward(lat, lon float64) (x, y float64) {
+	l := isometricLatitude(DegToRad(lat), math.Sqrt(p.Ellipsoid.E2()))
+	r := p.c * math.Exp(-p.n*l)
+	gamma := p.n * DegToRad(lon-p.Lon0)
+
+	return p.xs + r*math.Sin(gamma), p.ys - r*math.Cos(gamma)
+}
+
+func (p *LambertConformalConic) Inverse(x, y float64) (lat, lon float64) {
+	dx, dy := x-p.xs, p.ys-y
+	r := math.Hypot(dx, dy)
+	gamma := math.Atan2(dx, dy)
+
+	l := -math.Log(math.Abs(r/p.c)) / p.n
+	phi := latitudeFromIsometric(l, math.Sqrt(p.Ellipsoid.E2()))
+
+	return phi * 180 / math.Pi, p.Lon0 + gamma/p.n*180/math.Pi
+}
+
+// WebMercator is the spherical Mercator of web map tiles, EPSG:3857.
+type WebMercator struct{}
+
+func (WebMercator) Forward(lat, lon float64) (x, y float64) {
+	return WGS84.A * DegToRad(lon), WGS84.A * math.Log(math.Tan(math.Pi/4+DegToRad(lat)/2))
+}
+
+func (WebMercator) Inverse(x, y float64) (lat, lon float64) {
+	lat = (2*math.Atan(math.Exp(y/WGS84.A)) - math.Pi/2) * 180 / math.Pi
+	return lat, x / WGS84.A * 180 / math.Pi
+}
+
+// Geographic is the identity projection of EPSG:4326, x is the longitude
+// and y the latitude in degrees.
+type Geographic struct{}
+
+func (Geographic) Forward(lat, lon float64) (x, y float64) {
+	return lon, lat
+}
+
+func (Geographic) Inverse(x, y float64) (lat, lon float64) {
+	return y, x
+}
+
+const (
+	EPSGWGS84       = 4326
+	EPSGLambert93   = 2154
+	EPSGWebMercator = 3857
+)
+
+var (
+	projectionRegistryMu sync.RWMutex
+	projectionRegistry   = map[int]func() Projection{
+		EPSGWGS84:       func() Projection { return Geographic{} },
+		EPSGWebMercator: func() Projection { return WebMercator{} },
+		EPSGLambert93: func() Projection {
+			return NewLambertConformalConic2SP(GRS80, 46.5, 3, 44, 49, 700000, 6600000)
+		},
+	}
+)
+
+func init() {
+	// UTM zones, WGS84 north 326xx and south 327xx
+	for zone := 1; zone <= 60; zone++ {
+		projectionRegistry[32600+zone] = func() Projection { return NewUTM(zone, true) }
+		projectionRegistry[32700+zone] = func() Projection { return NewUTM(zone, false) }
+	}
+
+	// RGF93 conic conformal zones CC42 to CC50, EPSG:3942 to EPSG:3950
+	for zone := 42; zone <= 50; zone++ {
+		lat0 := float64(zone)
+		falseNorthing := float64(zone-41)*1000000 + 200000
+		projectionRegistry[3900+zone] = func() Projection {
+			return NewLambertConformalConic2SP(GRS80, lat0, 3, lat0-0.75, lat0+0.75, 1700000, falseNorthing)
+		}
+	}
+}
+
+// RegisterProjection makes a projection available under an EPSG code,
+// replacing any existing definition.
+func RegisterProjection(code int, constructor func() Projection) {
+	projectionRegistryMu.Lock()
+	defer projectionRegistryMu.Unlock()
+	projectionRegistry[code] = constructor
+}
+
+func ProjectionForEPSG(code int) (Projection, error) {
+	projectionRegistryMu.RLock()
+	constructor, ok := projectionRegistry[code]
+	projectionRegistryMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown projection EPSG:%d", code)
+	}
+	return constructor(), nil
+}
+
+func RegisteredEPSGCodes() []int {
+	projectionRegistryMu.RLock()
+	defer projectionRegistryMu.RUnlock()
+
+	codes := make([]int, 0, len(projectionRegistry))
+	for code := range projectionRegistry {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	return codes
+}
+
+func ToProjected(p Projection, lat, lon CoordinateDecimal) (x, y float64, err error) {
+	if lat.CoordType != Latitude || lon.CoordType != Longitude {
+		return 0, 0, fmt.Errorf("expected a latitude and a longitude, got types %d and %d", lat.CoordType, lon.CoordType)
+	}
+	x, y = p.Forward(lat.DecimalDegree, lon.DecimalDegree)
+	return x, y, nil
+}
+
+func FromProjected(p Projection, x, y float64) (lat, lon CoordinateDecimal) {
+	latDeg, lonDeg := p.Inverse(x, y)
+	return MakeCoordinateDecimal(latDeg, Latitude), MakeCoordinateDecimal(lonDeg, Longitude)
+}
+
+// Reproject converts planar coordinates between two EPSG systems through
+// geographic coordinates. Both systems must share the WGS84/GRS80 datum.
+func Reproject(fromCode, toCode int, x, y float64) (float64, float64, error) {
+	from, err := ProjectionForEPSG(fromCode)
+	if err != nil {
+		return 0, 0, err
+	}
+	to, err := ProjectionForEPSG(toCode)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	lat, lon := from.Inverse(x, y)
+	outX, outY := to.Forward(lat, lon)
+	return outX, outY, nil
+}
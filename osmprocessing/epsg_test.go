@@ -0,0 +1,160 @@
+package osmprocessing
+
+import (
+	"math"
+	"testing"
+)
+
+// Test vectors from IGN, "Projection cartographique conique conforme de
+// Lambert", Notes techniques NT/G 71.
+
+func TestIsometricLatitude(t *testing.T) {
+	e := 0.08199188998
+
+	tests := []struct {
+		phi, l float64
+	}{
+		{0.872664626, 1.00552653648},
+		{-0.3, -0.30261690060},
+		{0.19998903370, 0.2},
+	}
+
+	for _, tt := range tests {
+		if got := isometricLatitude(tt.phi, e); math.Abs(got-tt.l) > 1e-10 {
+			t.Errorf("ALG0001 phi=%v: got %.11f want %.11f", tt.phi, got, tt.l)
+		}
+		if got := latitudeFromIsometric(tt.l, e); math.Abs(got-tt.phi) > 1e-10 {
+			t.Errorf("ALG0002 L=%v: got %.11f want %.11f", tt.l, got, tt.phi)
+		}
+	}
+}
+
+func TestLambertConformalConicIGN(t *testing.T) {
+	e := 0.08248325676
+	p := &LambertConformalConic{
+		Ellipsoid: Ellipsoid{A: 1, F: 1 - math.Sqrt(1-e*e)},
+		Lon0:      0.04079234433 * 180 / math.Pi,
+		n:         0.760405966,
+		c:         11603796.9767,
+		xs:        600000,
+		ys:        5657616.674,
+	}
+
+	t.Run("ALG0003 forward", func(t *testing.T) {
+		x, y := p.Forward(0.872664626*180/math.Pi, 0.145512099*180/math.Pi)
+		if math.Abs(x-1029705.0818) > 1e-3 || math.Abs(y-272723.8510) > 1e-3 {
+			t.Errorf("got %.4f,%.4f want 1029705.0818,272723.8510", x, y)
+		}
+	})
+
+	t.Run("ALG0004 inverse", func(t *testing.T) {
+		lat, lon := p.Inverse(1029705.083, 272723.849)
+		if math.Abs(DegToRad(lat)-0.872664626) > 1e-9 || math.Abs(DegToRad(lon)-0.145512099) > 1e-9 {
+			t.Errorf("got %.9f,%.9f rad want 0.872664626,0.145512099", DegToRad(lat), DegToRad(lon))
+		}
+	})
+}
+
+func TestLambert93(t *testing.T) {
+	p, err := ProjectionForEPSG(EPSGLambert93)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("published constants", func(t *testing.T) {
+		lcc := p.(*LambertConformalConic)
+		if math.Abs(lcc.n-0.7256077650) > 1e-9 || math.Abs(lcc.c-11754255.426) > 1e-3 || math.Abs(lcc.ys-12655612.050) > 1e-3 {
+			t.Errorf("got n=%.10f C=%.4f Ys=%.4f", lcc.n, lcc.c, lcc.ys)
+		}
+	})
+
+	t.Run("origin", func(t *testing.T) {
+		x, y := p.Forward(46.5, 3)
+		if math.Abs(x-700000) > 1e-6 || math.Abs(y-6600000) > 1e-6 {
+			t.Errorf("got %.6f,%.6f", x, y)
+		}
+	})
+
+	t.Run("coordinate decimal round trip", func(t *testing.T) {
+		lat := ToDecimalCoord(44, 50, 16, North)
+		lon := ToDecimalCoord(0, 34, 45, West)
+
+		x, y, err := ToProjected(p, lat, lon)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gotLat, gotLon := FromProjected(p, x, y)
+		if gotLat.CoordType != Latitude || gotLon.CoordType != Longitude {
+			t.Fatal("expected typed coordinates back")
+		}
+		if math.Abs(gotLat.DecimalDegree-lat.DecimalDegree) > 1e-10 || math.Abs(gotLon.DecimalDegree-lon.DecimalDegree) > 1e-10 {
+			t.Errorf("round trip gave %v %v", gotLat, gotLon)
+		}
+	})
+
+	t.Run("rejects swapped coordinates", func(t *testing.T) {
+		lat := MakeCoordinateDecimal(44.8, Latitude)
+		lon := MakeCoordinateDecimal(-0.5, Longitude)
+		if _, _, err := ToProjected(p, lon, lat); err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
+
+func TestEPSGRegistry(t *testing.T) {
+	t.Run("conic conformal zone origin", func(t *testing.T) {
+		p, err := ProjectionForEPSG(3945)
+		if err != nil {
+			t.Fatal(err)
+		}
+		x, y := p.Forward(45, 3)
+		if math.Abs(x-1700000) > 1e-6 || math.Abs(y-4200000) > 1e-6 {
+			t.Errorf("CC45 origin got %.6f,%.6f", x, y)
+		}
+	})
+
+	t.Run("web mercator", func(t *testing.T) {
+		p, _ := ProjectionForEPSG(EPSGWebMercator)
+		x, y := p.Forward(0, 180)
+		if math.Abs(x-20037508.342789244) > 1e-6 || math.Abs(y) > 1e-6 {
+			t.Errorf("got %.6f,%.6f", x, y)
+		}
+		lat, lon := p.Inverse(p.Forward(85.0511287798, -120))
+		if math.Abs(lat-85.0511287798) > 1e-9 || math.Abs(lon+120) > 1e-9 {
+			t.Errorf("round trip gave %.10f,%.10f", lat, lon)
+		}
+	})
+
+	t.Run("utm matches direct construction", func(t *testing.T) {
+		p, _ := ProjectionForEPSG(32630)
+		x, y := p.Forward(44.8378, -0.5792)
+		wantX, wantY := NewUTM(30, true).Forward(44.8378, -0.5792)
+		if x != wantX || y != wantY {
+			t.Errorf("got %.3f,%.3f want %.3f,%.3f", x, y, wantX, wantY)
+		}
+	})
+
+	t.Run("lambert 93 to utm", func(t *testing.T) {
+		x, y, err := Reproject(EPSGLambert93, 32630, 700000, 6600000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantX, wantY := NewUTM(30, true).Forward(46.5, 3)
+		if math.Abs(x-wantX) > 1e-3 || math.Abs(y-wantY) > 1e-3 {
+			t.Errorf("got %.3f,%.3f want %.3f,%.3f", x, y, wantX, wantY)
+		}
+	})
+
+	t.Run("unknown code", func(t *testing.T) {
+		if _, err := ProjectionForEPSG(9999); err == nil {
+			t.Error("expected an error")
+		}
+	})
+
+	t.Run("register", func(t *testing.T) {
+		RegisterProjection(900913, func() Projection { return WebMercator{} })
+		if _, err := ProjectionForEPSG(900913); err != nil {
+			t.Error(err)
+		}
+	})
+}
@@ -0,0 +1,739 @@
+package osmprocessing
+
+import (
+	"math"
+)
+
+// KarneyGeodesy solves geodesics to round-off accuracy for any pair of
+// points, including nearly antipodal ones. It is a port of the series
+// expansions (order 6) of GeographicLib, see C. F. F. Karney, "Algorithms
+// for geodesics", J. Geodesy 87(1), 43-55 (2013).
+type KarneyGeodesy struct {
+	a, f, f1, e2, ep2, n, b float64
+	etol2                   float64
+	a3x                     [karneyOrder]float64
+	c3x                     [15]float64
+}
+
+const karneyOrder = 6
+
+var (
+	karneyTiny = math.Sqrt(math.SmallestNonzeroFloat64)
+	karneyTol0 = math.Nextafter(1, 2) - 1
+	karneyTol1 = 200 * karneyTol0
+	karneyTol2 = math.Sqrt(karneyTol0)
+	karneyTolb = karneyTol0 * karneyTol2
+	karneyXthr = 1000 * karneyTol2
+	karneyMax1 = 20
+	karneyMax2 = karneyMax1 + 53 + 10
+)
+
+func NewKarneyGeodesy(e Ellipsoid) *KarneyGeodesy {
+	g := &KarneyGeodesy{a: e.A, f: e.F}
+	g.f1 = 1 - g.f
+	g.e2 = g.f * (2 - g.f)
+	g.ep2 = g.e2 / (g.f1 * g.f1)
+	g.n = g.f / (2 - g.f)
+	g.b = g.a * g.f1
+	g.etol2 = 0.1 * karneyTol2 / math.Sqrt(math.Max(0.001, math.Abs(g.f))*math.Min(1, 1-g.f/2)/2)
+
+	g.a3coeff()
+	g.c3coeff()
+	return g
+}
+
+func polyval(n int, p []float64, s int, x float64) float64 {
+	y := 0.0
+	if n >= 0 {
+		y = p[s]
+	}
+	for n > 0 {
+		n--
+		s++
+		y = y*x + p[s]
+	}
+	return y
+}
+
+func sumErr(u, v float64) (s, t float64) {
+	s = u + v
+	up := s - v
+	vpp := s - up
+	up -= u
+	vpp -= v
+	t = -(up + vpp)
+	return s, t
+}
+
+func angRound(x float64) float64 {
+	z := 1.0 / 16
+	y := math.Abs(x)
+	if y < z {
+		y = z - (z - y)
+	}
+	return math.Copysign(y, x)
+}
+
+func angNormalize(x float64) float64 {
+	y := math.Remainder(x, 360)
+	if math.Abs(y) == 180 {
+		return math.Copysign(180, x)
+	}
+	return y
+}
+
+func angDiff(x, y float64) (d, t float64) {
+	d, t = sumErr(math.Remainder(-x, 360), math.Remainder(y, 360))
+	d, t = sumErr(math.Remainder(d, 360), t)
+	if d == 0 || math.Abs(d) == 180 {
+		if t == 0 {
+			d = math.Copysign(d, y-x)
+		} else {
+			d = math.Copysign(d, -t)
+		}
+	}
+	return d, t
+}
+
+// sincosd reduces the angle exactly before converting to radians so that
+// sincosd(90) is exactly (1, 0).
+func sincosd(x float64) (s, c float64) {
+	r := math.Mod(x, 360)
+	q := math.Round(r / 90)
+	r -= 90 * q
+	s, c = math.Sincos(DegToRad(r))
+
+	switch int(q) & 3 {
+	case 1:
+		s, c = c, -s
+	case 2:
+		s, c = -s, -c
+	case 3:
+		s, c = -c, s
+	}
+	return s, c
+}
+
+func atan2d(y, x float64) float64 {
+	q := 0
+	if math.Abs(y) > math.Abs(x) {
+		x, y = y, x
+		q = 2
+	}
+	if x < 0 {
+		x = -x
+		q++
+	}
+	ang := math.Atan2(y, x) * 180 / math.Pi
+	switch q {
+	case 1:
+		ang = math.Copysign(180, y) - ang
+	case 2:
+		ang = 90 - ang
+	case 3:
+		ang = -90 + ang
+	}
+	return ang
+}
+
+func norm2(x, y float64) (float64, float64) {
+	r := math.Hypot(x, y)
+	return x / r, y / r
+}
+
+func latFix(x float64) float64 {
+	if math.Abs(x) > 90 {
+		return math.NaN()
+	}
+	return x
+}
+
+func sinCosSeries(sinp bool, sinx, cosx float64, c []float64) float64 {
+	k := len(c)
+	n := k
+	if sinp {
+		n--
+	}
+	ar := 2 * (cosx - sinx) * (cosx + sinx)
+	y0, y1 := 0.0, 0.0
+	if n&1 != 0 {
+		k--
+		y0 = c[k]
+	}
+	n /= 2
+	for n > 0 {
+		n--
+		k--
+		y1 = ar*y0 - y1 + c[k]
+		k--
+		y0 = ar*y1 - y0 + c[k]
+	}
+	if sinp {
+		return 2 * sinx * cosx * y0
+	}
+	return cosx * (y0 - y1)
+}
+
+func a1m1f(eps float64) float64 {
+	coeff := []float64{1, 4, 64, 0, 256}
+	m := karneyOrder / 2
+	t := polyval(m, coeff, 0, eps*eps) / coeff[m+1]
+	return (t + eps) / (1 - eps)
+}
+
+func c1f(eps float64, c []float64) {
+	coeff := []float64{
+		-1, 6, -16, 32,
+		-9, 64, -128, 2048,
+		9, -16, 768,
+		3, -5, 512,
+		-7, 1280,
+		-7, 2048,
+	}
+	seriesCoeffs(eps, c, coeff)
+}
+
+func c1pf(eps float64, c []float64) {
+	coeff := []float64{
+		205, -432, 768, 1536,
+		4005, -4736, 3840, 12288,
+		-225, 116, 384,
+		-7173, 2695, 7680,
+		3467, 7680,
+		38081, 61440,
+	}
+	seriesCoeffs(eps, c, coeff)
+}
+
+func a2m1f(eps float64) float64 {
+	coeff := []float64{-11, -28, -192, 0, 256}
+	m := karneyOrder / 2
+	t := polyval(m, coeff, 0, eps*eps) / coeff[m+1]
+	return (t - eps) / (1 + eps)
+}
+
+func c2f(eps float64, c []float64) {
+	coeff := []float64{
+		1, 2, 16, 32,
+		35, 64, 384, 2048,
+		15, 80, 768,
+		7, 35, 512,
+		63, 1280,
+		77, 2048,
+	}
+	seriesCoeffs(eps, c, coeff)
+}
+
+// seriesCoeffs evaluates the C1, C1' and C2 coefficient tables, c[0] is
+// unused.
+func seriesCoeffs(eps float64, c []float64, coeff []float64) {
+	eps2 := eps * eps
+	d := eps
+	o := 0
+	for l := 1; l <= karneyOrder; l++ {
+		m := (karneyOrder - l) / 2
+		c[l] = d * polyval(m, coeff, o, eps2) / coeff[o+m+1]
+		o += m + 2
+		d *= eps
+	}
+}
+
+func (g *KarneyGeodesy) a3coeff() {
+	coeff := []float64{
+		-3, 128,
+		-2, -3, 64,
+		-1, -3, -1, 16,
+		3, -1, -2, 8,
+		1, -1, 2,
+		1, 1,
+	}
+	o, k := 0, 0
+	for j := karneyOrder - 1; j >= 0; j-- {
+		m := min(karneyOrder-j-1, j)
+		g.a3x[k] = polyval(m, coeff, o, g.n) / coeff[o+m+1]
+		k++
+		o += m + 2
+	}
+}
+
+func (g *KarneyGeodesy) c3coeff() {
+	coeff := []float64{
+		3, 128,
+		2, 5, 128,
+		-1, 3, 3, 64,
+		-1, 0, 1, 8,
+		-1, 1, 4,
+		5, 256,
+		1, 3, 128,
+		-3, -2, 3, 64,
+		1, -3, 2, 32,
+		7, 512,
+		-10, 9, 384,
+		5, -9, 5, 192,
+		7, 512,
+		-14, 7, 512,
+		21, 2560,
+	}
+	o, k := 0, 0
+	for l := 1; l < karneyOrder; l++ {
+		for j := karneyOrder - 1; j >= l; j-- {
+			m := min(karneyOrder-j-1, j)
+			g.c3x[k] = polyval(m, coeff, o, g.n) / coeff[o+m+1]
+			k++
+			o += m + 2
+		}
+	}
+}
+
+func (g *KarneyGeodesy) a3f(eps float64) float64 {
+	return polyval(karneyOrder-1, g.a3x[:], 0, eps)
+}
+
+func (g *KarneyGeodesy) c3f(eps float64, c []float64) {
+	mult := 1.0
+	o := 0
+	for l := 1; l < karneyOrder; l++ {
+		m := karneyOrder - l - 1
+		mult *= eps
+		c[l] = mult * polyval(m, g.c3x[:], o, eps)
+		o += m + 1
+	}
+}
+
+// lengths returns the scaled distance s12/b, reduced length m12/b and m0.
+func (g *KarneyGeodesy) lengths(eps, sig12, ssig1, csig1, dn1, ssig2, csig2, dn2 float64,
+	c1a, c2a []float64) (s12b, m12b, m0 float64) {
+
+	a1 := a1m1f(eps)
+	c1f(eps, c1a)
+	a2 := a2m1f(eps)
+	c2f(eps, c2a)
+	m0 = a1 - a2
+	a2 = 1 + a2
+	a1 = 1 + a1
+
+	b1 := sinCosSeries(true, ssig2, csig2, c1a) - sinCosSeries(true, ssig1, csig1, c1a)
+	s12b = a1 * (sig12 + b1)
+	b2 := sinCosSeries(true, ssig2, csig2, c2a) - sinCosSeries(true, ssig1, csig1, c2a)
+	j12 := m0*sig12 + (a1*b1 - a2*b2)
+
+	m12b = dn2*(csig1*ssig2) - dn1*(ssig1*csig2) - csig1*csig2*j12
+	return s12b, m12b, m0
+}
+
+func astroid(x, y float64) float64 {
+	p := x * x
+	q := y * y
+	r := (p + q - 1) / 6
+	if q == 0 && r <= 0 {
+		return 0
+	}
+
+	s := p * q / 4
+	r2 := r * r
+	r3 := r * r2
+	disc := s * (s + 2*r3)
+	u := r
+	if disc >= 0 {
+		t3 := s + r3
+		if t3 < 0 {
+			t3 -= math.Sqrt(disc)
+		} else {
+			t3 += math.Sqrt(disc)
+		}
+		t := math.Cbrt(t3)
+		u += t
+		if t != 0 {
+			u += r2 / t
+		}
+	} else {
+		ang := math.Atan2(math.Sqrt(-disc), -(s + r3))
+		u += 2 * r * math.Cos(ang/3)
+	}
+	v := math.Sqrt(u*u + q)
+	var uv float64
+	if u < 0 {
+		uv = q / (v - u)
+	} else {
+		uv = u + v
+	}
+	w := (uv - q) / (2 * v)
+	return uv / (math.Sqrt(uv+w*w) + w)
+}
+
+func (g *KarneyGeodesy) inverseStart(sbet1, cbet1, dn1, sbet2, cbet2, dn2, lam12, slam12, clam12 float64,
+	c1a, c2a []float64) (sig12, salp1, calp1, salp2, calp2, dnm float64) {
+
+	sig12 = -1
+	sbet12 := sbet2*cbet1 - cbet2*sbet1
+	cbet12 := cbet2*cbet1 + sbet2*sbet1
+	sbet12a := sbet2*cbet1 + cbet2*sbet1
+
+	shortline := cbet12 >= 0 && sbet12 < 0.5 && cbet2*lam12 < 0.5
+	var somg12, comg12 float64
+	if shortline {
+		sbetm2 := (sbet1 + sbet2) * (sbet1 + sbet2)
+		sbetm2 /= sbetm2 + (cbet1+cbet2)*(cbet1+cbet2)
+		dnm = math.Sqrt(1 + g.ep2*sbetm2)
+		omg12 := lam12 / (g.f1 * dnm)
+		somg12, comg12 = math.Sincos(omg12)
+	} else {
+		somg12, comg12 = slam12, clam12
+	}
+
+	salp1 = cbet2 * somg12
+	if comg12 >= 0 {
+		calp1 = sbet12 + cbet2*sbet1*somg12*somg12/(1+comg12)
+	} else {
+		calp1 = sbet12a - cbet2*sbet1*somg12*somg12/(1-comg12)
+	}
+
+	ssig12 := math.Hypot(salp1, calp1)
+	csig12 := sbet1*sbet2 + cbet1*cbet2*comg12
+
+	if shortline && ssig12 < g.etol2 {
+		salp2 = cbet1 * somg12
+		if comg12 >= 0 {
+			calp2 = sbet12 - cbet1*sbet2*(somg12*somg12/(1+comg12))
+		} else {
+			calp2 = sbet12 - cbet1*sbet2*(1-comg12)
+		}
+		salp2, calp2 = norm2(salp2, calp2)
+		sig12 = math.Atan2(ssig12, csig12)
+	} else if math.Abs(g.n) >= 0.1 || csig12 >= 0 || ssig12 >= 6*math.Abs(g.n)*math.Pi*cbet1*cbet1 {
+		// zeroth order spherical approximation is good enough
+	} else {
+		// nearly antipodal, scale to the astroid problem
+		lam12x := math.Atan2(-slam12, -clam12)
+		k2 := sbet1 * sbet1 * g.ep2
+		eps := k2 / (2*(1+math.Sqrt(1+k2)) + k2)
+		lamscale := g.f * cbet1 * g.a3f(eps) * math.Pi
+		betscale := lamscale * cbet1
+		x := lam12x / lamscale
+		y := sbet12a / betscale
+
+		if y > -karneyTol1 && x > -1-karneyXthr {
+			salp1 = math.Min(1, -x)
+			calp1 = -math.Sqrt(1 - salp1*salp1)
+		} else {
+			k := astroid(x, y)
+			omg12a := lamscale * (-x * k / (1 + k))
+			somg12, comg12 = math.Sincos(omg12a)
+			comg12 = -comg12
+			salp1 = cbet2 * somg12
+			calp1 = sbet12a - cbet2*sbet1*somg12*somg12/(1-comg12)
+		}
+	}
+
+	if salp1 > 0 {
+		salp1, calp1 = norm2(salp1, calp1)
+	} else {
+		salp1, calp1 = 1, 0
+	}
+	return sig12, salp1, calp1, salp2, calp2, dnm
+}
+
+type lambda12Result struct {
+	lam12, salp2, calp2, sig12, ssig1, csig1, ssig2, csig2, eps, dlam12 float64
+}
+
+func (g *KarneyGeodesy) lambda12(sbet1, cbet1, dn1, sbet2, cbet2, dn2, salp1, calp1, slam120, clam120 float64,
+	diffp bool, c1a, c2a, c3a []float64) lambda12Result {
+
+	var r lambda12Result
+
+	if sbet1 == 0 && calp1 == 0 {
+		calp1 = -karneyTiny
+	}
+
+	salp0 := salp1 * cbet1
+	calp0 := math.Hypot(calp1, salp1*sbet1)
+
+	ssig1 := sbet1
+	somg1 := salp0 * sbet1
+	csig1 := calp1 * cbet1
+	comg1 := csig1
+	ssig1, csig1 = norm2(ssig1, csig1)
+
+	if cbet2 != cbet1 {
+		r.salp2 = salp0 / cbet2
+	} else {
+		r.salp2 = salp1
+	}
+
+	if cbet2 != cbet1 || math.Abs(sbet2) != -sbet1 {
+		var t float64
+		if cbet1 < -sbet1 {
+			t = (cbet2 - cbet1) * (cbet1 + cbet2)
+		} else {
+			t = (sbet1 - sbet2) * (sbet1 + sbet2)
+		}
+		r.calp2 = math.Sqrt((calp1*cbet1)*(calp1*cbet1)+t) / cbet2
+	} else {
+		r.calp2 = math.Abs(calp1)
+	}
+
+	ssig2 := sbet2
+	somg2 := salp0 * sbet2
+	csig2 := r.calp2 * cbet2
+	comg2 := csig2
+	ssig2, csig2 = norm2(ssig2, csig2)
+
+	r.sig12 = math.Atan2(math.Max(0, csig1*ssig2-ssig1*csig2), csig1*csig2+ssig1*ssig2)
+	somg12 := math.Max(0, comg1*somg2-somg1*comg2)
+	comg12 := comg1*comg2 + somg1*somg2
+	eta := math.Atan2(somg12*clam120-comg12*slam120, comg12*clam120+somg12*slam120)
+
+	k2 := calp0 * calp0 * g.ep2
+	r.eps = k2 / (2*(1+math.Sqrt(1+k2)) + k2)
+	g.c3f(r.eps, c3a)
+	b312 := sinCosSeries(true, ssig2, csig2, c3a) - sinCosSeries(true, ssig1, csig1, c3a)
+	domg12 := -g.f * g.a3f(r.eps) * salp0 * (r.sig12 + b312)
+	r.lam12 = eta + domg12
+
+	if diffp {
+		if r.calp2 == 0 {
+			r.dlam12 = -2 * g.f1 * dn1 / sbet1
+		} else {
+			_, m12b, _ := g.lengths(r.eps, r.sig12, ssig1, csig1, dn1, ssig2, csig2, dn2, c1a, c2a)
+			r.dlam12 = m12b * g.f1 / (r.calp2 * cbet2)
+		}
+	} else {
+		r.dlam12 = math.NaN()
+	}
+
+	r.ssig1, r.csig1, r.ssig2, r.csig2 = ssig1, csig1, ssig2, csig2
+	return r
+}
+
+func (g *KarneyGeodesy) Inverse(lat1, lon1, lat2, lon2 float64) (distance, azi1, azi2 float64) {
+	lat1 = latFix(lat1)
+	lat2 = latFix(lat2)
+
+	lon12, lon12s := angDiff(lon1, lon2)
+	lonsign := math.Copysign(1, lon12)
+	lon12 = lonsign * angRound(lon12)
+	lon12s = angRound((180 - lon12) - lonsign*lon12s)
+	lam12 := DegToRad(lon12)
+
+	var slam12, clam12 float64
+	if lon12 > 90 {
+		slam12, clam12 = sincosd(lon12s)
+		clam12 = -clam12
+	} else {
+		slam12, clam12 = sincosd(lon12)
+	}
+
+	lat1 = angRound(lat1)
+	lat2 = angRound(lat2)
+
+	swapp := 1.0
+	if math.Abs(lat1) < math.Abs(lat2) || math.IsNaN(lat2) {
+		swapp = -1
+		lonsign *= -1
+		lat1, lat2 = lat2, lat1
+	}
+	latsign := math.Copysign(1, -lat1)
+	lat1 *= latsign
+	lat2 *= latsign
+
+	sbet1, cbet1 := sincosd(lat1)
+	sbet1 *= g.f1
+	sbet1, cbet1 = norm2(sbet1, cbet1)
+	cbet1 = math.Max(karneyTiny, cbet1)
+
+	sbet2, cbet2 := sincosd(lat2)
+	sbet2 *= g.f1
+	sbet2, cbet2 = norm2(sbet2, cbet2)
+	cbet2 = math.Max(karneyTiny, cbet2)
+
+	if cbet1 < -sbet1 {
+		if cbet2 == cbet1 {
+			sbet2 = math.Copysign(sbet1, sbet2)
+		}
+	} else if math.Abs(sbet2) == -sbet1 {
+		cbet2 = cbet1
+	}
+
+	dn1 := math.Sqrt(1 + g.ep2*sbet1*sbet1)
+	dn2 := math.Sqrt(1 + g.ep2*sbet2*sbet2)
+
+	c1a := make([]float64, karneyOrder+1)
+	c2a := make([]float64, karneyOrder+1)
+	c3a := make([]float64, karneyOrder)
+
+	var s12x, m12x, sig12, salp1, calp1, salp2, calp2 float64
+
+	meridian := lat1 == -90 || slam12 == 0
+	if meridian {
+		calp1, salp1 = clam12, slam12
+		calp2, salp2 = 1, 0
+
+		ssig1, csig1 := sbet1, calp1*cbet1
+		ssig2, csig2 := sbet2, calp2*cbet2
+
+		sig12 = math.Atan2(math.Max(0, csig1*ssig2-ssig1*csig2), csig1*csig2+ssig1*ssig2)
+		s12x, m12x, _ = g.lengths(g.n, sig12, ssig1, csig1, dn1, ssig2, csig2, dn2, c1a, c2a)
+
+		if sig12 < 1 || m12x >= 0 {
+			if sig12 < 3*karneyTiny || (sig12 < karneyTol0 && (s12x < 0 || m12x < 0)) {
+				sig12, m12x, s12x = 0, 0, 0
+			}
+			m12x *= g.b
+			s12x *= g.b
+		} else {
+			meridian = false
+		}
+	}
+
+	if !meridian && sbet1 == 0 && (g.f <= 0 || lon12s >= g.f*180) {
+		// along the equator
+		calp1, calp2 = 0, 0
+		salp1, salp2 = 1, 1
+		s12x = g.a * lam12
+	} else if !meridian {
+		var dnm float64
+		sig12, salp1, calp1, salp2, calp2, dnm = g.inverseStart(sbet1, cbet1, dn1, sbet2, cbet2, dn2,
+			lam12, slam12, clam12, c1a, c2a)
+
+		if sig12 >= 0 {
+			s12x = sig12 * g.b * dnm
+		} else {
+			numit := 0
+			tripn, tripb := false, false
+			salp1a, calp1a := karneyTiny, 1.0
+			salp1b, calp1b := karneyTiny, -1.0
+
+			var r lambda12Result
+			for numit < karneyMax2 {
+				r = g.lambda12(sbet1, cbet1, dn1, sbet2, cbet2, dn2, salp1, calp1, slam12, clam12,
+					numit < karneyMax1, c1a, c2a, c3a)
+				v := r.lam12
+				salp2, calp2 = r.salp2, r.calp2
+
+				tolerance := karneyTol0
+				if tripn {
+					tolerance *= 8
+				}
+				if tripb || !(math.Abs(v) >= tolerance) {
+					break
+				}
+
+				if v > 0 && (numit > karneyMax1 || calp1/salp1 > calp1b/salp1b) {
+					salp1b, calp1b = salp1, calp1
+				} else if v < 0 && (numit > karneyMax1 || calp1/salp1 < calp1a/salp1a) {
+					salp1a, calp1a = salp1, calp1
+				}
+
+				numit++
+				if numit < karneyMax1 && r.dlam12 > 0 {
+					dalp1 := -v / r.dlam12
+					if math.Abs(dalp1) < math.Pi {
+						sdalp1, cdalp1 := math.Sincos(dalp1)
+						nsalp1 := salp1*cdalp1 + calp1*sdalp1
+						if nsalp1 > 0 {
+							calp1 = calp1*cdalp1 - salp1*sdalp1
+							salp1 = nsalp1
+							salp1, calp1 = norm2(salp1, calp1)
+							tripn = math.Abs(v) <= 16*karneyTol0
+							continue
+						}
+					}
+				}
+
+				// Newton's method failed, bisect
+				salp1 = (salp1a + salp1b) / 2
+				calp1 = (calp1a + calp1b) / 2
+				salp1, calp1 = norm2(salp1, calp1)
+				tripn = false
+				tripb = math.Abs(salp1a-salp1)+(calp1a-calp1) < karneyTolb ||
+					math.Abs(salp1-salp1b)+(calp1-calp1b) < karneyTolb
+			}
+
+			s12x, _, _ = g.lengths(r.eps, r.sig12, r.ssig1, r.csig1, dn1, r.ssig2, r.csig2, dn2, c1a, c2a)
+			s12x *= g.b
+		}
+	}
+
+	distance = 0 + s12x
+
+	if swapp < 0 {
+		salp1, salp2 = salp2, salp1
+		calp1, calp2 = calp2, calp1
+	}
+
+	salp1 *= swapp * lonsign
+	calp1 *= swapp * latsign
+	salp2 *= swapp * lonsign
+	calp2 *= swapp * latsign
+
+	azi1 = NormalizeBearing(atan2d(salp1, calp1))
+	azi2 = NormalizeBearing(atan2d(salp2, calp2))
+	return distance, azi1, azi2
+}
+
+func (g *KarneyGeodesy) Direct(lat1, lon1, azi1, distance float64) (lat2, lon2, azi2 float64) {
+	salp1, calp1 := sincosd(angRound(azi1))
+
+	sbet1, cbet1 := sincosd(angRound(latFix(lat1)))
+	sbet1 *= g.f1
+	sbet1, cbet1 = norm2(sbet1, cbet1)
+	cbet1 = math.Max(karneyTiny, cbet1)
+
+	salp0 := salp1 * cbet1
+	calp0 := math.Hypot(calp1, salp1*sbet1)
+
+	ssig1 := sbet1
+	somg1 := salp0 * sbet1
+	csig1 := 1.0
+	if sbet1 != 0 || calp1 != 0 {
+		csig1 = cbet1 * calp1
+	}
+	comg1 := csig1
+	ssig1, csig1 = norm2(ssig1, csig1)
+
+	k2 := calp0 * calp0 * g.ep2
+	eps := k2 / (2*(1+math.Sqrt(1+k2)) + k2)
+
+	a1m1 := a1m1f(eps)
+	c1a := make([]float64, karneyOrder+1)
+	c1f(eps, c1a)
+	b11 := sinCosSeries(true, ssig1, csig1, c1a)
+	s, c := math.Sincos(b11)
+	stau1 := ssig1*c + csig1*s
+	ctau1 := csig1*c - ssig1*s
+
+	c1pa := make([]float64, karneyOrder+1)
+	c1pf(eps, c1pa)
+
+	a3c := -g.f * salp0 * g.a3f(eps)
+	c3a := make([]float64, karneyOrder)
+	g.c3f(eps, c3a)
+	b31 := sinCosSeries(true, ssig1, csig1, c3a)
+
+	tau12 := distance / (g.b * (1 + a1m1))
+	s, c = math.Sincos(tau12)
+	b12 := -sinCosSeries(true, stau1*c+ctau1*s, ctau1*c-stau1*s, c1pa)
+	sig12 := tau12 - (b12 - b11)
+	ssig12, csig12 := math.Sincos(sig12)
+
+	ssig2 := ssig1*csig12 + csig1*ssig12
+	csig2 := csig1*csig12 - ssig1*ssig12
+
+	sbet2 := calp0 * ssig2
+	cbet2 := math.Hypot(salp0, calp0*csig2)
+	if cbet2 == 0 {
+		cbet2, csig2 = karneyTiny, karneyTiny
+	}
+	salp2, calp2 := salp0, calp0*csig2
+
+	somg2, comg2 := salp0*ssig2, csig2
+	omg12 := math.Atan2(somg2*comg1-comg2*somg1, comg2*comg1+somg2*somg1)
+	lam12 := omg12 + a3c*(sig12+(sinCosSeries(true, ssig2, csig2, c3a)-b31))
+	lon12 := lam12 * 180 / math.Pi
+
+	lat2 = atan2d(sbet2, g.f1*cbet2)
+	lon2 = angNormalize(angNormalize(lon1) + angNormalize(lon12))
+	azi2 = NormalizeBearing(atan2d(salp2, calp2))
+	return lat2, lon2, azi2
+}
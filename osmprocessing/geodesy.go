@@ -0,0 +1,236 @@
+package osmprocessing
+
+import (
+	"errors"
+	"math"
+	"sync/atomic"
+)
+
+// Geodesy solves the two geodesic problems. Azimuths are in degrees
+// clockwise from north, distances in metres.
+type Geodesy interface {
+	// Inverse returns the distance between two points and the azimuths of
+	// the geodesic at both ends.
+	Inverse(lat1, lon1, lat2, lon2 float64) (distance, azi1, azi2 float64)
+	// Direct returns the point reached by travelling distance along the
+	// geodesic leaving the start point at azimuth azi1.
+	Direct(lat1, lon1, azi1, distance float64) (lat2, lon2, azi2 float64)
+}
+
+var geodesicBackend atomic.Value
+
+func init() {
+	geodesicBackend.Store(geodesyHolder{SphericalGeodesy{}})
+}
+
+// atomic.Value needs a single concrete type across stores
+type geodesyHolder struct {
+	Geodesy
+}
+
+// GeodesicBackend is the backend of GeodesicDistance, DestinationPoint and
+// of the Distance, Bearing and Destination methods of an EnhancedMap that
+// doesn't set its own. It starts out spherical, matching HaversineDistance.
+//
+// Nothing else follows it: HaversineDistance and CalculateBearing are
+// always spherical, and DistanceToWay, the way projections and the indexes
+// work on local planes. Switching to an ellipsoid makes the points
+// DestinationPoint returns more accurate, not the searches on the map.
+func GeodesicBackend() Geodesy {
+	return geodesicBackend.Load().(geodesyHolder).Geodesy
+}
+
+// SetGeodesicBackend replaces the backend, see GeodesicBackend for what it
+// serves.
+func SetGeodesicBackend(g Geodesy) {
+	geodesicBackend.Store(geodesyHolder{g})
+}
+
+func GeodesicDistance(lat1, lon1, lat2, lon2 float64) float64 {
+	distance, _, _ := GeodesicBackend().Inverse(lat1, lon1, lat2, lon2)
+	return distance
+}
+
+// SphericalGeodesy uses a sphere of radius R, it is fast but up to 0.5%
+// off the WGS84 ellipsoid.
+type SphericalGeodesy struct{}
+
+func (SphericalGeodesy) Inverse(lat1, lon1, lat2, lon2 float64) (distance, azi1, azi2 float64) {
+	distance = HaversineDistance(lat1, lon1, lat2, lon2)
+	azi1 = CalculateBearing(lat1, lon1, lat2, lon2)
+	azi2 = NormalizeBearing(CalculateBearing(lat2, lon2, lat1, lon1) + 180)
+	return distance, azi1, azi2
+}
+
+func (SphericalGeodesy) Direct(lat1, lon1, azi1, distance float64) (lat2, lon2, azi2 float64) {
+	phi1 := DegToRad(lat1)
+	lambda1 := DegToRad(lon1)
+	theta := DegToRad(azi1)
+	delta := distance / R
+
+	// φ2 = asin( sin φ1 ⋅ cos δ + cos φ1 ⋅ sin δ ⋅ cos θ )
+	// λ2 = λ1 + atan2( sin θ ⋅ sin δ ⋅ cos φ1, cos δ − sin φ1 ⋅ sin φ2 )
+	phi2 := math.Asin(math.Sin(phi1)*math.Cos(delta) + math.Cos(phi1)*math.Sin(delta)*math.Cos(theta))
+	lambda2 := lambda1 + math.Atan2(math.Sin(theta)*math.Sin(delta)*math.Cos(phi1),
+		math.Cos(delta)-math.Sin(phi1)*math.Sin(phi2))
+
+	lat2 = phi2 * 180 / math.Pi
+	lon2 = normalizeLon(lambda2 * 180 / math.Pi)
+	azi2 = NormalizeBearing(CalculateBearing(lat2, lon2, lat1, lon1) + 180)
+	return lat2, lon2, azi2
+}
+
+var ErrVincentyNoConvergence = errors.New("vincenty: no convergence, points are nearly antipodal")
+
+// VincentyGeodesy implements T. Vincenty, "Direct and inverse solutions of
+// geodesics on the ellipsoid with application of nested equations", Survey
+// Review 23(176), 88-93 (1975). It is accurate to 0.5mm but the inverse
+// iteration fails for nearly antipodal points, where Inverse falls back
+// to Karney's method.
+type VincentyGeodesy struct {
+	Ellipsoid Ellipsoid
+}
+
+func NewVincentyGeodesy() VincentyGeodesy {
+	return VincentyGeodesy{Ellipsoid: WGS84}
+}
+
+func (v VincentyGeodesy) Inverse(lat1, lon1, lat2, lon2 float64) (distance, azi1, azi2 float64) {
+	distance, azi1, azi2, err := v.InverseStrict(lat1, lon1, lat2, lon2)
+	if err != nil {
+		return NewKarneyGeodesy(v.Ellipsoid).Inverse(lat1, lon1, lat2, lon2)
+	}
+	return distance, azi1, azi2
+}
+
+// InverseStrict is Inverse without the fallback, it reports
+// ErrVincentyNoConvergence instead.
+func (v VincentyGeodesy) InverseStrict(lat1, lon1, lat2, lon2 float64) (distance, azi1, azi2 float64, err error) {
+	a, f := v.Ellipsoid.A, v.Ellipsoid.F
+	b := v.Ellipsoid.B()
+
+	L := DegToRad(normalizeLon(lon2 - lon1))
+	tanU1 := (1 - f) * math.Tan(DegToRad(lat1))
+	cosU1 := 1 / math.Sqrt(1+tanU1*tanU1)
+	sinU1 := tanU1 * cosU1
+	tanU2 := (1 - f) * math.Tan(DegToRad(lat2))
+	cosU2 := 1 / math.Sqrt(1+tanU2*tanU2)
+	sinU2 := tanU2 * cosU2
+
+	lambda := L
+	var sinLambda, cosLambda, sinSigma, cosSigma, sigma, cosSqAlpha, cos2SigmaM float64
+
+	converged := false
+	for i := 0; i < 200; i++ {
+		sinLambda, cosLambda = math.Sincos(lambda)
+		sinSigma = math.Hypot(cosU2*sinLambda, cosU1*sinU2-sinU1*cosU2*cosLambda)
+		if sinSigma == 0 {
+			return 0, 0, 0, nil
+		}
+		cosSigma = sinU1*sinU2 + cosU1*cosU2*cosLambda
+		sigma = math.Atan2(sinSigma, cosSigma)
+		sinAlpha := cosU1 * cosU2 * sinLambda / sinSigma
+		cosSqAlpha = 1 - sinAlpha*sinAlpha
+		cos2SigmaM = 0
+		if cosSqAlpha != 0 {
+			// equatorial line has cosSqAlpha = 0
+			cos2SigmaM = cosSigma - 2*sinU1*sinU2/cosSqAlpha
+		}
+		C := f / 16 * cosSqAlpha * (4 + f*(4-3*cosSqAlpha))
+		previous := lambda
+		lambda = L + (1-C)*f*sinAlpha*
+			(sigma+C*sinSigma*(cos2SigmaM+C*cosSigma*(-1+2*cos2SigmaM*cos2SigmaM)))
+
+		if math.Abs(lambda-previous) < 1e-14 {
+			converged = true
+			break
+		}
+		if math.Abs(lambda) > math.Pi {
+			break
+		}
+	}
+	if !converged {
+		return 0, 0, 0, ErrVincentyNoConvergence
+	}
+
+	uSq := cosSqAlpha * (a*a - b*b) / (b * b)
+	A := 1 + uSq/16384*(4096+uSq*(-768+uSq*(320-175*uSq)))
+	B := uSq / 1024 * (256 + uSq*(-128+uSq*(74-47*uSq)))
+	deltaSigma := B * sinSigma * (cos2SigmaM + B/4*(cosSigma*(-1+2*cos2SigmaM*cos2SigmaM)-
+		B/6*cos2SigmaM*(-3+4*sinSigma*sinSigma)*(-3+4*cos2SigmaM*cos2SigmaM)))
+
+	distance = b * A * (sigma - deltaSigma)
+	azi1 = math.Atan2(cosU2*sinLambda, cosU1*sinU2-sinU1*cosU2*cosLambda) * 180 / math.Pi
+	azi2 = math.Atan2(cosU1*sinLambda, -sinU1*cosU2+cosU1*sinU2*cosLambda) * 180 / math.Pi
+
+	return distance, NormalizeBearing(azi1), NormalizeBearing(azi2), nil
+}
+
+func (v VincentyGeodesy) Direct(lat1, lon1, azi1, distance float64) (lat2, lon2, azi2 float64) {
+	a, f := v.Ellipsoid.A, v.Ellipsoid.F
+	b := v.Ellipsoid.B()
+
+	sinAlpha1, cosAlpha1 := math.Sincos(DegToRad(azi1))
+	tanU1 := (1 - f) * math.Tan(DegToRad(lat1))
+	cosU1 := 1 / math.Sqrt(1+tanU1*tanU1)
+	sinU1 := tanU1 * cosU1
+
+	sigma1 := math.Atan2(tanU1, cosAlpha1)
+	sinAlpha := cosU1 * sinAlpha1
+	cosSqAlpha := 1 - sinAlpha*sinAlpha
+	uSq := cosSqAlpha * (a*a - b*b) / (b * b)
+	A := 1 + uSq/16384*(4096+uSq*(-768+uSq*(320-175*uSq)))
+	B := uSq / 1024 * (256 + uSq*(-128+uSq*(74-47*uSq)))
+
+	sigma := distance / (b * A)
+	var sinSigma, cosSigma, cos2SigmaM float64
+
+	for i := 0; i < 200; i++ {
+		cos2SigmaM = math.Cos(2*sigma1 + sigma)
+		sinSigma, cosSigma = math.Sincos(sigma)
+		deltaSigma := B * sinSigma * (cos2SigmaM + B/4*(cosSigma*(-1+2*cos2SigmaM*cos2SigmaM)-
+			B/6*cos2SigmaM*(-3+4*sinSigma*sinSigma)*(-3+4*cos2SigmaM*cos2SigmaM)))
+		previous := sigma
+		sigma = distance/(b*A) + deltaSigma
+		if math.Abs(sigma-previous) < 1e-14 {
+			break
+		}
+	}
+	sinSigma, cosSigma = math.Sincos(sigma)
+	cos2SigmaM = math.Cos(2*sigma1 + sigma)
+
+	x := sinU1*sinSigma - cosU1*cosSigma*cosAlpha1
+	phi2 := math.Atan2(sinU1*cosSigma+cosU1*sinSigma*cosAlpha1, (1-f)*math.Hypot(sinAlpha, x))
+	lambda := math.Atan2(sinSigma*sinAlpha1, cosU1*cosSigma-sinU1*sinSigma*cosAlpha1)
+	C := f / 16 * cosSqAlpha * (4 + f*(4-3*cosSqAlpha))
+	L := lambda - (1-C)*f*sinAlpha*(sigma+C*sinSigma*(cos2SigmaM+C*cosSigma*(-1+2*cos2SigmaM*cos2SigmaM)))
+
+	lat2 = phi2 * 180 / math.Pi
+	lon2 = normalizeLon(lon1 + L*180/math.Pi)
+	azi2 = NormalizeBearing(math.Atan2(sinAlpha, -x) * 180 / math.Pi)
+	return lat2, lon2, azi2
+}
+
+// geodesy returns the backend of the map, or the package default. A nil
+// map uses the default as well.
+func (em *EnhancedMap) geodesy() Geodesy {
+	if em != nil && em.Geodesy != nil {
+		return em.Geodesy
+	}
+	return GeodesicBackend()
+}
+
+func (em *EnhancedMap) Distance(lat1, lon1, lat2, lon2 float64) float64 {
+	distance, _, _ := em.geodesy().Inverse(lat1, lon1, lat2, lon2)
+	return distance
+}
+
+func (em *EnhancedMap) Bearing(lat1, lon1, lat2, lon2 float64) float64 {
+	_, azi1, _ := em.geodesy().Inverse(lat1, lon1, lat2, lon2)
+	return azi1
+}
+
+func (em *EnhancedMap) Destination(lat, lon, bearing, distance float64) (float64, float64) {
+	lat2, lon2, _ := em.geodesy().Direct(lat, lon, bearing, distance)
+	return lat2, lon2
+}
@@ -0,0 +1,230 @@
+package osmprocessing
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func dms(deg, min, sec float64) float64 {
+	return math.Copysign(math.Abs(deg)+min/60+sec/3600, deg)
+}
+
+func TestKarneyInverse(t *testing.T) {
+	k := NewKarneyGeodesy(WGS84)
+
+	// Wellington to Salamanca, from the GeographicLib documentation
+	s12, azi1, azi2 := k.Inverse(-41.32, 174.81, 40.96, -5.50)
+	if math.Abs(s12-19959679.267353) > 1e-6 {
+		t.Errorf("distance got %.6f want 19959679.267353", s12)
+	}
+	if math.Abs(azi1-161.067669986160) > 1e-9 || math.Abs(azi2-18.825195123247) > 1e-9 {
+		t.Errorf("azimuths got %.12f,%.12f", azi1, azi2)
+	}
+
+	// antipodes on the equator are joined over the pole, half the meridian
+	if s12, _, _ := k.Inverse(0, 0, 0, 180); math.Abs(s12-20003931.4586) > 1e-3 {
+		t.Errorf("antipodal equatorial distance got %.4f", s12)
+	}
+
+	if s12, _, _ := k.Inverse(10, 20, 10, 20); s12 != 0 {
+		t.Errorf("coincident points got %v", s12)
+	}
+}
+
+// Lines of the GeographicLib geodesic test set, GeodTest.dat: lat1, lon1,
+// azi1, lat2, lon2, azi2 and s12, exact on WGS84.
+var geodTestSet = [][7]float64{
+	{35.60777, -139.44815, 111.098748429560326, -11.17491, -69.95921, 129.289270889708762, 8935244.5604818305},
+	{55.52454, 106.05087, 22.020059880982801, 77.03196, 197.18234, 109.112041110671519, 4105086.1713924406},
+	{-21.97856, 142.59065, -32.44456876433189, 41.84138, 98.56635, -41.84359951440466, 8394328.894657671},
+	{-66.99028, 112.2363, 173.73491240878403, -12.70631, 285.90344, 2.512956620913668, 11150344.2312080241},
+	{-17.42761, 173.34268, -159.033557661192928, -15.84784, 5.93557, -20.787484651536988, 16076603.1631180673},
+	{32.84994, 48.28919, 150.492927788121982, -56.28556, 202.29132, 48.113449399816759, 16727068.9438164461},
+	{6.96833, 52.74123, 92.581585386317712, -7.39675, 206.17291, 90.721692165923907, 17102477.2496958388},
+	{-50.56724, -16.30485, -105.439679907590164, -33.56571, -94.97412, -47.348547835650331, 6455670.5118668696},
+	{-58.93002, -8.90775, 140.965397902500679, -8.91104, 133.13503, 19.255429433416599, 11756066.0219864627},
+	{-68.82867, -74.28391, 93.774347763114881, -50.63005, -8.36685, 34.65564085411343, 3956936.926063544},
+	{-10.62672, -32.0898, -86.426713286747751, 5.883, -134.31681, -80.473780971034875, 11470869.3864563009},
+	{-21.76221, 166.90563, 29.319421206936428, 48.72884, 213.97627, 43.508671946410168, 9098627.3986554915},
+	{-19.79938, -174.47484, 71.167275780171533, -11.99349, -154.35109, 65.589099775199228, 2319004.8601169389},
+	{-11.95887, -116.94513, 92.712619830452549, 4.57352, 7.16501, 78.64960934409585, 13834722.5801401374},
+	{-87.85331, 85.66836, -65.120313040242748, 66.48646, 16.09921, -4.888658719272296, 17286615.3147144645},
+	{1.74708, 128.32011, -101.584843631173858, -11.16617, 11.87109, -86.325793296437476, 12942901.1241347408},
+	{-25.72959, -144.90758, -153.647468693117198, -57.70581, -269.17879, -48.343983158876487, 9413446.7452453107},
+	{-41.22777, 122.32875, 14.285113402275739, -7.57291, 130.37946, 10.805303085187369, 3812686.035106021},
+	{11.01307, 138.25278, 79.43682622782374, 6.62726, 247.05981, 103.708090215522657, 11911190.819018408},
+	{-29.47124, 95.14681, -163.779130441688382, -27.46601, -69.15955, -15.909335945554969, 13487015.8381145492},
+}
+
+// The nearly antipodal example of Karney, Algorithms for geodesics (2013),
+// to the digits given there, where Vincenty's inverse does not converge.
+var geodAntipodal = [7]float64{-30, 0, 161.890524736, 29.9, 179.8, 18.090737246, 19989832.82761}
+
+func TestKarneyTestSet(t *testing.T) {
+	k := NewKarneyGeodesy(WGS84)
+	for _, c := range geodTestSet {
+		s12, azi1, azi2 := k.Inverse(c[0], c[1], c[3], c[4])
+		if math.Abs(s12-c[6]) > 1e-6 || math.Abs(BearingDifference(azi1, c[2])) > 1e-9 ||
+			math.Abs(BearingDifference(azi2, c[5])) > 1e-9 {
+			t.Errorf("inverse %v: got %.7f %.12f %.12f", c, s12, azi1, azi2)
+		}
+
+		lat2, lon2, azi2 := k.Direct(c[0], c[1], c[2], c[6])
+		if math.Abs(lat2-c[3]) > 1e-11 || math.Abs(normalizeLon(lon2-c[4])) > 1e-11 ||
+			math.Abs(BearingDifference(azi2, c[5])) > 1e-9 {
+			t.Errorf("direct %v: got %.13f %.13f %.12f", c, lat2, lon2, azi2)
+		}
+	}
+}
+
+// Flinders Peak to Buninyong, the example of Vincenty's paper as worked by
+// Geoscience Australia.
+func TestVincenty(t *testing.T) {
+	lat1, lon1 := dms(-37, 57, 3.72030), dms(144, 25, 29.52440)
+	lat2, lon2 := dms(-37, 39, 10.15610), dms(143, 55, 35.38390)
+	wantAzi1 := dms(306, 52, 5.37)
+	wantReverse := dms(127, 10, 25.07)
+
+	v := NewVincentyGeodesy()
+
+	t.Run("inverse", func(t *testing.T) {
+		s12, azi1, azi2, err := v.InverseStrict(lat1, lon1, lat2, lon2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(s12-54972.271) > 1e-3 {
+			t.Errorf("distance got %.4f want 54972.271", s12)
+		}
+		if math.Abs(azi1-wantAzi1) > 0.01/3600 || math.Abs(NormalizeBearing(azi2-180)-wantReverse) > 0.01/3600 {
+			t.Errorf("azimuths got %.6f,%.6f", azi1, azi2)
+		}
+	})
+
+	t.Run("direct", func(t *testing.T) {
+		gotLat, gotLon, _ := v.Direct(lat1, lon1, wantAzi1, 54972.271)
+		if HaversineDistance(gotLat, gotLon, lat2, lon2) > 0.001 {
+			t.Errorf("got %.9f,%.9f want %.9f,%.9f", gotLat, gotLon, lat2, lon2)
+		}
+	})
+
+	t.Run("agrees with karney", func(t *testing.T) {
+		s12, azi1, _ := v.Inverse(lat1, lon1, lat2, lon2)
+		ks12, kazi1, _ := NewKarneyGeodesy(WGS84).Inverse(lat1, lon1, lat2, lon2)
+		if math.Abs(s12-ks12) > 1e-5 || math.Abs(azi1-kazi1) > 1e-8 {
+			t.Errorf("vincenty %.6f %.9f karney %.6f %.9f", s12, azi1, ks12, kazi1)
+		}
+	})
+
+	t.Run("nearly antipodal", func(t *testing.T) {
+		_, _, _, err := v.InverseStrict(0, 0, 0.5, 179.7)
+		if !errors.Is(err, ErrVincentyNoConvergence) {
+			t.Fatalf("expected ErrVincentyNoConvergence, got %v", err)
+		}
+		s12, _, _ := v.Inverse(0, 0, 0.5, 179.7)
+		if math.Abs(s12-19944127.420750) > 1e-6 {
+			t.Errorf("fallback distance got %.6f", s12)
+		}
+
+		c := geodAntipodal
+		if _, _, _, err := v.InverseStrict(c[0], c[1], c[3], c[4]); !errors.Is(err, ErrVincentyNoConvergence) {
+			t.Fatalf("expected ErrVincentyNoConvergence, got %v", err)
+		}
+		s12, azi1, azi2 := v.Inverse(c[0], c[1], c[3], c[4])
+		if math.Abs(s12-c[6]) > 1e-5 || math.Abs(BearingDifference(azi1, c[2])) > 1e-9 ||
+			math.Abs(BearingDifference(azi2, c[5])) > 1e-9 {
+			t.Errorf("fallback %v: got %.6f %.9f %.9f", c, s12, azi1, azi2)
+		}
+		// the direct problem converges however long the line
+		lat2, lon2, azi2 := v.Direct(c[0], c[1], c[2], c[6])
+		if math.Abs(lat2-c[3]) > 1e-9 || math.Abs(normalizeLon(lon2-c[4])) > 1e-9 ||
+			math.Abs(BearingDifference(azi2, c[5])) > 1e-9 {
+			t.Errorf("direct %v: got %.11f %.11f %.9f", c, lat2, lon2, azi2)
+		}
+	})
+}
+
+// Vincenty's series are good to a tenth of a millimetre, against the exact
+// lines of the test set.
+func TestVincentyTestSet(t *testing.T) {
+	v := NewVincentyGeodesy()
+	for _, c := range geodTestSet {
+		s12, azi1, azi2, err := v.InverseStrict(c[0], c[1], c[3], c[4])
+		if err != nil {
+			t.Errorf("inverse %v: %v", c, err)
+		} else if math.Abs(s12-c[6]) > 1e-4 || math.Abs(BearingDifference(azi1, c[2])) > 1e-9 ||
+			math.Abs(BearingDifference(azi2, c[5])) > 1e-9 {
+			t.Errorf("inverse %v: got %.7f %.12f %.12f", c, s12, azi1, azi2)
+		}
+
+		lat2, lon2, azi2 := v.Direct(c[0], c[1], c[2], c[6])
+		if math.Abs(lat2-c[3]) > 1e-9 || math.Abs(normalizeLon(lon2-c[4])) > 1e-9 ||
+			math.Abs(BearingDifference(azi2, c[5])) > 1e-9 {
+			t.Errorf("direct %v: got %.13f %.13f %.12f", c, lat2, lon2, azi2)
+		}
+	}
+}
+
+func TestGeodesyRoundTrip(t *testing.T) {
+	backends := map[string]Geodesy{
+		"spherical": SphericalGeodesy{},
+		"vincenty":  NewVincentyGeodesy(),
+		"karney":    NewKarneyGeodesy(WGS84),
+	}
+	starts := [][2]float64{{44.8378, -0.5792}, {69.6496, 18.9560}, {-33.8688, 151.2093}, {0, 179.9}}
+
+	for name, g := range backends {
+		t.Run(name, func(t *testing.T) {
+			for _, start := range starts {
+				for _, azi := range []float64{0, 37, 90, 181, 300} {
+					for _, distance := range []float64{1, 850, 120000} {
+						lat2, lon2, _ := g.Direct(start[0], start[1], azi, distance)
+						s12, azi1, _ := g.Inverse(start[0], start[1], lat2, lon2)
+						if math.Abs(s12-distance) > 1e-6 {
+							t.Errorf("%v az %v: distance %.9f want %v", start, azi, s12, distance)
+						}
+						// compare the lateral offset, the azimuth is ill-conditioned over 1m
+						if DegToRad(BearingDifference(azi1, azi))*distance > 1e-6 {
+							t.Errorf("%v az %v: azimuth %.9f", start, azi, azi1)
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestGeodesySelection(t *testing.T) {
+	if _, ok := GeodesicBackend().(SphericalGeodesy); !ok {
+		t.Fatalf("default should be spherical, got %T", GeodesicBackend())
+	}
+
+	em := &EnhancedMap{}
+	want := HaversineDistance(44.8, -0.6, 44.9, -0.5)
+	if got := em.Distance(44.8, -0.6, 44.9, -0.5); got != want {
+		t.Errorf("map without geodesy got %v want %v", got, want)
+	}
+
+	em.Geodesy = NewKarneyGeodesy(WGS84)
+	ellipsoidal := em.Distance(44.8, -0.6, 44.9, -0.5)
+	if ellipsoidal == want {
+		t.Error("per-map geodesy was ignored")
+	}
+
+	SetGeodesicBackend(NewVincentyGeodesy())
+	defer SetGeodesicBackend(SphericalGeodesy{})
+	if got := GeodesicDistance(44.8, -0.6, 44.9, -0.5); math.Abs(got-ellipsoidal) > 1e-6 {
+		t.Errorf("default vincenty got %v want %v", got, ellipsoidal)
+	}
+}
+
+func TestVincentyAcrossAntimeridian(t *testing.T) {
+	s12, _, _, err := NewVincentyGeodesy().InverseStrict(0.5, 179.9, 0.6, -179.8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ks12, _, _ := NewKarneyGeodesy(WGS84).Inverse(0.5, 179.9, 0.6, -179.8)
+	if math.Abs(s12-ks12) > 1e-5 {
+		t.Errorf("got %.6f want %.6f", s12, ks12)
+	}
+}
@@ -52,27 +52,11 @@ func BearingDifference(bearing1, bearing2 float64) float64 {
 }
 
 func DistanceToWay(lat, lon float64, way *osm.Way, nodes map[osm.NodeID]*osm.Node) float64 {
-	if len(way.Nodes) < 2 {
+	proj, ok := ProjectOnWay(lat, lon, way, nodes)
+	if !ok {
 		return math.Inf(1)
 	}
-
-	minDist := math.Inf(1)
-
-	for i := 0; i < len(way.Nodes)-1; i++ {
-		node1, ok1 := nodes[way.Nodes[i].ID]
-		node2, ok2 := nodes[way.Nodes[i+1].ID]
-
-		if !ok1 || !ok2 {
-			continue
-		}
-
-		dist := DistanceToSegment(lat, lon, node1.Lat, node1.Lon, node2.Lat, node2.Lon)
-		if dist < minDist {
-			minDist = dist
-		}
-	}
-
-	return minDist
+	return proj.Distance
 }
 
 func DegToRad(d float64) float64 {
@@ -80,29 +64,49 @@ func DegToRad(d float64) float64 {
 }
 
 func DistanceToSegment(px, py, x1, y1, x2, y2 float64) float64 {
+	_, dist, _ := projectOnSegment(px, py, x1, y1, x2, y2)
+	return dist
+}
 
-	px_r := DegToRad(px)
-	py_r := DegToRad(py)
-	x1_r := DegToRad(x1)
-	y1_r := DegToRad(y1)
-	x2_r := DegToRad(x2)
-	y2_r := DegToRad(y2)
+func ClosestPointOnSegment(px, py, x1, y1, x2, y2 float64) (lat, lon float64) {
+	t, _, _ := projectOnSegment(px, py, x1, y1, x2, y2)
+	return x1 + t*(x2-x1), y1 + t*(y2-y1)
+}
 
-	dx := x2_r - x1_r
-	dy := y2_r - y1_r
+// projectOnSegment scales degrees to metres with MetersPerDegree at the
+// latitude of the point rather than going through the map's LocalENU. It
+// serves Map and R-tree methods that have no projection at hand, and costs
+// a single sin and cos per call where LocalENU.Forward converts each end to
+// ECEF. With the ends within 200m of the point, the reach of a road search,
+// it agrees with a LocalENU anchored at the point to a centimetre up to 70°
+// of latitude. The longitude scale drifts along longer segments, by 20cm
+// for ends 1km away at 70°. cross is the distance signed positive when the
+// point is right of the segment.
+func projectOnSegment(px, py, x1, y1, x2, y2 float64) (t, dist, cross float64) {
+	latMeters, lonMeters := MetersPerDegree(px)
+	ax, ay := normalizeLon(y1-py)*lonMeters, (x1-px)*latMeters
+	bx, by := normalizeLon(y2-py)*lonMeters, (x2-px)*latMeters
+
+	dx := bx - ax
+	dy := by - ay
 
 	if dx == 0 && dy == 0 {
-		return HaversineDistance(px, py, x1, y1)
+		return 0, math.Hypot(ax, ay), 0
 	}
 
-	t := ((px_r-x1_r)*dx + (py_r-y1_r)*dy) / (dx*dx + dy*dy)
+	t = -(ax*dx + ay*dy) / (dx*dx + dy*dy)
 
 	t = math.Max(0, math.Min(1, t))
 
-	closestLat := x1 + t*(x2-x1)
-	closestLon := y1 + t*(y2-y1)
+	cx, cy := ax+t*dx, ay+t*dy
+	dist = math.Hypot(cx, cy)
 
-	return HaversineDistance(px, py, closestLat, closestLon)
+	// the point sits at -c from the closest point, d x -c is positive when
+	// it is on the left
+	if dy*cx-dx*cy > 0 {
+		return t, dist, -dist
+	}
+	return t, dist, dist
 }
 
 func GetWayHeading(way *osm.Way, segmentIdx int, nodes map[osm.NodeID]*osm.Node) float64 {
@@ -121,29 +125,11 @@ func GetWayHeading(way *osm.Way, segmentIdx int, nodes map[osm.NodeID]*osm.Node)
 }
 
 func GetWayHeadingAtPoint(way *osm.Way, lat, lon float64, nodes map[osm.NodeID]*osm.Node) float64 {
-	if len(way.Nodes) < 2 {
+	proj, ok := ProjectOnWay(lat, lon, way, nodes)
+	if !ok {
 		return 0
 	}
-
-	minDist := math.Inf(1)
-	bestSegment := 0
-
-	for i := 0; i < len(way.Nodes)-1; i++ {
-		node1, ok1 := nodes[way.Nodes[i].ID]
-		node2, ok2 := nodes[way.Nodes[i+1].ID]
-
-		if !ok1 || !ok2 {
-			continue
-		}
-
-		dist := DistanceToSegment(lat, lon, node1.Lat, node1.Lon, node2.Lat, node2.Lon)
-		if dist < minDist {
-			minDist = dist
-			bestSegment = i
-		}
-	}
-
-	return GetWayHeading(way, bestSegment, nodes)
+	return proj.Bearing
 }
 
 func GetWayLength(way *osm.Way, nodes map[osm.NodeID]*osm.Node) float64 {
@@ -167,46 +153,63 @@ func GetWayLength(way *osm.Way, nodes map[osm.NodeID]*osm.Node) float64 {
 	return totalLength
 }
 
-type Bounds struct {
-	MinLat, MaxLat float64
-	MinLon, MaxLon float64
+type LatLon struct {
+	Lat, Lon float64
+}
+
+// Polygon is an outer ring with optional holes. Rings are closed, the
+// first and last points are equal.
+type Polygon struct {
+	Outer []LatLon
+	Holes [][]LatLon
+}
+
+func (p Polygon) Contains(lat, lon float64) bool {
+	if !ringContains(p.Outer, lat, lon) {
+		return false
+	}
+	for _, hole := range p.Holes {
+		if ringContains(hole, lat, lon) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p Polygon) Rings() [][]LatLon {
+	return append([][]LatLon{p.Outer}, p.Holes...)
 }
 
-func (m *Map) CalculateBounds() Bounds {
-	if len(m.Nodes) == 0 {
+func (p Polygon) Bounds() Bounds {
+	if len(p.Outer) == 0 {
 		return Bounds{}
 	}
 
 	bounds := Bounds{
 		MinLat: math.Inf(1),
 		MaxLat: math.Inf(-1),
-		MinLon: math.Inf(1),
-		MaxLon: math.Inf(-1),
 	}
 
-	for _, node := range m.Nodes {
-		if node.Lat < bounds.MinLat {
-			bounds.MinLat = node.Lat
-		}
-		if node.Lat > bounds.MaxLat {
-			bounds.MaxLat = node.Lat
-		}
-		if node.Lon < bounds.MinLon {
-			bounds.MinLon = node.Lon
-		}
-		if node.Lon > bounds.MaxLon {
-			bounds.MaxLon = node.Lon
-		}
+	lons := make([]float64, 0, len(p.Outer))
+	for _, pt := range p.Outer {
+		bounds.MinLat = math.Min(bounds.MinLat, pt.Lat)
+		bounds.MaxLat = math.Max(bounds.MaxLat, pt.Lat)
+		lons = append(lons, wrapMinLon(pt.Lon))
 	}
+	bounds.MinLon, bounds.MaxLon = smallestLonRange(lons)
 
 	return bounds
 }
 
-func (b Bounds) Contains(lat, lon float64) bool {
-	return lat >= b.MinLat && lat <= b.MaxLat &&
-		lon >= b.MinLon && lon <= b.MaxLon
-}
-
-func (b Bounds) GetCenter() (lat, lon float64) {
-	return (b.MinLat + b.MaxLat) / 2, (b.MinLon + b.MaxLon) / 2
+// ray casting, see https://wrfranklin.org/Research/Short_Notes/pnpoly.html
+func ringContains(ring []LatLon, lat, lon float64) bool {
+	inside := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		a, b := ring[i], ring[j]
+		if (a.Lat > lat) != (b.Lat > lat) &&
+			lon < (b.Lon-a.Lon)*(lat-a.Lat)/(b.Lat-a.Lat)+a.Lon {
+			inside = !inside
+		}
+	}
+	return inside
 }
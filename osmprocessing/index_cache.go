@@ -0,0 +1,409 @@
+package osmprocessing
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash"
+	"maps"
+	"math"
+	"os"
+	"slices"
+
+	"github.com/paulmach/osm"
+)
+
+// indexCacheVersion changes with the layout of the cache or with the way
+// any index is built, so older caches are rebuilt.
+const indexCacheVersion = 3
+
+// indexCache is what SaveIndexes writes. Ways, landmarks and buildings are
+// referred to by their position in the map, which the checksum covers.
+type indexCache struct {
+	Version    int
+	Checksum   [sha256.Size]byte
+	WayIndex   IndexType
+	Aux        AuxIndexes
+	Bounds     Bounds
+	NodeToWays map[osm.NodeID][]int
+	Junctions  map[osm.NodeID]*Junction
+	Spatial    spatialIndexCache
+	RTree      []rtreeNodeCache // preorder
+	Landmarks  degreeGridCache
+	Buildings  degreeGridCache
+	// Field is the likelihood field, nil when none was built
+	Field *LikelihoodField
+}
+
+type spatialIndexCache struct {
+	Lat0, Lon0 float64
+	CellSize   float64
+	WayCells   [][]GridCell
+	NodeCells  map[osm.NodeID]GridCell
+}
+
+type rtreeNodeCache struct {
+	Box      rtreeBox
+	Children int // the following subtrees
+	Segments []rtreeSegmentCache
+}
+
+type rtreeSegmentCache struct {
+	Way, Index int
+	Box        rtreeBox
+}
+
+type degreeGridCache struct {
+	CellSize float64
+	Grid     map[GridCell][]int
+}
+
+// Checksum is a SHA-256 of everything the indexes are built from: the ways
+// in order with their nodes and tags, the nodes' positions, the landmarks
+// and the buildings. The ways the landmarks are attached to are left out,
+// loading attaches them again.
+func (m *Map) Checksum() [sha256.Size]byte {
+	h := checksumWriter{hash: sha256.New()}
+
+	h.int(len(m.Ways))
+	for _, way := range m.Ways {
+		h.int(int(way.ID))
+		h.int(len(way.Nodes))
+		for _, wn := range way.Nodes {
+			h.int(int(wn.ID))
+		}
+		h.int(len(way.Tags))
+		for _, tag := range way.Tags {
+			h.string(tag.Key)
+			h.string(tag.Value)
+		}
+	}
+
+	ids := make([]osm.NodeID, 0, len(m.Nodes))
+	for id := range m.Nodes {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	h.int(len(ids))
+	for _, id := range ids {
+		h.int(int(id))
+		h.float(m.Nodes[id].Lat)
+		h.float(m.Nodes[id].Lon)
+	}
+
+	h.int(len(m.Landmarks))
+	for _, lm := range m.Landmarks {
+		h.int(int(lm.ID))
+		h.int(int(lm.Type))
+		h.float(lm.Lat)
+		h.float(lm.Lon)
+	}
+
+	h.int(len(m.Buildings))
+	for _, b := range m.Buildings {
+		h.string(b.ID.String())
+		h.float(b.Height)
+		h.int(b.Levels)
+		rings := b.Polygon.Rings()
+		h.int(len(rings))
+		for _, ring := range rings {
+			h.int(len(ring))
+			for _, pt := range ring {
+				h.float(pt.Lat)
+				h.float(pt.Lon)
+			}
+		}
+	}
+
+	var sum [sha256.Size]byte
+	h.hash.Sum(sum[:0])
+	return sum
+}
+
+type checksumWriter struct {
+	hash hash.Hash
+	buf  []byte
+}
+
+func (w *checksumWriter) int(v int) {
+	w.buf = binary.LittleEndian.AppendUint64(w.buf[:0], uint64(v))
+	w.hash.Write(w.buf)
+}
+
+func (w *checksumWriter) float(v float64) {
+	w.buf = binary.LittleEndian.AppendUint64(w.buf[:0], math.Float64bits(v))
+	w.hash.Write(w.buf)
+}
+
+func (w *checksumWriter) string(s string) {
+	w.int(len(s))
+	w.hash.Write([]byte(s))
+}
+
+// SaveIndexes writes the indexes built by BuildIndexes to fname, with the
+// checksum of the map, for LoadEnhancedMap. An R-tree left behind by edits
+// is built again first.
+func (em *EnhancedMap) SaveIndexes(fname string) error {
+	em.RebuildWayIndex()
+
+	wayPos := make(map[*osm.Way]int, len(em.Ways))
+	for i, way := range em.Ways {
+		wayPos[way] = i
+	}
+
+	cache := indexCache{
+		Version:    indexCacheVersion,
+		Checksum:   em.Map.Checksum(),
+		WayIndex:   em.options.wayIndex,
+		Aux:        em.options.aux,
+		Bounds:     em.Bounds,
+		NodeToWays: make(map[osm.NodeID][]int, len(em.NodeToWays)),
+		Junctions:  em.Junctions,
+	}
+	for id, ways := range em.NodeToWays {
+		for _, way := range ways {
+			cache.NodeToWays[id] = append(cache.NodeToWays[id], wayPos[way])
+		}
+	}
+
+	si := em.SpatialIndex
+	si.mu.RLock()
+	cache.Spatial = spatialIndexCache{
+		Lat0:      si.projection.Lat0,
+		Lon0:      si.projection.Lon0,
+		CellSize:  si.cellSize,
+		WayCells:  make([][]GridCell, len(em.Ways)),
+		NodeCells: maps.Clone(si.nodeCells),
+	}
+	for i, way := range em.Ways {
+		cache.Spatial.WayCells[i] = si.wayCells[way.ID]
+	}
+	si.mu.RUnlock()
+
+	if tree, ok := em.WayIndex.(*SegmentRTree); ok {
+		cache.RTree = flattenRTree(tree.root, wayPos, nil)
+	}
+
+	landmarkPos := make(map[*Landmark]int, len(em.Landmarks))
+	for i, lm := range em.Landmarks {
+		landmarkPos[lm] = i
+	}
+	cache.Landmarks = flattenDegreeGrid(em.LandmarkIndex.grid, em.LandmarkIndex.cellSize, landmarkPos)
+
+	buildingPos := make(map[*Building]int, len(em.Buildings))
+	for i, b := range em.Buildings {
+		buildingPos[b] = i
+	}
+	cache.Buildings = flattenDegreeGrid(em.BuildingIndex.grid, em.BuildingIndex.cellSize, buildingPos)
+	cache.Field = em.LikelihoodField
+
+	file, err := os.Create(fname)
+	if err != nil {
+		return fmt.Errorf("failed to create %q %w", fname, err)
+	}
+	defer file.Close()
+
+	if err := gob.NewEncoder(file).Encode(&cache); err != nil {
+		return fmt.Errorf("failed to encode %q %w", fname, err)
+	}
+	return file.Close()
+}
+
+func flattenRTree(node *rtreeNode, wayPos map[*osm.Way]int, out []rtreeNodeCache) []rtreeNodeCache {
+	if node == nil {
+		return out
+	}
+
+	flat := rtreeNodeCache{Box: node.box, Children: len(node.children)}
+	for _, s := range node.segments {
+		flat.Segments = append(flat.Segments, rtreeSegmentCache{Way: wayPos[s.way], Index: s.index, Box: s.box})
+	}
+	out = append(out, flat)
+
+	for _, child := range node.children {
+		out = flattenRTree(child, wayPos, out)
+	}
+	return out
+}
+
+func flattenDegreeGrid[T comparable](grid map[GridCell][]T, cellSize float64, pos map[T]int) degreeGridCache {
+	flat := degreeGridCache{CellSize: cellSize, Grid: make(map[GridCell][]int, len(grid))}
+	for cell, bucket := range grid {
+		for _, item := range bucket {
+			flat.Grid[cell] = append(flat.Grid[cell], pos[item])
+		}
+	}
+	return flat
+}
+
+// LoadEnhancedMap returns the map with the indexes saved in fname. When
+// the file is missing, unreadable, saved from another version of the map or
+// with other index options the indexes are built again and saved over it.
+// The map is ready to use even when err reports the cache couldn't be
+// written. The likelihood field is saved along, a cache holding none or one
+// of another resolution or reach is stale.
+func LoadEnhancedMap(m *Map, fname string, opts ...Option) (*EnhancedMap, error) {
+	em, err := loadIndexCache(m, fname, opts)
+	if err == nil {
+		return em, nil
+	}
+
+	em = NewEnhancedMap(m, opts...)
+	if err := em.SaveIndexes(fname); err != nil {
+		return em, err
+	}
+	return em, nil
+}
+
+// errIndexCacheStale is returned for a cache of another map or version.
+var errIndexCacheStale = errors.New("index cache is stale")
+
+func loadIndexCache(m *Map, fname string, opts []Option) (*EnhancedMap, error) {
+	file, err := os.Open(fname)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %q %w", fname, err)
+	}
+	defer file.Close()
+
+	var cache indexCache
+	if err := gob.NewDecoder(file).Decode(&cache); err != nil {
+		return nil, fmt.Errorf("failed to decode %q %w", fname, err)
+	}
+	em := newEnhancedMap(m, opts)
+	o := em.options
+	if cache.Version != indexCacheVersion || cache.Checksum != m.Checksum() ||
+		cache.WayIndex != o.wayIndex || cache.Aux != o.aux || cache.Spatial.CellSize != o.cellSize {
+		return nil, fmt.Errorf("failed to load %q %w", fname, errIndexCacheStale)
+	}
+	if field := cache.Field; (field == nil) != (o.fieldResolution <= 0) ||
+		field != nil && (field.Resolution != o.fieldResolution || field.MaxDistance != o.fieldMaxDistance) {
+		return nil, fmt.Errorf("failed to load %q %w", fname, errIndexCacheStale)
+	}
+	if len(cache.Spatial.WayCells) != len(m.Ways) {
+		return nil, fmt.Errorf("failed to load %q: %d ways for %d", fname, len(cache.Spatial.WayCells), len(m.Ways))
+	}
+
+	em.Junctions = cache.Junctions
+	em.Bounds = cache.Bounds
+	if em.Junctions == nil {
+		em.Junctions = make(map[osm.NodeID]*Junction)
+	}
+
+	for _, way := range m.Ways {
+		em.WaysByID[way.ID] = way
+	}
+	for id, positions := range cache.NodeToWays {
+		for _, i := range positions {
+			if i < 0 || i >= len(m.Ways) {
+				return nil, fmt.Errorf("failed to load %q: node %d on way %d of %d", fname, id, i, len(m.Ways))
+			}
+			em.NodeToWays[id] = append(em.NodeToWays[id], m.Ways[i])
+		}
+	}
+
+	si := NewSpatialIndex(cache.Spatial.Lat0, cache.Spatial.Lon0, cache.Spatial.CellSize)
+	for i, way := range m.Ways {
+		si.wayCells[way.ID] = cache.Spatial.WayCells[i]
+		for _, cell := range cache.Spatial.WayCells[i] {
+			si.wayGrid[cell] = append(si.wayGrid[cell], way)
+		}
+	}
+	for id, cell := range cache.Spatial.NodeCells {
+		if node, ok := m.Nodes[id]; ok {
+			si.nodeCells[id] = cell
+			si.nodeGrid[cell] = append(si.nodeGrid[cell], node)
+		}
+	}
+	em.SpatialIndex = si
+
+	switch o.wayIndex {
+	case GridIndex:
+		em.WayIndex = NewGridWayIndex(m, si)
+	default:
+		tree := &SegmentRTree{nodes: m.Nodes}
+		if len(cache.RTree) > 0 {
+			var rest []rtreeNodeCache
+			tree.root, rest, err = unflattenRTree(cache.RTree, m.Ways, &tree.size)
+			if err == nil && len(rest) > 0 {
+				err = fmt.Errorf("%d R-tree nodes past the root", len(rest))
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to load %q: %w", fname, err)
+			}
+		}
+		em.WayIndex = tree
+	}
+	m.AttachLandmarks(landmarkAttachDistance, em.WayIndex)
+
+	em.LandmarkIndex = NewLandmarkIndex(cache.Landmarks.CellSize)
+	for cell, positions := range cache.Landmarks.Grid {
+		for _, i := range positions {
+			if i < 0 || i >= len(m.Landmarks) {
+				return nil, fmt.Errorf("failed to load %q: landmark %d of %d", fname, i, len(m.Landmarks))
+			}
+			em.LandmarkIndex.grid[cell] = append(em.LandmarkIndex.grid[cell], m.Landmarks[i])
+		}
+	}
+	em.BuildingIndex = NewBuildingIndex(cache.Buildings.CellSize)
+	for cell, positions := range cache.Buildings.Grid {
+		for _, i := range positions {
+			if i < 0 || i >= len(m.Buildings) {
+				return nil, fmt.Errorf("failed to load %q: building %d of %d", fname, i, len(m.Buildings))
+			}
+			em.BuildingIndex.grid[cell] = append(em.BuildingIndex.grid[cell], m.Buildings[i])
+		}
+	}
+
+	// names are quick to index again
+	em.StreetIndex = NewStreetIndex()
+	if o.aux&AuxStreetNames != 0 {
+		em.StreetIndex = m.BuildStreetIndex()
+	}
+
+	em.buildRestrictions()
+	if cache.Field != nil {
+		if err := cache.Field.restore(); err != nil {
+			return nil, fmt.Errorf("failed to load %q: %w", fname, err)
+		}
+		em.LikelihoodField = cache.Field
+	}
+
+	return em, nil
+}
+
+// unflattenRTree rebuilds the subtree at the head of flat and returns what
+// follows it. It fails on a segment of no way or segment of the map, or on
+// a node with more children than flat holds.
+func unflattenRTree(flat []rtreeNodeCache, ways []*osm.Way, size *int) (*rtreeNode, []rtreeNodeCache, error) {
+	if len(flat) == 0 {
+		return nil, nil, errors.New("R-tree node missing")
+	}
+	node := &rtreeNode{box: flat[0].Box}
+	for _, s := range flat[0].Segments {
+		if s.Way < 0 || s.Way >= len(ways) {
+			return nil, nil, fmt.Errorf("R-tree segment on way %d of %d", s.Way, len(ways))
+		}
+		way := ways[s.Way]
+		if s.Index < 0 || s.Index >= len(way.Nodes)-1 {
+			return nil, nil, fmt.Errorf("R-tree segment %d of way %d with %d nodes", s.Index, way.ID, len(way.Nodes))
+		}
+		node.segments = append(node.segments, &rtreeSegment{way: way, index: s.Index, box: s.Box})
+	}
+	*size += len(node.segments)
+
+	rest := flat[1:]
+	if flat[0].Children < 0 || flat[0].Children > len(rest) {
+		return nil, nil, fmt.Errorf("R-tree node with %d children, %d nodes left", flat[0].Children, len(rest))
+	}
+	for c := 0; c < flat[0].Children; c++ {
+		child, next, err := unflattenRTree(rest, ways, size)
+		if err != nil {
+			return nil, nil, err
+		}
+		node.children = append(node.children, child)
+		rest = next
+	}
+	return node, rest, nil
+}
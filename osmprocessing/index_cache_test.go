@@ -0,0 +1,222 @@
+package osmprocessing
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/paulmach/osm"
+)
+
+func cacheTestMap() *Map {
+	rng := rand.New(rand.NewSource(21))
+	m := randomWayMap(rng, 200, 44.84, -0.58, 1500)
+	for i := 0; i < 20; i++ {
+		lat, lon := DestinationPoint(44.84, -0.58, rng.Float64()*360, rng.Float64()*1500)
+		m.Landmarks = append(m.Landmarks, &Landmark{ID: osm.NodeID(-1 - i), Type: TrafficSignals, Lat: lat, Lon: lon})
+	}
+	return m
+}
+
+func TestLoadEnhancedMap(t *testing.T) {
+	m := cacheTestMap()
+	fname := filepath.Join(t.TempDir(), "bordeaux.idx")
+
+	// the first load finds no cache and writes one
+	built, err := LoadEnhancedMap(m, fname, WithWayIndex(RTreeIndex))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(fname); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := loadIndexCache(m, fname, []Option{WithWayIndex(RTreeIndex)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(loaded.WaysByID) != len(built.WaysByID) || len(loaded.NodeToWays) != len(built.NodeToWays) ||
+		len(loaded.Junctions) != len(built.Junctions) || loaded.Bounds != built.Bounds {
+		t.Fatalf("loaded %d ways, %d nodes, %d junctions in %v, built %d, %d, %d in %v",
+			len(loaded.WaysByID), len(loaded.NodeToWays), len(loaded.Junctions), loaded.Bounds,
+			len(built.WaysByID), len(built.NodeToWays), len(built.Junctions), built.Bounds)
+	}
+	if got, want := loaded.WayIndex.(*SegmentRTree).Len(), built.WayIndex.(*SegmentRTree).Len(); got != want {
+		t.Fatalf("loaded %d segments, built %d", got, want)
+	}
+	for id, ways := range built.NodeToWays {
+		for i, way := range ways {
+			if loaded.NodeToWays[id][i] != way {
+				t.Fatalf("node %d: way %d is not the map's way", id, way.ID)
+			}
+		}
+	}
+
+	rng := rand.New(rand.NewSource(22))
+	for i := 0; i < 300; i++ {
+		lat, lon := DestinationPoint(44.84, -0.58, rng.Float64()*360, rng.Float64()*1600)
+
+		wantWay, wantDist := built.FindNearestWayFast(lat, lon, 100)
+		gotWay, gotDist := loaded.FindNearestWayFast(lat, lon, 100)
+		if gotWay != wantWay || gotDist != wantDist {
+			t.Fatalf("%.6f,%.6f: loaded finds %v at %.3f, built %v at %.3f", lat, lon, gotWay, gotDist, wantWay, wantDist)
+		}
+
+		if got, want := len(loaded.SpatialIndex.QueryWays(lat, lon, 150)), len(built.SpatialIndex.QueryWays(lat, lon, 150)); got != want {
+			t.Fatalf("%.6f,%.6f: grid has %d ways, built %d", lat, lon, got, want)
+		}
+		if got, want := len(loaded.SpatialIndex.QueryNodes(lat, lon, 150)), len(built.SpatialIndex.QueryNodes(lat, lon, 150)); got != want {
+			t.Fatalf("%.6f,%.6f: grid has %d nodes, built %d", lat, lon, got, want)
+		}
+
+		gotLm, _ := loaded.FindNearestLandmark(lat, lon, 300, TrafficSignals)
+		wantLm, _ := built.FindNearestLandmark(lat, lon, 300, TrafficSignals)
+		if gotLm != wantLm {
+			t.Fatalf("%.6f,%.6f: loaded finds landmark %v, built %v", lat, lon, gotLm, wantLm)
+		}
+	}
+}
+
+func TestLoadEnhancedMapRebuilds(t *testing.T) {
+	m := cacheTestMap()
+	fname := filepath.Join(t.TempDir(), "bordeaux.idx")
+	if _, err := LoadEnhancedMap(m, fname); err != nil {
+		t.Fatal(err)
+	}
+
+	// moving a node makes the cache stale
+	way := m.Ways[0]
+	node := m.Nodes[way.Nodes[0].ID]
+	node.Lat, node.Lon = DestinationPoint(node.Lat, node.Lon, 0, 2000)
+
+	if _, err := loadIndexCache(m, fname, nil); !errors.Is(err, errIndexCacheStale) {
+		t.Fatalf("got %v, want a stale cache", err)
+	}
+
+	em, err := LoadEnhancedMap(m, fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found, dist := em.FindNearestWayFast(node.Lat, node.Lon, 1); found != way || dist > 1e-6 {
+		t.Errorf("got %v at %.3f, want way %d on its moved node", found, dist, way.ID)
+	}
+	if _, err := loadIndexCache(m, fname, nil); err != nil {
+		t.Errorf("cache not rewritten: %v", err)
+	}
+
+	// and so is a file that is no cache at all
+	if err := os.WriteFile(fname, []byte("index"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadEnhancedMap(m, fname); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadIndexCache(m, fname, nil); err != nil {
+		t.Errorf("cache not rewritten: %v", err)
+	}
+}
+
+func TestLoadEnhancedMapLikelihoodField(t *testing.T) {
+	m := cacheTestMap()
+	fname := filepath.Join(t.TempDir(), "bordeaux.idx")
+	built, err := LoadEnhancedMap(m, fname, WithLikelihoodField(2, 20))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := loadIndexCache(m, fname, []Option{WithLikelihoodField(2, 20)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.LikelihoodField == nil || len(loaded.LikelihoodField.CrossTrack) != len(built.LikelihoodField.CrossTrack) {
+		t.Fatalf("loaded field %v", loaded.LikelihoodField)
+	}
+	rng := rand.New(rand.NewSource(23))
+	for i := 0; i < 100; i++ {
+		lat, lon := DestinationPoint(44.84, -0.58, rng.Float64()*360, rng.Float64()*1500)
+		gotDist, gotBearing, _ := loaded.LikelihoodField.Lookup(lat, lon)
+		wantDist, wantBearing, _ := built.LikelihoodField.Lookup(lat, lon)
+		if gotDist != wantDist || gotBearing != wantBearing {
+			t.Fatalf("%.6f,%.6f: loaded %.3f %.1f, built %.3f %.1f", lat, lon, gotDist, gotBearing, wantDist, wantBearing)
+		}
+	}
+
+	// a field of another resolution or reach, or none, makes it stale
+	for _, opts := range [][]Option{{WithLikelihoodField(1, 20)}, {WithLikelihoodField(2, 30)}, nil} {
+		if _, err := loadIndexCache(m, fname, opts); !errors.Is(err, errIndexCacheStale) {
+			t.Errorf("got %v, want a stale cache", err)
+		}
+	}
+}
+
+func TestLoadEnhancedMapCorrupt(t *testing.T) {
+	m := cacheTestMap()
+	fname := filepath.Join(t.TempDir(), "bordeaux.idx")
+	if _, err := LoadEnhancedMap(m, fname, WithWayIndex(RTreeIndex)); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := os.ReadFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, corrupt := range map[string]func(c *indexCache){
+		"node on no way": func(c *indexCache) {
+			for id := range c.NodeToWays {
+				c.NodeToWays[id] = append(c.NodeToWays[id], len(m.Ways))
+				break
+			}
+		},
+		"segment of no way":  func(c *indexCache) { c.RTree[len(c.RTree)-1].Segments[0].Way = -1 },
+		"segment past a way": func(c *indexCache) { c.RTree[len(c.RTree)-1].Segments[0].Index = 1 << 20 },
+		"missing children":   func(c *indexCache) { c.RTree[0].Children = len(c.RTree) },
+		"extra nodes":        func(c *indexCache) { c.RTree = append(c.RTree, c.RTree[len(c.RTree)-1]) },
+		"landmark":           func(c *indexCache) { c.Landmarks.Grid[GridCell{}] = []int{len(m.Landmarks)} },
+		"building":           func(c *indexCache) { c.Buildings.Grid[GridCell{}] = []int{-1} },
+	} {
+		t.Run(name, func(t *testing.T) {
+			var cache indexCache
+			if err := gob.NewDecoder(bytes.NewReader(saved)).Decode(&cache); err != nil {
+				t.Fatal(err)
+			}
+			corrupt(&cache)
+			var buf bytes.Buffer
+			if err := gob.NewEncoder(&buf).Encode(&cache); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(fname, buf.Bytes(), 0666); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := loadIndexCache(m, fname, []Option{WithWayIndex(RTreeIndex)}); err == nil {
+				t.Fatal("loaded a corrupt cache")
+			}
+			if em, err := LoadEnhancedMap(m, fname, WithWayIndex(RTreeIndex)); err != nil || em.WayIndex.(*SegmentRTree).Len() == 0 {
+				t.Fatalf("got %v", err)
+			}
+		})
+	}
+}
+
+func TestMapChecksum(t *testing.T) {
+	a, b := cacheTestMap(), cacheTestMap()
+	if a.Checksum() != b.Checksum() {
+		t.Fatal("the same map has two checksums")
+	}
+
+	b.Ways[3].Tags = append(b.Ways[3].Tags, b.Ways[3].Tags[0])
+	if a.Checksum() == b.Checksum() {
+		t.Error("a new tag leaves the checksum")
+	}
+
+	c := cacheTestMap()
+	c.Landmarks[0].Type = Crossing
+	if a.Checksum() == c.Checksum() {
+		t.Error("a landmark type leaves the checksum")
+	}
+}
@@ -0,0 +1,165 @@
+package osmprocessing
+
+import (
+	"math"
+	"sort"
+
+	"github.com/paulmach/osm"
+)
+
+// JunctionArm is one road leaving a junction, Bearing is the heading a
+// vehicle has when it leaves the junction along that road.
+type JunctionArm struct {
+	WayID   osm.WayID
+	Bearing float64
+}
+
+// Junction describes the geometry of a node where three or more roads meet.
+// Arms are sorted clockwise by bearing.
+type Junction struct {
+	NodeID osm.NodeID
+	Lat    float64
+	Lon    float64
+	Arms   []JunctionArm
+}
+
+func (em *EnhancedMap) buildJunctions() {
+	em.Junctions = make(map[osm.NodeID]*Junction)
+
+	for nodeID := range em.NodeToWays {
+		em.updateJunction(nodeID)
+	}
+}
+
+// updateJunction describes the node again, or forgets it when it is no
+// longer where three roads meet.
+func (em *EnhancedMap) updateJunction(nodeID osm.NodeID) {
+	delete(em.Junctions, nodeID)
+
+	node, ok := em.Nodes[nodeID]
+	if !ok {
+		return
+	}
+
+	var arms []JunctionArm
+	seen := make(map[osm.WayID]bool)
+
+	for _, way := range em.NodeToWays[nodeID] {
+		if seen[way.ID] {
+			continue
+		}
+		seen[way.ID] = true
+
+		for i, wn := range way.Nodes {
+			if wn.ID != nodeID {
+				continue
+			}
+			if i < len(way.Nodes)-1 {
+				arms = append(arms, JunctionArm{WayID: way.ID, Bearing: GetWayHeading(way, i, em.Nodes)})
+			}
+			if i > 0 {
+				arms = append(arms, JunctionArm{WayID: way.ID, Bearing: NormalizeBearing(GetWayHeading(way, i-1, em.Nodes) + 180)})
+			}
+		}
+	}
+
+	if len(arms) < 3 {
+		return
+	}
+
+	sort.Slice(arms, func(i, j int) bool {
+		return arms[i].Bearing < arms[j].Bearing
+	})
+
+	em.Junctions[nodeID] = &Junction{NodeID: nodeID, Lat: node.Lat, Lon: node.Lon, Arms: arms}
+}
+
+// Signature returns the clockwise angles between consecutive arms. The
+// angles sum to 360 and don't depend on how the junction is oriented.
+func (j *Junction) Signature() []float64 {
+	signature := make([]float64, len(j.Arms))
+	for i := range j.Arms {
+		next := j.Arms[(i+1)%len(j.Arms)]
+		signature[i] = NormalizeBearing(next.Bearing - j.Arms[i].Bearing)
+	}
+	return signature
+}
+
+// TurnAngles returns the signed turn angle, positive to the right, for every
+// way of entering the junction along one arm and leaving along another.
+func (j *Junction) TurnAngles() []float64 {
+	var turns []float64
+	for i, entry := range j.Arms {
+		arrivalHeading := NormalizeBearing(entry.Bearing + 180)
+		for k, exit := range j.Arms {
+			if i == k {
+				continue
+			}
+			turns = append(turns, BearingDifference(arrivalHeading, exit.Bearing))
+		}
+	}
+	return turns
+}
+
+func (j *Junction) MatchesTurn(turnAngle, tolerance float64) bool {
+	for _, turn := range j.TurnAngles() {
+		if math.Abs(BearingDifference(turn, turnAngle)) <= tolerance {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchesSignature reports whether the junction has as many arms as the
+// signature and its angles match the signature, in any rotation, within
+// tolerance degrees.
+func (j *Junction) MatchesSignature(signature []float64, tolerance float64) bool {
+	own := j.Signature()
+	if len(own) != len(signature) {
+		return false
+	}
+
+	for shift := range own {
+		matches := true
+		for i := range signature {
+			if math.Abs(own[(i+shift)%len(own)]-signature[i]) > tolerance {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return true
+		}
+	}
+	return false
+}
+
+// FindJunctionsByTurn returns every junction where the observed turn,
+// positive to the right, is possible within tolerance degrees.
+func (em *EnhancedMap) FindJunctionsByTurn(turnAngle, tolerance float64) []*Junction {
+	var results []*Junction
+	for _, j := range em.Junctions {
+		if j.MatchesTurn(turnAngle, tolerance) {
+			results = append(results, j)
+		}
+	}
+	sortJunctions(results)
+	return results
+}
+
+func (em *EnhancedMap) FindJunctionsBySignature(signature []float64, tolerance float64) []*Junction {
+	var results []*Junction
+	for _, j := range em.Junctions {
+		if j.MatchesSignature(signature, tolerance) {
+			results = append(results, j)
+		}
+	}
+	sortJunctions(results)
+	return results
+}
+
+func sortJunctions(junctions []*Junction) {
+	sort.Slice(junctions, func(i, k int) bool {
+		return junctions[i].NodeID < junctions[k].NodeID
+	})
+}
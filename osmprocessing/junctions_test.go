@@ -0,0 +1,66 @@
+package osmprocessing
+
+import (
+	"testing"
+)
+
+func TestJunctions(t *testing.T) {
+	m, grid := GenerateMap(2, 2, 150,
+		ToDecimalCoord(46, 0, 0, North),
+		ToDecimalCoord(7, 0, 0, East))
+
+	em := NewEnhancedMap(m)
+
+	t.Run("only nodes with three or more arms", func(t *testing.T) {
+		if len(em.Junctions) != 5 {
+			t.Errorf("expected 5 junctions, got %d", len(em.Junctions))
+		}
+		if _, ok := em.Junctions[grid["0,0"]]; ok {
+			t.Error("corner should not be a junction")
+		}
+	})
+
+	t.Run("arms are sorted bearings", func(t *testing.T) {
+		center := em.Junctions[grid["1,1"]]
+		if center == nil {
+			t.Fatal("expected centre junction")
+		}
+		want := []float64{0, 90, 180, 270}
+		if len(center.Arms) != len(want) {
+			t.Fatalf("expected %d arms, got %d", len(want), len(center.Arms))
+		}
+		for i, arm := range center.Arms {
+			if d := BearingDifference(arm.Bearing, want[i]); d > 1 || d < -1 {
+				t.Errorf("arm %d bearing %.2f, want %.2f", i, arm.Bearing, want[i])
+			}
+		}
+	})
+
+	t.Run("find by turn", func(t *testing.T) {
+		right := em.FindJunctionsByTurn(90, 5)
+		if len(right) != 5 {
+			t.Errorf("expected every junction to allow a right turn, got %d", len(right))
+		}
+
+		if got := em.FindJunctionsByTurn(45, 5); len(got) != 0 {
+			t.Errorf("expected no junction with a 45 degree turn, got %d", len(got))
+		}
+
+		straight := em.FindJunctionsByTurn(0, 5)
+		if len(straight) != 5 {
+			t.Errorf("expected every junction to allow going straight, got %d", len(straight))
+		}
+	})
+
+	t.Run("find by signature", func(t *testing.T) {
+		cross := em.FindJunctionsBySignature([]float64{90, 90, 90, 90}, 5)
+		if len(cross) != 1 || cross[0].NodeID != grid["1,1"] {
+			t.Errorf("expected only the centre crossroads, got %d", len(cross))
+		}
+
+		tee := em.FindJunctionsBySignature([]float64{180, 90, 90}, 5)
+		if len(tee) != 4 {
+			t.Errorf("expected 4 T junctions, got %d", len(tee))
+		}
+	})
+}
@@ -0,0 +1,245 @@
+package osmprocessing
+
+import (
+	"math"
+	"slices"
+	"sort"
+
+	"github.com/paulmach/osm"
+)
+
+type LandmarkType int
+
+const (
+	TrafficSignals LandmarkType = iota
+	StopSign
+	Crossing
+	BusStop
+	SpeedBump
+)
+
+func (t LandmarkType) String() string {
+	switch t {
+	case TrafficSignals:
+		return "traffic_signals"
+	case StopSign:
+		return "stop"
+	case Crossing:
+		return "crossing"
+	case BusStop:
+		return "bus_stop"
+	case SpeedBump:
+		return "speed_bump"
+	}
+	return "unknown"
+}
+
+// Landmark is a tagged OSM node that a robot can detect, attached to the
+// road it belongs to by NewEnhancedMap. Offset is the distance in metres
+// from the start of the way, WayID is 0 when no way was close enough to
+// attach to.
+type Landmark struct {
+	ID     osm.NodeID
+	Type   LandmarkType
+	Lat    float64
+	Lon    float64
+	WayID  osm.WayID
+	Offset float64
+}
+
+// maximum distance between a landmark and the road it is attached to
+const landmarkAttachDistance = 30.0
+
+var trafficCalmingBumps = map[string]bool{
+	"bump":    true,
+	"hump":    true,
+	"table":   true,
+	"cushion": true,
+}
+
+func landmarkTypeOf(tags osm.Tags) (LandmarkType, bool) {
+	switch tags.Find("highway") {
+	case "traffic_signals":
+		return TrafficSignals, true
+	case "stop":
+		return StopSign, true
+	case "crossing":
+		return Crossing, true
+	case "bus_stop":
+		return BusStop, true
+	}
+
+	if tags.Find("public_transport") == "platform" && tags.Find("bus") == "yes" {
+		return BusStop, true
+	}
+	if tags.HasTag("crossing") {
+		return Crossing, true
+	}
+	if trafficCalmingBumps[tags.Find("traffic_calming")] {
+		return SpeedBump, true
+	}
+
+	return 0, false
+}
+
+func extractLandmark(n *osm.Node) (*Landmark, bool) {
+	if n.Tags == nil {
+		return nil, false
+	}
+	typ, ok := landmarkTypeOf(n.Tags)
+	if !ok {
+		return nil, false
+	}
+	return &Landmark{ID: n.ID, Type: typ, Lat: n.Lat, Lon: n.Lon}, true
+}
+
+// AttachLandmarks links every landmark to the way it lies on, or to the
+// nearest way within maxDist found through index, and records its offset
+// along that way. NewEnhancedMap attaches them with its own way index.
+func (m *Map) AttachLandmarks(maxDist float64, index WayIndex) {
+	if len(m.Landmarks) == 0 {
+		return
+	}
+
+	onWay := make(map[osm.NodeID]*osm.Way)
+	for _, way := range m.Ways {
+		for _, wn := range way.Nodes {
+			if _, ok := onWay[wn.ID]; !ok {
+				onWay[wn.ID] = way
+			}
+		}
+	}
+
+	for _, lm := range m.Landmarks {
+		attachLandmark(lm, onWay[lm.ID], maxDist, index, m.Nodes)
+	}
+}
+
+// attachLandmark attaches lm to way, the way it lies on, or when way is nil
+// to the nearest way within maxDist.
+func attachLandmark(lm *Landmark, way *osm.Way, maxDist float64, index WayIndex, nodes map[osm.NodeID]*osm.Node) {
+	if way == nil {
+		way, _ = index.FindNearestWay(lm.Lat, lm.Lon, maxDist)
+	}
+	if way == nil {
+		lm.WayID = 0
+		lm.Offset = 0
+		return
+	}
+
+	proj, _ := ProjectOnWay(lm.Lat, lm.Lon, way, nodes)
+	lm.WayID = way.ID
+	lm.Offset = proj.AlongTrack
+}
+
+// wrapLonIdx brings a longitude cell index back between -180 and 180, so
+// neighbouring cells are found across the antimeridian.
+func wrapLonIdx(idx int, cellSize float64) int {
+	n := int(math.Round(360 / cellSize))
+	half := n / 2
+	return ((idx+half)%n+n)%n - half
+}
+
+// cellRadius returns how many degree cells of the landmark and building
+// grids a radius in metres spans along each axis at the given latitude.
+func cellRadius(lat, radius, cellSize float64) (latCells, lonCells int) {
+	latMeters, lonMeters := MetersPerDegree(lat)
+	maxCells := int(math.Ceil(360 / cellSize))
+
+	latCells = int(math.Ceil(radius/latMeters/cellSize)) + 1
+	lonCells = maxCells
+	if lonMeters > 0 {
+		lonCells = min(maxCells, int(math.Ceil(radius/lonMeters/cellSize))+1)
+	}
+	return latCells, lonCells
+}
+
+type LandmarkIndex struct {
+	grid     map[GridCell][]*Landmark
+	cellSize float64 // in degrees
+}
+
+func NewLandmarkIndex(cellSize float64) *LandmarkIndex {
+	return &LandmarkIndex{
+		grid:     make(map[GridCell][]*Landmark),
+		cellSize: cellSize,
+	}
+}
+
+func (li *LandmarkIndex) getCell(lat, lon float64) GridCell {
+	return GridCell{
+		LatIdx: int(math.Floor(lat / li.cellSize)),
+		LonIdx: wrapLonIdx(int(math.Floor(lon/li.cellSize)), li.cellSize),
+	}
+}
+
+func (li *LandmarkIndex) Insert(lm *Landmark) {
+	cell := li.getCell(lm.Lat, lm.Lon)
+	li.grid[cell] = append(li.grid[cell], lm)
+}
+
+func (li *LandmarkIndex) remove(lm *Landmark) {
+	cell := li.getCell(lm.Lat, lm.Lon)
+	landmarks := slices.DeleteFunc(slices.Clone(li.grid[cell]), func(l *Landmark) bool { return l == lm })
+	if len(landmarks) == 0 {
+		delete(li.grid, cell)
+	} else {
+		li.grid[cell] = landmarks
+	}
+}
+
+// Query returns the landmarks within radius metres, nearest first. When
+// types are given only landmarks of those types are returned.
+func (li *LandmarkIndex) Query(lat, lon, radius float64, types ...LandmarkType) []*Landmark {
+	centerCell := li.getCell(lat, lon)
+
+	latCells, lonCells := cellRadius(lat, radius, li.cellSize)
+
+	var results []*Landmark
+	distances := make(map[*Landmark]float64)
+
+	for dLat := -latCells; dLat <= latCells; dLat++ {
+		for dLon := -lonCells; dLon <= lonCells; dLon++ {
+			cell := GridCell{
+				LatIdx: centerCell.LatIdx + dLat,
+				LonIdx: wrapLonIdx(centerCell.LonIdx+dLon, li.cellSize),
+			}
+
+			for _, lm := range li.grid[cell] {
+				if _, seen := distances[lm]; seen {
+					continue
+				}
+				if len(types) > 0 && !slices.Contains(types, lm.Type) {
+					continue
+				}
+				dist := HaversineDistance(lat, lon, lm.Lat, lm.Lon)
+				if dist <= radius {
+					results = append(results, lm)
+					distances[lm] = dist
+				}
+			}
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return distances[results[i]] < distances[results[j]]
+	})
+
+	return results
+}
+
+func (li *LandmarkIndex) Nearest(lat, lon, maxDist float64, typ LandmarkType) (*Landmark, float64) {
+	candidates := li.Query(lat, lon, maxDist, typ)
+	if len(candidates) == 0 {
+		return nil, math.Inf(1)
+	}
+	return candidates[0], HaversineDistance(lat, lon, candidates[0].Lat, candidates[0].Lon)
+}
+
+func (m *Map) BuildLandmarkIndex(cellSize float64) *LandmarkIndex {
+	index := NewLandmarkIndex(cellSize)
+	for _, lm := range m.Landmarks {
+		index.Insert(lm)
+	}
+	return index
+}
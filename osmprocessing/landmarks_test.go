@@ -0,0 +1,125 @@
+package osmprocessing
+
+import (
+	"math"
+	"testing"
+
+	"github.com/paulmach/osm"
+)
+
+func TestLandmarkTypeOf(t *testing.T) {
+	tests := []struct {
+		name     string
+		tags     osm.Tags
+		expected LandmarkType
+		ok       bool
+	}{
+		{"traffic signals", osm.Tags{{Key: "highway", Value: "traffic_signals"}}, TrafficSignals, true},
+		{"stop sign", osm.Tags{{Key: "highway", Value: "stop"}}, StopSign, true},
+		{"crossing on highway", osm.Tags{{Key: "highway", Value: "crossing"}}, Crossing, true},
+		{"crossing tag only", osm.Tags{{Key: "crossing", Value: "zebra"}}, Crossing, true},
+		{"bus stop", osm.Tags{{Key: "highway", Value: "bus_stop"}}, BusStop, true},
+		{"bus platform", osm.Tags{{Key: "public_transport", Value: "platform"}, {Key: "bus", Value: "yes"}}, BusStop, true},
+		{"speed bump", osm.Tags{{Key: "traffic_calming", Value: "bump"}}, SpeedBump, true},
+		{"chicane is not a bump", osm.Tags{{Key: "traffic_calming", Value: "chicane"}}, 0, false},
+		{"plain node", osm.Tags{{Key: "name", Value: "somewhere"}}, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := landmarkTypeOf(tt.tags)
+			if ok != tt.ok || (ok && got != tt.expected) {
+				t.Errorf("got %v,%v want %v,%v", got, ok, tt.expected, tt.ok)
+			}
+		})
+	}
+}
+
+func TestAttachLandmarks(t *testing.T) {
+	m, grid := GenerateMap(0, 2, 100,
+		ToDecimalCoord(46, 0, 0, North),
+		ToDecimalCoord(7, 0, 0, East))
+
+	onRoad := m.Nodes[grid["0,1"]]
+	offRoad := m.Nodes[grid["0,2"]]
+
+	m.Landmarks = []*Landmark{
+		{ID: onRoad.ID, Type: TrafficSignals, Lat: onRoad.Lat, Lon: onRoad.Lon},
+		{ID: 1000, Type: BusStop, Lat: offRoad.Lat + 0.0001, Lon: offRoad.Lon - 0.0006},
+		{ID: 1001, Type: StopSign, Lat: 0, Lon: 0},
+	}
+	NewEnhancedMap(m)
+
+	t.Run("node on way", func(t *testing.T) {
+		lm := m.Landmarks[0]
+		if lm.WayID == 0 {
+			t.Fatal("expected landmark to be attached")
+		}
+		way := m.Ways[0]
+		for _, w := range m.Ways {
+			if w.ID == lm.WayID {
+				way = w
+			}
+		}
+		if way.Nodes[0].ID == lm.ID {
+			assertWithinPercent(t, lm.Offset, 0, 1.0)
+		} else {
+			assertWithinPercent(t, lm.Offset, 100, 2.0)
+		}
+	})
+
+	t.Run("node beside way", func(t *testing.T) {
+		lm := m.Landmarks[1]
+		if lm.WayID != m.Ways[1].ID {
+			t.Fatalf("expected way %d, got %d", m.Ways[1].ID, lm.WayID)
+		}
+		assertWithinPercent(t, lm.Offset, 54, 5.0)
+	})
+
+	t.Run("distant node stays unattached", func(t *testing.T) {
+		if m.Landmarks[2].WayID != 0 {
+			t.Errorf("expected no way, got %d", m.Landmarks[2].WayID)
+		}
+	})
+}
+
+func TestLandmarkIndex(t *testing.T) {
+	m, grid := GenerateMap(2, 2, 100,
+		ToDecimalCoord(46, 0, 0, North),
+		ToDecimalCoord(7, 0, 0, East))
+
+	for key, typ := range map[string]LandmarkType{"0,0": TrafficSignals, "1,1": StopSign, "2,2": TrafficSignals} {
+		n := m.Nodes[grid[key]]
+		m.Landmarks = append(m.Landmarks, &Landmark{ID: n.ID, Type: typ, Lat: n.Lat, Lon: n.Lon})
+	}
+
+	em := NewEnhancedMap(m)
+	center := m.Nodes[grid["1,1"]]
+
+	t.Run("query is sorted and radius exact", func(t *testing.T) {
+		got := em.LandmarkIndex.Query(center.Lat, center.Lon, 150)
+		if len(got) != 3 {
+			t.Fatalf("expected 3 landmarks, got %d", len(got))
+		}
+		if got[0].Type != StopSign {
+			t.Errorf("expected nearest to be the stop sign, got %v", got[0].Type)
+		}
+
+		got = em.LandmarkIndex.Query(center.Lat, center.Lon, 100)
+		if len(got) != 1 {
+			t.Errorf("expected 1 landmark within 100m, got %d", len(got))
+		}
+	})
+
+	t.Run("nearest by type", func(t *testing.T) {
+		lm, dist := em.FindNearestLandmark(center.Lat, center.Lon, 200, TrafficSignals)
+		if lm == nil {
+			t.Fatal("expected a traffic signal")
+		}
+		assertWithinPercent(t, dist, 100*math.Sqrt2, 2.0)
+
+		if lm, _ := em.FindNearestLandmark(center.Lat, center.Lon, 200, BusStop); lm != nil {
+			t.Error("expected no bus stop")
+		}
+	})
+}
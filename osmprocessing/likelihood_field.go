@@ -0,0 +1,291 @@
+package osmprocessing
+
+import (
+	"encoding/gob"
+	"fmt"
+	"math"
+	"os"
+
+	"github.com/paulmach/osm"
+)
+
+// LikelihoodField is a raster over the map holding, at each pixel, the
+// distance to the nearest road and that road's bearing, so a measurement
+// update costs a bilinear lookup instead of a nearest way search.
+//
+// Pixels are samples on the plane tangent at Lat0, Lon0, pixel (i, j) sits
+// at MinX + i*Resolution, MinY + j*Resolution. CrossTrack is signed like
+// WayProjection.CrossTrack, which keeps it linear across a road so the
+// interpolation stays exact on the road itself. Pixels with no road within
+// MaxDistance hold +Inf. Exact marks pixels next to one closest to another
+// road or another direction, around junctions and bends, where
+// interpolating between them would mix two roads.
+type LikelihoodField struct {
+	Lat0, Lon0    float64
+	MinX, MinY    float64
+	Resolution    float64 // metres per pixel
+	MaxDistance   float64
+	Width, Height int
+	CrossTrack    []float32
+	Bearing       []float32
+	Exact         []bool
+
+	projection *LocalENU
+}
+
+// likelihoodFieldTurn is the bearing change between neighbouring pixels
+// past which they are taken to lie on different roads.
+const likelihoodFieldTurn = 10.0
+
+// NewLikelihoodField rasterizes the ways of the map every resolution
+// metres, out to maxDistance from them.
+func NewLikelihoodField(m *Map, resolution, maxDistance float64) *LikelihoodField {
+	lat0, lon0 := m.CalculateBounds().GetCenter()
+	f := &LikelihoodField{
+		Lat0: lat0, Lon0: lon0,
+		Resolution:  resolution,
+		MaxDistance: maxDistance,
+		projection:  NewLocalENU(lat0, lon0),
+	}
+
+	// pixels out to a little past maxDistance, so a point is only ever
+	// next to a far pixel when it is further than maxDistance itself
+	reach := maxDistance + 2*resolution
+
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, node := range m.Nodes {
+		x, y := f.projection.Forward(node.Lat, node.Lon)
+		minX, minY = math.Min(minX, x), math.Min(minY, y)
+		maxX, maxY = math.Max(maxX, x), math.Max(maxY, y)
+	}
+	if len(m.Nodes) == 0 {
+		minX, minY, maxX, maxY = 0, 0, 0, 0
+	}
+
+	f.MinX, f.MinY = minX-reach, minY-reach
+	f.Width = int(math.Ceil((maxX+reach-f.MinX)/resolution)) + 1
+	f.Height = int(math.Ceil((maxY+reach-f.MinY)/resolution)) + 1
+
+	size := f.Width * f.Height
+	f.CrossTrack = make([]float32, size)
+	f.Bearing = make([]float32, size)
+	f.Exact = make([]bool, size)
+	// which segment, counted from 1, each pixel was last stamped by, and
+	// the nodes at the ends of each
+	nearest := make([]int32, size)
+	segment := int32(0)
+	ends := [][2]osm.NodeID{{}}
+	// pixels past the end of their segment, where distance is radial
+	beyondEnd := make([]bool, size)
+	for i := range f.CrossTrack {
+		f.CrossTrack[i] = float32(math.Inf(1))
+	}
+
+	for _, way := range m.Ways {
+		for i := 0; i < len(way.Nodes)-1; i++ {
+			n1, ok1 := m.Nodes[way.Nodes[i].ID]
+			n2, ok2 := m.Nodes[way.Nodes[i+1].ID]
+			if !ok1 || !ok2 {
+				continue
+			}
+			segment++
+			ends = append(ends, [2]osm.NodeID{n1.ID, n2.ID})
+			bearing := float32(CalculateBearing(n1.Lat, n1.Lon, n2.Lat, n2.Lon))
+			ax, ay := f.projection.Forward(n1.Lat, n1.Lon)
+			bx, by := f.projection.Forward(n2.Lat, n2.Lon)
+
+			f.stampSegment(ax, ay, bx, by, reach, func(idx int, cross float64, beyond bool) {
+				if math.Abs(cross) < math.Abs(float64(f.CrossTrack[idx])) {
+					f.CrossTrack[idx] = float32(cross)
+					f.Bearing[idx] = bearing
+					nearest[idx] = segment
+					beyondEnd[idx] = beyond
+				}
+			})
+		}
+	}
+
+	// the signed distance is linear only across the middle of a segment.
+	// Neighbours of opposite signs past the end of one or further apart
+	// than across a road lie either side of the line through a segment's
+	// end or between two roads. Neighbours on different segments can only
+	// be mixed where one road goes on straight into the next, not where
+	// roads cross, meet at a junction or bend
+	for j := 0; j < f.Height; j++ {
+		for i := 0; i < f.Width; i++ {
+			idx := j*f.Width + i
+			if nearest[idx] == 0 {
+				continue
+			}
+			for _, n := range [2]int{idx + 1, idx + f.Width} {
+				if (n == idx+1 && i+1 >= f.Width) || n >= size || nearest[n] == 0 {
+					continue
+				}
+				a, b := float64(f.CrossTrack[idx]), float64(f.CrossTrack[n])
+				split := (a < 0) != (b < 0) &&
+					(beyondEnd[idx] || beyondEnd[n] || math.Abs(a)+math.Abs(b) > 1.5*resolution)
+
+				joined := true
+				if s1, s2 := ends[nearest[idx]], ends[nearest[n]]; nearest[n] != nearest[idx] {
+					joined = (s1[0] == s2[0] || s1[0] == s2[1] || s1[1] == s2[0] || s1[1] == s2[1]) &&
+						math.Abs(BearingDifference(float64(f.Bearing[idx]), float64(f.Bearing[n]))) <= likelihoodFieldTurn
+				}
+				if split || !joined {
+					f.Exact[idx], f.Exact[n] = true, true
+				}
+			}
+		}
+	}
+
+	return f
+}
+
+// stampSegment calls set with the signed distance of every pixel within
+// reach of the segment, and whether the pixel is past either end. The
+// pixels within reach on a row form one interval since the region is
+// convex.
+func (f *LikelihoodField) stampSegment(ax, ay, bx, by, reach float64, set func(idx int, cross float64, beyond bool)) {
+	dx, dy := bx-ax, by-ay
+	length := math.Hypot(dx, dy)
+
+	fromJ := max(0, int(math.Ceil((math.Min(ay, by)-reach-f.MinY)/f.Resolution)))
+	toJ := min(f.Height-1, int(math.Floor((math.Max(ay, by)+reach-f.MinY)/f.Resolution)))
+
+	for j := fromJ; j <= toJ; j++ {
+		y := f.MinY + float64(j)*f.Resolution
+
+		lo, hi := math.Inf(1), math.Inf(-1)
+		for _, end := range [2][2]float64{{ax, ay}, {bx, by}} {
+			if h := reach*reach - (y-end[1])*(y-end[1]); h >= 0 {
+				lo, hi = math.Min(lo, end[0]-math.Sqrt(h)), math.Max(hi, end[0]+math.Sqrt(h))
+			}
+		}
+		if length > 0 {
+			// the band along the segment: 0 <= along <= length and
+			// |across| <= reach, both linear in x on this row
+			bandLo, bandHi := math.Inf(-1), math.Inf(1)
+			bandLo, bandHi = clipLinear(bandLo, bandHi, dx/length, (y-ay)*dy/length-ax*dx/length, 0, length)
+			bandLo, bandHi = clipLinear(bandLo, bandHi, dy/length, -(y-ay)*dx/length-ax*dy/length, -reach, reach)
+			if bandLo <= bandHi {
+				lo, hi = math.Min(lo, bandLo), math.Max(hi, bandHi)
+			}
+		}
+		if lo > hi {
+			continue
+		}
+
+		fromI := max(0, int(math.Ceil((lo-f.MinX)/f.Resolution)))
+		toI := min(f.Width-1, int(math.Floor((hi-f.MinX)/f.Resolution)))
+		for i := fromI; i <= toI; i++ {
+			x := f.MinX + float64(i)*f.Resolution
+			px, py := x-ax, y-ay
+
+			t := 0.0
+			if length > 0 {
+				t = (px*dx + py*dy) / (length * length)
+			}
+			beyond := t <= 0 || t >= 1
+			t = math.Max(0, math.Min(1, t))
+			dist := math.Hypot(px-t*dx, py-t*dy)
+			if dist > reach {
+				continue
+			}
+			// positive on the right of a to b, as in projectOnSegment
+			if dy*px-dx*py < 0 {
+				dist = -dist
+			}
+			set(j*f.Width+i, dist, beyond)
+		}
+	}
+}
+
+// clipLinear narrows [lo, hi] to the x where lower <= k*x + c <= upper.
+func clipLinear(lo, hi, k, c, lower, upper float64) (float64, float64) {
+	if k == 0 {
+		if c < lower || c > upper {
+			return math.Inf(1), math.Inf(-1)
+		}
+		return lo, hi
+	}
+	x1, x2 := (lower-c)/k, (upper-c)/k
+	return math.Max(lo, math.Min(x1, x2)), math.Min(hi, math.Max(x1, x2))
+}
+
+// Lookup interpolates the distance to the nearest road and its bearing at
+// the point. distance is +Inf when no road is within MaxDistance. exact is
+// true where the raster can't answer, near a junction or a bend, and the
+// caller should search the ways instead.
+func (f *LikelihoodField) Lookup(lat, lon float64) (distance, bearing float64, exact bool) {
+	x, y := f.projection.Forward(lat, lon)
+	fx, fy := (x-f.MinX)/f.Resolution, (y-f.MinY)/f.Resolution
+	i, j := int(math.Floor(fx)), int(math.Floor(fy))
+
+	if i < 0 || j < 0 || i+1 >= f.Width || j+1 >= f.Height {
+		return math.Inf(1), 0, false
+	}
+	tx, ty := fx-float64(i), fy-float64(j)
+
+	corners := [4]int{j*f.Width + i, j*f.Width + i + 1, (j+1)*f.Width + i, (j+1)*f.Width + i + 1}
+	weights := [4]float64{(1 - tx) * (1 - ty), tx * (1 - ty), (1 - tx) * ty, tx * ty}
+
+	var cross, turn float64
+	first := float64(f.Bearing[corners[0]])
+	for k, idx := range corners {
+		if f.Exact[idx] {
+			return 0, 0, true
+		}
+		if math.IsInf(float64(f.CrossTrack[idx]), 0) {
+			return math.Inf(1), 0, false
+		}
+		cross += weights[k] * float64(f.CrossTrack[idx])
+		turn += weights[k] * BearingDifference(first, float64(f.Bearing[idx]))
+	}
+
+	if math.Abs(cross) > f.MaxDistance {
+		return math.Inf(1), 0, false
+	}
+	return math.Abs(cross), NormalizeBearing(first + turn), false
+}
+
+// Save writes the field with gob, next to the map it was built from.
+func (f *LikelihoodField) Save(fname string) error {
+	file, err := os.Create(fname)
+	if err != nil {
+		return fmt.Errorf("failed to create %q %w", fname, err)
+	}
+	defer file.Close()
+
+	if err := gob.NewEncoder(file).Encode(f); err != nil {
+		return fmt.Errorf("failed to encode %q %w", fname, err)
+	}
+	return file.Close()
+}
+
+func LoadLikelihoodField(fname string) (*LikelihoodField, error) {
+	file, err := os.Open(fname)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %q %w", fname, err)
+	}
+	defer file.Close()
+
+	f := &LikelihoodField{}
+	if err := gob.NewDecoder(file).Decode(f); err != nil {
+		return nil, fmt.Errorf("failed to decode %q %w", fname, err)
+	}
+	if err := f.restore(); err != nil {
+		return nil, fmt.Errorf("failed to decode %q: %w", fname, err)
+	}
+
+	return f, nil
+}
+
+// restore checks a decoded field and sets up its projection again.
+func (f *LikelihoodField) restore() error {
+	if size := f.Width * f.Height; f.Width < 0 || f.Height < 0 ||
+		len(f.CrossTrack) != size || len(f.Bearing) != size || len(f.Exact) != size {
+		return fmt.Errorf("%d by %d field with %d pixels", f.Width, f.Height, len(f.CrossTrack))
+	}
+	f.projection = NewLocalENU(f.Lat0, f.Lon0)
+	return nil
+}
@@ -0,0 +1,145 @@
+package osmprocessing
+
+import (
+	"math"
+	"math/rand"
+	"path/filepath"
+	"testing"
+)
+
+func TestLikelihoodFieldMatchesSearch(t *testing.T) {
+	rng := rand.New(rand.NewSource(7))
+	m := randomWayMap(rng, 100, 44.84, -0.58, 500)
+	em := NewEnhancedMap(m)
+	field := NewLikelihoodField(m, 1, 30)
+
+	exactCount := 0
+	for i := 0; i < 5000; i++ {
+		lat, lon := DestinationPoint(44.84, -0.58, rng.Float64()*360, rng.Float64()*800)
+		distance, bearing, exact := field.Lookup(lat, lon)
+		if exact {
+			exactCount++
+			continue
+		}
+
+		wantDist, wantBearing, ok := em.NearestRoad(lat, lon, 30)
+		if math.IsInf(distance, 1) {
+			if ok {
+				t.Fatalf("%.6f,%.6f: field has no road, search found one at %.2f", lat, lon, wantDist)
+			}
+			continue
+		}
+		if !ok {
+			t.Fatalf("%.6f,%.6f: field has a road at %.2f, search none", lat, lon, distance)
+		}
+		if math.Abs(distance-wantDist) > 0.25 {
+			t.Errorf("%.6f,%.6f: distance %.3f want %.3f", lat, lon, distance, wantDist)
+		}
+		// where two segments meet both are closest, either bearing will do
+		atNode := false
+		if match := em.NearestWays(lat, lon, 1, 30); len(match) > 0 {
+			atNode = match[0].T == 0 || match[0].T == 1
+		}
+		if !atNode && math.Abs(BearingDifference(bearing, wantBearing)) > likelihoodFieldTurn {
+			t.Errorf("%.6f,%.6f: bearing %.1f want %.1f", lat, lon, bearing, wantBearing)
+		}
+	}
+
+	if exactCount > 1500 {
+		t.Errorf("%d of 5000 lookups fell back to a search", exactCount)
+	}
+}
+
+func TestLikelihoodFieldRoads(t *testing.T) {
+	m, grid := GenerateMap(2, 2, 200,
+		ToDecimalCoord(44, 50, 0, North),
+		ToDecimalCoord(0, 35, 0, West))
+	em := NewEnhancedMap(m)
+	field := em.BuildLikelihoodField(2, 50)
+
+	// on the street, between two rows of pixels
+	a, b := m.Nodes[grid["1,0"]], m.Nodes[grid["1,1"]]
+	lat, lon := (a.Lat+b.Lat)/2, (a.Lon+b.Lon)/2
+	distance, bearing, exact := field.Lookup(lat, lon)
+	if exact || distance > 0.01 || math.Abs(BearingDifference(bearing, CalculateBearing(a.Lat, a.Lon, b.Lat, b.Lon))) > 0.01 {
+		t.Errorf("on the street got %.3f %.2f %v", distance, bearing, exact)
+	}
+
+	// 7.3m off it, across pixels
+	lat, lon = DestinationPoint(lat, lon, 0, 7.3)
+	if distance, _, _ := field.Lookup(lat, lon); math.Abs(distance-7.3) > 0.01 {
+		t.Errorf("7.3m north got %.3f", distance)
+	}
+
+	// between the arms of a junction the raster can't tell the roads apart
+	junction := m.Nodes[grid["1,1"]]
+	lat, lon = DestinationPoint(junction.Lat, junction.Lon, 45, 3)
+	if _, _, exact := field.Lookup(lat, lon); !exact {
+		t.Error("no exact fallback at the junction")
+	}
+	if distance, _, ok := em.NearestRoad(lat, lon, 50); !ok || math.Abs(distance-3*math.Sqrt2/2) > 0.05 {
+		t.Errorf("near the junction got %.3f %v", distance, ok)
+	}
+
+	if distance, _, _ := field.Lookup(0, 0); !math.IsInf(distance, 1) {
+		t.Errorf("off the raster got %v", distance)
+	}
+	if _, _, ok := em.NearestRoad(lat, lon, 51); !ok {
+		t.Error("a search further than the field did not fall back")
+	}
+}
+
+func TestLikelihoodFieldSaveLoad(t *testing.T) {
+	rng := rand.New(rand.NewSource(8))
+	m := randomWayMap(rng, 20, 44.84, -0.58, 300)
+	field := NewLikelihoodField(m, 2, 20)
+
+	fname := filepath.Join(t.TempDir(), "bordeaux.field")
+	if err := field.Save(fname); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadLikelihoodField(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 200; i++ {
+		lat, lon := DestinationPoint(44.84, -0.58, rng.Float64()*360, rng.Float64()*400)
+		d1, b1, e1 := field.Lookup(lat, lon)
+		d2, b2, e2 := loaded.Lookup(lat, lon)
+		if d1 != d2 && !(math.IsInf(d1, 1) && math.IsInf(d2, 1)) || b1 != b2 || e1 != e2 {
+			t.Fatalf("loaded field differs: %v %v %v vs %v %v %v", d1, b1, e1, d2, b2, e2)
+		}
+	}
+
+	if _, err := LoadLikelihoodField(filepath.Join(t.TempDir(), "missing.field")); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
+
+func BenchmarkNearestRoad(b *testing.B) {
+	rng := rand.New(rand.NewSource(9))
+	m := randomWayMap(rng, 2000, 44.84, -0.58, 2000)
+	em := NewEnhancedMap(m)
+
+	var queries [][2]float64
+	for i := 0; i < 1000; i++ {
+		lat, lon := DestinationPoint(44.84, -0.58, rng.Float64()*360, rng.Float64()*2000)
+		queries = append(queries, [2]float64{lat, lon})
+	}
+
+	b.Run("search", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			q := queries[i%len(queries)]
+			em.NearestRoad(q[0], q[1], 50)
+		}
+	})
+
+	em.BuildLikelihoodField(1, 50)
+	b.Run("field", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			q := queries[i%len(queries)]
+			em.NearestRoad(q[0], q[1], 50)
+		}
+	})
+}
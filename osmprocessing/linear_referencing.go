@@ -0,0 +1,231 @@
+package osmprocessing
+
+import (
+	"math"
+	"slices"
+
+	"github.com/paulmach/osm"
+)
+
+// wayOffsets returns the distance from the start of the way to each of its
+// nodes. Segments with missing nodes have no length, like in GetWayLength.
+func wayOffsets(way *osm.Way, nodes map[osm.NodeID]*osm.Node) []float64 {
+	offsets := make([]float64, len(way.Nodes))
+
+	for i := 0; i < len(way.Nodes)-1; i++ {
+		offsets[i+1] = offsets[i]
+
+		node1, ok1 := nodes[way.Nodes[i].ID]
+		node2, ok2 := nodes[way.Nodes[i+1].ID]
+		if ok1 && ok2 {
+			offsets[i+1] += HaversineDistance(node1.Lat, node1.Lon, node2.Lat, node2.Lon)
+		}
+	}
+
+	return offsets
+}
+
+// PointAtOffset interpolates the position of the way offset metres from its
+// start, and the heading of the way there. The offset is clamped to the
+// length of the way. On a node the heading is the one of the next segment.
+func PointAtOffset(way *osm.Way, offset float64, nodes map[osm.NodeID]*osm.Node) (lat, lon, heading float64, ok bool) {
+	return pointAtOffset(way, offset, true, nodes)
+}
+
+// pointAtOffset looks at the segment ahead in the direction of travel, so
+// travelling backwards on a node uses the segment before it, reversed.
+func pointAtOffset(way *osm.Way, offset float64, forward bool, nodes map[osm.NodeID]*osm.Node) (lat, lon, heading float64, ok bool) {
+	offsets := wayOffsets(way, nodes)
+
+	best := -1
+	for i := 0; i < len(way.Nodes)-1; i++ {
+		_, ok1 := nodes[way.Nodes[i].ID]
+		_, ok2 := nodes[way.Nodes[i+1].ID]
+		if !ok1 || !ok2 {
+			continue
+		}
+
+		if best == -1 || (forward && offset >= offsets[i]) || (!forward && offset > offsets[i]) {
+			best = i
+		}
+	}
+	if best == -1 {
+		return 0, 0, 0, false
+	}
+
+	node1 := nodes[way.Nodes[best].ID]
+	node2 := nodes[way.Nodes[best+1].ID]
+
+	t := 0.0
+	if segLength := offsets[best+1] - offsets[best]; segLength > 0 {
+		t = math.Max(0, math.Min(1, (offset-offsets[best])/segLength))
+	}
+
+	heading = CalculateBearing(node1.Lat, node1.Lon, node2.Lat, node2.Lon)
+	if !forward {
+		heading = NormalizeBearing(heading + 180)
+	}
+
+	return node1.Lat + t*(node2.Lat-node1.Lat), node1.Lon + t*(node2.Lon-node1.Lon), heading, true
+}
+
+// OffsetOnWay is the distance from the start of the way to the point of the
+// way closest to lat, lon.
+func OffsetOnWay(lat, lon float64, way *osm.Way, nodes map[osm.NodeID]*osm.Node) (float64, bool) {
+	proj, ok := ProjectOnWay(lat, lon, way, nodes)
+	return proj.AlongTrack, ok
+}
+
+// SubPolyline returns the part of the way between two offsets, in the order
+// they are given, so from > to walks the way backwards.
+func SubPolyline(way *osm.Way, from, to float64, nodes map[osm.NodeID]*osm.Node) []LatLon {
+	if from > to {
+		line := SubPolyline(way, to, from, nodes)
+		slices.Reverse(line)
+		return line
+	}
+
+	startLat, startLon, _, ok := PointAtOffset(way, from, nodes)
+	if !ok {
+		return nil
+	}
+	endLat, endLon, _, _ := PointAtOffset(way, to, nodes)
+
+	line := []LatLon{{startLat, startLon}}
+
+	offsets := wayOffsets(way, nodes)
+	for i, wn := range way.Nodes {
+		node, ok := nodes[wn.ID]
+		if !ok || offsets[i] <= from || offsets[i] >= to {
+			continue
+		}
+		line = append(line, LatLon{node.Lat, node.Lon})
+	}
+
+	return append(line, LatLon{endLat, endLon})
+}
+
+// WayPosition is a position on a way with a direction of travel.
+type WayPosition struct {
+	Way     *osm.Way
+	Offset  float64 // metres from the start of the way
+	Forward bool    // travelling in the direction of the way
+}
+
+// SuccessorChooser picks where to go at a node where the road branches.
+// Candidates start on the node and include going on along the current way.
+// It returns an index in candidates, or -1 to stop on the node.
+type SuccessorChooser func(node osm.NodeID, current WayPosition, candidates []WayPosition) int
+
+// Position returns the coordinates of pos and the heading of travel there.
+func (em *EnhancedMap) Position(pos WayPosition) (lat, lon, heading float64) {
+	lat, lon, heading, _ = pointAtOffset(pos.Way, pos.Offset, pos.Forward, em.Nodes)
+	return lat, lon, heading
+}
+
+// Walk moves distance metres from pos, following the road through the
+// nodes it shares with other ways. Where there is more than one way to go
+// on, choose decides. ok is false when the walk stopped early on a dead end
+// or because choose returned -1, the returned position is where it stopped.
+// A nil choose uses StraightestSuccessor.
+func (em *EnhancedMap) Walk(pos WayPosition, distance float64, choose SuccessorChooser) (WayPosition, bool) {
+	if len(pos.Way.Nodes) < 2 {
+		return pos, false
+	}
+	if choose == nil {
+		choose = em.StraightestSuccessor
+	}
+
+	remaining := distance
+	nodeIdx := -1 // index in pos.Way of the node pos is on, -1 between nodes
+
+	for {
+		offsets := wayOffsets(pos.Way, em.Nodes)
+
+		var next int
+		switch {
+		case nodeIdx >= 0 && pos.Forward:
+			next = nodeIdx + 1
+		case nodeIdx >= 0:
+			next = nodeIdx - 1
+		case pos.Forward:
+			next = len(offsets) - 1
+			for i, offset := range offsets {
+				if offset > pos.Offset {
+					next = i
+					break
+				}
+			}
+		default:
+			next = 0
+			for i := len(offsets) - 1; i >= 0; i-- {
+				if offsets[i] < pos.Offset {
+					next = i
+					break
+				}
+			}
+		}
+
+		if next >= 0 && next < len(offsets) {
+			gap := math.Abs(offsets[next] - pos.Offset)
+			if remaining <= gap {
+				if pos.Forward {
+					pos.Offset += remaining
+				} else {
+					pos.Offset -= remaining
+				}
+				return pos, true
+			}
+			remaining -= gap
+			pos.Offset = offsets[next]
+			nodeIdx = next
+		}
+
+		candidates, indexes := em.successors(pos, nodeIdx)
+
+		choice := 0
+		switch {
+		case len(candidates) == 0:
+			return pos, false
+		case len(candidates) > 1:
+			choice = choose(pos.Way.Nodes[nodeIdx].ID, pos, candidates)
+			if choice < 0 || choice >= len(candidates) {
+				return pos, false
+			}
+		}
+
+		pos, nodeIdx = candidates[choice], indexes[choice]
+	}
+}
+
+// successors lists the ways leaving node nodeIdx of pos.Way that may be
+// taken, except turning back the way we came, with the index of the node
+// in each of them.
+func (em *EnhancedMap) successors(pos WayPosition, nodeIdx int) ([]WayPosition, []int) {
+	var candidates []WayPosition
+	var indexes []int
+
+	for _, mv := range em.moves(pos.Way, nodeIdx, pos.Forward) {
+		candidates = append(candidates, mv.Position(em.Nodes))
+		indexes = append(indexes, mv.NodeIdx)
+	}
+
+	return candidates, indexes
+}
+
+// StraightestSuccessor is a SuccessorChooser that keeps the heading closest
+// to the one the node was reached with.
+func (em *EnhancedMap) StraightestSuccessor(node osm.NodeID, current WayPosition, candidates []WayPosition) int {
+	// heading of the segment behind, reversed
+	_, _, back, _ := pointAtOffset(current.Way, current.Offset, !current.Forward, em.Nodes)
+	arrival := NormalizeBearing(back + 180)
+
+	best, bestDiff := -1, math.Inf(1)
+	for i, candidate := range candidates {
+		_, _, heading := em.Position(candidate)
+		if diff := math.Abs(BearingDifference(arrival, heading)); diff < bestDiff {
+			best, bestDiff = i, diff
+		}
+	}
+	return best
+}
@@ -0,0 +1,176 @@
+package osmprocessing
+
+import (
+	"math"
+	"testing"
+
+	"github.com/paulmach/osm"
+)
+
+func TestLinearReferencing(t *testing.T) {
+	m, grid := GenerateMap(1, 2, 100,
+		ToDecimalCoord(46, 0, 0, North),
+		ToDecimalCoord(7, 0, 0, East))
+
+	// east along the bottom row, then north
+	way := &osm.Way{ID: 100, Nodes: osm.WayNodes{
+		{ID: grid["0,0"]}, {ID: grid["0,1"]}, {ID: grid["0,2"]}, {ID: grid["1,2"]},
+	}}
+	length := GetWayLength(way, m.Nodes)
+
+	t.Run("point at offset", func(t *testing.T) {
+		lat, lon, heading, ok := PointAtOffset(way, 150, m.Nodes)
+		if !ok {
+			t.Fatal("expected a point")
+		}
+		n1, n2 := m.Nodes[grid["0,1"]], m.Nodes[grid["0,2"]]
+		assertWithinPercent(t, HaversineDistance(n1.Lat, n1.Lon, lat, lon), 50, 0.5)
+		assertWithinPercent(t, HaversineDistance(n2.Lat, n2.Lon, lat, lon), 50, 0.5)
+		if math.Abs(BearingDifference(heading, 90)) > 0.1 {
+			t.Errorf("heading %.2f, want 90", heading)
+		}
+
+		// on a node the heading is the one of the next segment
+		_, _, heading, _ = PointAtOffset(way, wayOffsets(way, m.Nodes)[2], m.Nodes)
+		if math.Abs(BearingDifference(heading, 0)) > 0.1 {
+			t.Errorf("heading on the corner %.2f, want 0", heading)
+		}
+	})
+
+	t.Run("offsets are clamped", func(t *testing.T) {
+		lat, lon, _, _ := PointAtOffset(way, -10, m.Nodes)
+		if start := m.Nodes[grid["0,0"]]; lat != start.Lat || lon != start.Lon {
+			t.Errorf("got %.7f,%.7f want the first node", lat, lon)
+		}
+		lat, lon, _, _ = PointAtOffset(way, length+10, m.Nodes)
+		if end := m.Nodes[grid["1,2"]]; lat != end.Lat || lon != end.Lon {
+			t.Errorf("got %.7f,%.7f want the last node", lat, lon)
+		}
+	})
+
+	t.Run("offset round trip", func(t *testing.T) {
+		for _, offset := range []float64{0, 12.5, 99, 201.25, length} {
+			lat, lon, _, _ := PointAtOffset(way, offset, m.Nodes)
+			got, ok := OffsetOnWay(lat, lon, way, m.Nodes)
+			if !ok || math.Abs(got-offset) > 0.01 {
+				t.Errorf("offset %.2f came back as %.4f", offset, got)
+			}
+		}
+	})
+
+	t.Run("sub polyline", func(t *testing.T) {
+		line := SubPolyline(way, 50, 250, m.Nodes)
+		if len(line) != 4 {
+			t.Fatalf("got %d points, want 4", len(line))
+		}
+		if corner := m.Nodes[grid["0,2"]]; line[2] != (LatLon{corner.Lat, corner.Lon}) {
+			t.Errorf("third point %v is not the corner", line[2])
+		}
+
+		total := 0.0
+		for i := 0; i < len(line)-1; i++ {
+			total += HaversineDistance(line[i].Lat, line[i].Lon, line[i+1].Lat, line[i+1].Lon)
+		}
+		assertWithinPercent(t, total, 200, 0.5)
+
+		reversed := SubPolyline(way, 250, 50, m.Nodes)
+		for i := range line {
+			if reversed[len(reversed)-1-i] != line[i] {
+				t.Fatalf("reversed polyline %v is not %v backwards", reversed, line)
+			}
+		}
+	})
+}
+
+func TestWalk(t *testing.T) {
+	m, grid := GenerateMap(2, 2, 100,
+		ToDecimalCoord(46, 0, 0, North),
+		ToDecimalCoord(7, 0, 0, East))
+	em := NewEnhancedMap(m)
+
+	wayBetween := func(a, b string) *osm.Way {
+		for _, way := range em.NodeToWays[grid[a]] {
+			if way.Nodes[0].ID == grid[a] && way.Nodes[1].ID == grid[b] {
+				return way
+			}
+		}
+		t.Fatalf("no way from %s to %s", a, b)
+		return nil
+	}
+
+	start := WayPosition{Way: wayBetween("0,0", "0,1"), Offset: 0, Forward: true}
+
+	t.Run("within the way", func(t *testing.T) {
+		pos, ok := em.Walk(start, 37.5, nil)
+		if !ok || pos.Way != start.Way || pos.Offset != 37.5 {
+			t.Errorf("got way %d offset %.2f", pos.Way.ID, pos.Offset)
+		}
+	})
+
+	t.Run("straight through a junction", func(t *testing.T) {
+		pos, ok := em.Walk(start, 150, nil)
+		if !ok || pos.Way != wayBetween("0,1", "0,2") || !pos.Forward {
+			t.Fatalf("got way %d forward %v", pos.Way.ID, pos.Forward)
+		}
+		assertWithinPercent(t, pos.Offset, 50, 0.5)
+	})
+
+	t.Run("chosen turn", func(t *testing.T) {
+		turnLeft := func(node osm.NodeID, current WayPosition, candidates []WayPosition) int {
+			for i, c := range candidates {
+				if _, _, heading := em.Position(c); math.Abs(BearingDifference(heading, 0)) < 1 {
+					return i
+				}
+			}
+			return -1
+		}
+
+		pos, ok := em.Walk(start, 150, turnLeft)
+		if !ok || pos.Way != wayBetween("0,1", "1,1") {
+			t.Fatalf("got way %d", pos.Way.ID)
+		}
+		lat, lon, heading := em.Position(pos)
+		corner := m.Nodes[grid["0,1"]]
+		assertWithinPercent(t, HaversineDistance(corner.Lat, corner.Lon, lat, lon), 50, 0.5)
+		if math.Abs(BearingDifference(heading, 0)) > 0.1 {
+			t.Errorf("heading %.2f, want 0", heading)
+		}
+	})
+
+	t.Run("backwards", func(t *testing.T) {
+		from := WayPosition{Way: wayBetween("0,1", "0,2"), Offset: 50, Forward: false}
+		pos, ok := em.Walk(from, 100, nil)
+		if !ok || pos.Way != start.Way || pos.Forward {
+			t.Fatalf("got way %d forward %v", pos.Way.ID, pos.Forward)
+		}
+		assertWithinPercent(t, pos.Offset, GetWayLength(start.Way, m.Nodes)-50, 0.5)
+	})
+
+	t.Run("stop at a junction", func(t *testing.T) {
+		stop := func(osm.NodeID, WayPosition, []WayPosition) int { return -1 }
+		pos, ok := em.Walk(start, 150, stop)
+		if ok {
+			t.Error("expected the walk to stop")
+		}
+		if pos.Way != start.Way || pos.Offset != GetWayLength(start.Way, m.Nodes) {
+			t.Errorf("stopped on way %d at %.2f", pos.Way.ID, pos.Offset)
+		}
+	})
+
+	t.Run("dead end", func(t *testing.T) {
+		dm, dgrid := GenerateMap(0, 2, 100,
+			ToDecimalCoord(46, 0, 0, North),
+			ToDecimalCoord(7, 0, 0, East))
+		single := &osm.Way{ID: 1, Nodes: osm.WayNodes{{ID: dgrid["0,0"]}, {ID: dgrid["0,1"]}, {ID: dgrid["0,2"]}}}
+		dm.Ways = []*osm.Way{single}
+		dem := NewEnhancedMap(dm)
+
+		pos, ok := dem.Walk(WayPosition{Way: single, Forward: true}, 500, nil)
+		if ok {
+			t.Error("expected the walk to stop at the dead end")
+		}
+		if pos.Offset != GetWayLength(single, dm.Nodes) {
+			t.Errorf("stopped at %.2f", pos.Offset)
+		}
+	})
+}
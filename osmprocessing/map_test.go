@@ -208,7 +208,7 @@ func TestSpatialIndex(t *testing.T) {
 		ToDecimalCoord(46, 0, 0, North),
 		ToDecimalCoord(7, 0, 0, East))
 
-	index := m.BuildSpatialIndex(0.001)
+	index := m.BuildSpatialIndex(100)
 
 	t.Run("query ways in radius", func(t *testing.T) {
 		bounds := m.CalculateBounds()
@@ -246,7 +246,7 @@ func TestFindNearestWayWithIndex(t *testing.T) {
 		ToDecimalCoord(7, 0, 0, East))
 
 	//m.Ways = GenerateAllWays(m, grid, 2, 2)
-	index := m.BuildSpatialIndex(0.001)
+	index := m.BuildSpatialIndex(100)
 
 	originNode := m.Nodes[grid["0,0"]]
 
@@ -364,7 +364,7 @@ func BenchmarkFindNearestWay(b *testing.B) {
 		ToDecimalCoord(7, 0, 0, East))
 
 	//m.Ways = GenerateAllWays(m, grid, 10, 10)
-	index := m.BuildSpatialIndex(0.001)
+	index := m.BuildSpatialIndex(100)
 
 	testNode := m.Nodes[grid["5,5"]]
 
@@ -0,0 +1,249 @@
+package osmprocessing
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/paulmach/osm"
+)
+
+// TurnRestriction forbids, or with an only_ type imposes, going from one
+// way to another through a node, from an OSM type=restriction relation.
+// From and To are the IDs of the ways of the map, after they were split at
+// intersections. Restrictions through a way rather than a node are not
+// kept.
+type TurnRestriction struct {
+	ID   osm.RelationID
+	Type string // the restriction tag, such as no_left_turn or only_straight_on
+	From osm.WayID
+	Via  osm.NodeID
+	To   osm.WayID
+}
+
+// extractRestrictions reads the restrictions through a node and moves them
+// onto the pieces of their from and to ways that touch the node, origin
+// gives the way each piece was cut from.
+func extractRestrictions(relations []*osm.Relation, split []*osm.Way, origin map[osm.WayID]osm.WayID) []*TurnRestriction {
+	pieces := make(map[osm.WayID][]*osm.Way)
+	for _, way := range split {
+		pieces[origin[way.ID]] = append(pieces[origin[way.ID]], way)
+	}
+	touching := func(id osm.WayID, node osm.NodeID) []*osm.Way {
+		var ways []*osm.Way
+		for _, way := range pieces[id] {
+			if slices.ContainsFunc(way.Nodes, func(wn osm.WayNode) bool { return wn.ID == node }) {
+				ways = append(ways, way)
+			}
+		}
+		return ways
+	}
+
+	var restrictions []*TurnRestriction
+	for _, r := range relations {
+		typ := r.Tags.Find("restriction")
+		if typ == "" {
+			typ = r.Tags.Find("restriction:motorcar")
+		}
+
+		var from, to osm.WayID
+		var via osm.NodeID
+		for _, m := range r.Members {
+			switch {
+			case m.Role == "from" && m.Type == osm.TypeWay:
+				from = osm.WayID(m.Ref)
+			case m.Role == "to" && m.Type == osm.TypeWay:
+				to = osm.WayID(m.Ref)
+			case m.Role == "via" && m.Type == osm.TypeNode:
+				via = osm.NodeID(m.Ref)
+			}
+		}
+		if typ == "" || from == 0 || to == 0 || via == 0 {
+			continue
+		}
+
+		for _, f := range touching(from, via) {
+			for _, t := range touching(to, via) {
+				restrictions = append(restrictions, &TurnRestriction{ID: r.ID, Type: typ, From: f.ID, Via: via, To: t.ID})
+			}
+		}
+	}
+	return restrictions
+}
+
+// Only is true for restrictions naming the only way allowed.
+func (r *TurnRestriction) Only() bool {
+	return strings.HasPrefix(r.Type, "only_")
+}
+
+// Move is a way to leave a node, or to reach it for PreviousWays.
+type Move struct {
+	Way     *osm.Way
+	Forward bool    // travelling in the direction of the way
+	NodeIdx int     // index of the node in Way.Nodes
+	Turn    float64 // degrees, positive to the right, 0 straight on
+}
+
+// Position is where the move leaves the node, for Walk.
+func (mv Move) Position(nodes map[osm.NodeID]*osm.Node) WayPosition {
+	return WayPosition{Way: mv.Way, Offset: wayOffsets(mv.Way, nodes)[mv.NodeIdx], Forward: mv.Forward}
+}
+
+// Oneway is 1 when the way may only be travelled forwards, -1 only
+// backwards and 0 both ways. Roundabouts and motorways are oneway unless
+// tagged otherwise.
+func Oneway(way *osm.Way) int {
+	switch way.Tags.Find("oneway") {
+	case "yes", "true", "1":
+		return 1
+	case "-1", "reverse":
+		return -1
+	case "no", "false", "0":
+		return 0
+	}
+
+	switch {
+	case way.Tags.Find("junction") == "roundabout", way.Tags.Find("junction") == "circular",
+		way.Tags.Find("highway") == "motorway":
+		return 1
+	}
+	return 0
+}
+
+func canTravel(way *osm.Way, forward bool) bool {
+	switch Oneway(way) {
+	case 1:
+		return forward
+	case -1:
+		return !forward
+	}
+	return true
+}
+
+func (em *EnhancedMap) buildRestrictions() {
+	em.restrictions = make(map[osm.NodeID][]*TurnRestriction)
+	for _, r := range em.Restrictions {
+		em.restrictions[r.Via] = append(em.restrictions[r.Via], r)
+	}
+}
+
+// arrivalHeading is the heading of travel on reaching node nodeIdx of the
+// way, and departureHeading on leaving it.
+func (em *EnhancedMap) arrivalHeading(way *osm.Way, nodeIdx int, forward bool) float64 {
+	if forward {
+		return GetWayHeading(way, nodeIdx-1, em.Nodes)
+	}
+	return NormalizeBearing(GetWayHeading(way, nodeIdx, em.Nodes) + 180)
+}
+
+func (em *EnhancedMap) departureHeading(way *osm.Way, nodeIdx int, forward bool) float64 {
+	if forward {
+		return GetWayHeading(way, nodeIdx, em.Nodes)
+	}
+	return NormalizeBearing(GetWayHeading(way, nodeIdx-1, em.Nodes) + 180)
+}
+
+// moves lists the ways to leave node nodeIdx of way having reached it in
+// the given direction, going on along the way included, but not turning
+// back along it. Oneway tags and turn restrictions through the node are
+// honoured.
+func (em *EnhancedMap) moves(way *osm.Way, nodeIdx int, forward bool) []Move {
+	nodeID := way.Nodes[nodeIdx].ID
+	arrival := em.arrivalHeading(way, nodeIdx, forward)
+
+	// an only_ restriction leaves a single way, a no_ one takes one away
+	var only []osm.WayID
+	banned := make(map[osm.WayID]bool)
+	for _, r := range em.restrictions[nodeID] {
+		if r.From != way.ID {
+			continue
+		}
+		if r.Only() {
+			only = append(only, r.To)
+		} else {
+			banned[r.To] = true
+		}
+	}
+
+	var moves []Move
+	seen := make(map[osm.WayID]bool)
+	for _, next := range em.NodeToWays[nodeID] {
+		if seen[next.ID] || banned[next.ID] || len(only) > 0 && !slices.Contains(only, next.ID) {
+			continue
+		}
+		seen[next.ID] = true
+
+		for i, wn := range next.Nodes {
+			if wn.ID != nodeID {
+				continue
+			}
+			uturn := next == way && i == nodeIdx
+
+			for _, dir := range [2]bool{true, false} {
+				if dir && i == len(next.Nodes)-1 || !dir && i == 0 ||
+					uturn && dir != forward || !canTravel(next, dir) {
+					continue
+				}
+				moves = append(moves, Move{
+					Way:     next,
+					Forward: dir,
+					NodeIdx: i,
+					Turn:    BearingDifference(arrival, em.departureHeading(next, i, dir)),
+				})
+			}
+		}
+	}
+	return moves
+}
+
+// NextWays lists where travel along the whole way in the given direction
+// may go on from its last node, with the turn each takes.
+func (em *EnhancedMap) NextWays(way *osm.Way, forward bool) []Move {
+	if len(way.Nodes) < 2 {
+		return nil
+	}
+	end := len(way.Nodes) - 1
+	if !forward {
+		end = 0
+	}
+	return em.moves(way, end, forward)
+}
+
+// PreviousWays lists the ways from which travel may enter the way in the
+// given direction at its first node. Each move is the arrival on that
+// node, Turn is the turn onto the way.
+func (em *EnhancedMap) PreviousWays(way *osm.Way, forward bool) []Move {
+	if len(way.Nodes) < 2 {
+		return nil
+	}
+	start := 0
+	if !forward {
+		start = len(way.Nodes) - 1
+	}
+	nodeID := way.Nodes[start].ID
+
+	var previous []Move
+	seen := make(map[osm.WayID]bool)
+	for _, prev := range em.NodeToWays[nodeID] {
+		if seen[prev.ID] {
+			continue
+		}
+		seen[prev.ID] = true
+
+		for i, wn := range prev.Nodes {
+			if wn.ID != nodeID {
+				continue
+			}
+			for _, dir := range [2]bool{true, false} {
+				if dir && i == 0 || !dir && i == len(prev.Nodes)-1 || !canTravel(prev, dir) {
+					continue
+				}
+				for _, mv := range em.moves(prev, i, dir) {
+					if mv.Way == way && mv.Forward == forward && mv.NodeIdx == start {
+						previous = append(previous, Move{Way: prev, Forward: dir, NodeIdx: i, Turn: mv.Turn})
+					}
+				}
+			}
+		}
+	}
+	return previous
+}
@@ -0,0 +1,158 @@
+package osmprocessing
+
+import (
+	"math"
+	"testing"
+
+	"github.com/paulmach/osm"
+)
+
+// crossroads is a junction at node 1 with an arm of 100m to each of north
+// (2), east (3), south (4) and west (5). Ways 2 to 5 run from the centre
+// out, except the west one which runs in.
+func crossroads(tags map[osm.WayID]osm.Tags, restrictions ...*TurnRestriction) (*EnhancedMap, map[string]*osm.Way) {
+	m := &Map{Nodes: map[osm.NodeID]*osm.Node{1: {ID: 1, Lat: 44.84, Lon: -0.58}}}
+	for i, bearing := range []float64{0, 90, 180, 270} {
+		lat, lon := DestinationPoint(44.84, -0.58, bearing, 100)
+		m.Nodes[osm.NodeID(i+2)] = &osm.Node{ID: osm.NodeID(i + 2), Lat: lat, Lon: lon}
+	}
+
+	arms := map[string]*osm.Way{
+		"north": {ID: 2, Nodes: osm.WayNodes{{ID: 1}, {ID: 2}}},
+		"east":  {ID: 3, Nodes: osm.WayNodes{{ID: 1}, {ID: 3}}},
+		"south": {ID: 4, Nodes: osm.WayNodes{{ID: 1}, {ID: 4}}},
+		"west":  {ID: 5, Nodes: osm.WayNodes{{ID: 5}, {ID: 1}}},
+	}
+	for _, name := range []string{"north", "east", "south", "west"} {
+		way := arms[name]
+		way.Tags = append(osm.Tags{{Key: "highway", Value: "residential"}}, tags[way.ID]...)
+		m.Ways = append(m.Ways, way)
+	}
+	m.Restrictions = restrictions
+
+	return NewEnhancedMap(m), arms
+}
+
+func moveTurns(moves []Move) map[osm.WayID]float64 {
+	turns := make(map[osm.WayID]float64)
+	for _, mv := range moves {
+		turns[mv.Way.ID] = mv.Turn
+	}
+	return turns
+}
+
+func TestNextWays(t *testing.T) {
+	tests := []struct {
+		name         string
+		tags         map[osm.WayID]osm.Tags
+		restrictions []*TurnRestriction
+		want         map[osm.WayID]float64
+	}{
+		{"all ways", nil, nil, map[osm.WayID]float64{2: -90, 3: 0, 4: 90}},
+		{"oneway into the junction", map[osm.WayID]osm.Tags{2: {{Key: "oneway", Value: "-1"}}}, nil,
+			map[osm.WayID]float64{3: 0, 4: 90}},
+		{"roundabout out of it", map[osm.WayID]osm.Tags{3: {{Key: "junction", Value: "roundabout"}}}, nil,
+			map[osm.WayID]float64{2: -90, 3: 0, 4: 90}},
+		{"no right turn", nil, []*TurnRestriction{{Type: "no_right_turn", From: 5, Via: 1, To: 4}},
+			map[osm.WayID]float64{2: -90, 3: 0}},
+		{"only straight on", nil, []*TurnRestriction{{Type: "only_straight_on", From: 5, Via: 1, To: 3}},
+			map[osm.WayID]float64{3: 0}},
+		{"restriction from another way", nil, []*TurnRestriction{{Type: "no_left_turn", From: 4, Via: 1, To: 3}},
+			map[osm.WayID]float64{2: -90, 3: 0, 4: 90}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			em, arms := crossroads(tt.tags, tt.restrictions...)
+			moves := em.NextWays(arms["west"], true)
+			got := moveTurns(moves)
+
+			if len(moves) != len(tt.want) {
+				t.Fatalf("got %v want %v", got, tt.want)
+			}
+			for id, turn := range tt.want {
+				if math.Abs(got[id]-turn) > 0.5 {
+					t.Errorf("way %d: turn %.2f want %v", id, got[id], turn)
+				}
+			}
+			for _, mv := range moves {
+				if !mv.Forward || mv.NodeIdx != 0 {
+					t.Errorf("way %d leaves from node %d forward %v", mv.Way.ID, mv.NodeIdx, mv.Forward)
+				}
+			}
+		})
+	}
+
+	t.Run("dead end", func(t *testing.T) {
+		em, arms := crossroads(nil)
+		if moves := em.NextWays(arms["west"], false); len(moves) != 0 {
+			t.Errorf("got %v", moveTurns(moves))
+		}
+	})
+}
+
+func TestPreviousWays(t *testing.T) {
+	em, arms := crossroads(map[osm.WayID]osm.Tags{4: {{Key: "oneway", Value: "yes"}}},
+		&TurnRestriction{Type: "no_left_turn", From: 2, Via: 1, To: 3})
+
+	// into the east arm from the west, straight on, but not from the north,
+	// a left turn, nor against the south oneway
+	moves := em.PreviousWays(arms["east"], true)
+	if len(moves) != 1 || moves[0].Way.ID != 5 || !moves[0].Forward || math.Abs(moves[0].Turn) > 0.5 {
+		t.Fatalf("got %v", moveTurns(moves))
+	}
+
+	// from the east back towards the centre, reaching the west end
+	moves = em.PreviousWays(arms["west"], false)
+	if got := moveTurns(moves); len(got) != 2 || math.Abs(got[2]-90) > 0.5 || math.Abs(got[3]) > 0.5 {
+		t.Errorf("got %v", got)
+	}
+}
+
+func TestWalkOneway(t *testing.T) {
+	em, arms := crossroads(map[osm.WayID]osm.Tags{2: {{Key: "oneway", Value: "-1"}}})
+
+	// straight on from the south would be north, against the oneway
+	pos, ok := em.Walk(WayPosition{Way: arms["south"], Offset: 100, Forward: false}, 150, nil)
+	if !ok || pos.Way.ID == 2 || pos.Way.ID == 4 {
+		t.Errorf("walked onto way %d", pos.Way.ID)
+	}
+}
+
+func TestExtractRestrictions(t *testing.T) {
+	// two streets crossing at node 1, both cut there into two pieces
+	ways := []*osm.Way{
+		{ID: 100, Nodes: osm.WayNodes{{ID: 5}, {ID: 1}, {ID: 3}}},
+		{ID: 200, Nodes: osm.WayNodes{{ID: 2}, {ID: 1}, {ID: 4}}},
+	}
+	split, origin := splitAtIntersections(ways)
+	if len(split) != 4 {
+		t.Fatalf("split into %d ways", len(split))
+	}
+
+	relations := []*osm.Relation{
+		{ID: 7, Tags: osm.Tags{{Key: "type", Value: "restriction"}, {Key: "restriction", Value: "no_left_turn"}},
+			Members: osm.Members{
+				{Type: osm.TypeWay, Ref: 100, Role: "from"},
+				{Type: osm.TypeNode, Ref: 1, Role: "via"},
+				{Type: osm.TypeWay, Ref: 200, Role: "to"},
+			}},
+		// through a way, not kept
+		{ID: 8, Tags: osm.Tags{{Key: "type", Value: "restriction"}, {Key: "restriction", Value: "no_u_turn"}},
+			Members: osm.Members{
+				{Type: osm.TypeWay, Ref: 100, Role: "from"},
+				{Type: osm.TypeWay, Ref: 200, Role: "via"},
+				{Type: osm.TypeWay, Ref: 100, Role: "to"},
+			}},
+	}
+
+	restrictions := extractRestrictions(relations, split, origin)
+	if len(restrictions) != 4 {
+		t.Fatalf("got %d restrictions", len(restrictions))
+	}
+	for _, r := range restrictions {
+		if r.ID != 7 || r.Via != 1 || origin[r.From] != 100 || origin[r.To] != 200 || r.Only() {
+			t.Errorf("got %+v", r)
+		}
+	}
+}
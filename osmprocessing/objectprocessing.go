@@ -15,8 +15,11 @@ import (
 )
 
 type Map struct {
-	Ways  []*osm.Way
-	Nodes map[osm.NodeID]*osm.Node
+	Ways         []*osm.Way
+	Nodes        map[osm.NodeID]*osm.Node
+	Landmarks    []*Landmark
+	Buildings    []*Building
+	Restrictions []*TurnRestriction
 }
 
 var drivableHighways = map[string]bool{
@@ -37,41 +40,21 @@ var drivableHighways = map[string]bool{
 	"track":          true,
 }
 
+// CalculateDestinationPoint is DestinationPoint on typed coordinates. The
+// result keeps full float64 precision, use Rounded to display it.
 func CalculateDestinationPoint(latOrgn, longOrgn CoordinateDecimal, bearing BearingDecimal,
 	distance float64) (latDest, longDest CoordinateDecimal) {
 
-	latOrgRad := float64(latOrgn.DecimalDegree) * float64(math.Pi) / 180.0
-	longOrgRad := float64(longOrgn.DecimalDegree) * float64(math.Pi) / 180.0
-	bearingRad := float64(bearing) * float64(math.Pi) / 180.0
+	lat, lon := DestinationPoint(latOrgn.DecimalDegree, longOrgn.DecimalDegree, float64(bearing), distance)
 
-	// φ2 = asin( sin φ1 ⋅ cos δ + cos φ1 ⋅ sin δ ⋅ cos θ )
-	// λ2 = λ1 + atan2( sin θ ⋅ sin δ ⋅ cos φ1, cos δ − sin φ1 ⋅ sin φ2 )
-	latDestRad := math.Asin(math.Sin(latOrgRad)*math.Cos(distance/R) + math.Cos(latOrgRad)*
-		math.Sin(distance/R)*math.Cos(float64(bearingRad)))
-
-	longDestRad := longOrgRad + math.Atan2(math.Sin(float64(bearingRad))*math.Sin(distance/R)*math.Cos(latOrgRad),
-		math.Cos(distance/R)-math.Sin(latOrgRad)*math.Sin(latDestRad))
-
-	latDestDeg := latDestRad * 180 / float64(math.Pi)
-	longDestDeg := longDestRad * 180 / float64(math.Pi)
-
-	latDestDeg = math.Round(latDestDeg*1e4) / 1e4
-	longDestDeg = math.Round(longDestDeg*1e4) / 1e4
-
-	latDir := North
-	if latDestDeg < 0 {
-		latDir = South
-	}
-
-	longDir := East
-	if longDestDeg < 0 {
-		longDir = West
-	}
-
-	lat := MakeCoordinateDecimal(latDestDeg, latDir)
-	long := MakeCoordinateDecimal(longDestDeg, longDir)
+	return MakeCoordinateDecimal(lat, Latitude), MakeCoordinateDecimal(lon, Longitude)
+}
 
-	return lat, long
+// DestinationPoint returns the point reached from lat, lon after travelling
+// distance metres at the given bearing, using GeodesicBackend().
+func DestinationPoint(lat, lon, bearing, distance float64) (float64, float64) {
+	lat2, lon2, _ := GeodesicBackend().Direct(lat, lon, bearing, distance)
+	return lat2, lon2
 }
 
 func DistanceTo(n *osm.Node, lat2, long2 CoordinateDecimal) float64 {
@@ -223,17 +206,51 @@ func SaveMapToOSM(fmap *Map, fname string) error {
 }
 
 func ExtractObjects(fname string, save bool) *Map {
+	open := func(waysOnly bool) (osm.Scanner, error) {
+		f, err := os.Open(fname)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open %q %w", fname, err)
+		}
+		scanner := osmpbf.New(context.Background(), f, 4)
+		scanner.SkipNodes, scanner.SkipRelations = waysOnly, waysOnly
+		return &fileScanner{Scanner: scanner, file: f}, nil
+	}
 
-	f, err := os.Open(fname)
+	m, err := extractObjects(open)
 	if err != nil {
-		panic(err)
+		log.Fatal(err)
 	}
-	defer f.Close()
+	return m
+}
+
+// fileScanner closes the file under the scanner with it.
+type fileScanner struct {
+	osm.Scanner
+	file *os.File
+}
+
+func (s *fileScanner) Close() error {
+	s.Scanner.Close()
+	return s.file.Close()
+}
+
+// extractObjects reads the map from the scanners open returns, a second
+// one, which may skip nodes and relations, for the members of building
+// multipolygons. Those are usually untagged ways and come before the
+// relations naming them.
+func extractObjects(open func(waysOnly bool) (osm.Scanner, error)) (*Map, error) {
+	scanner, err := open(false)
+	if err != nil {
+		return nil, err
+	}
+	defer scanner.Close()
 
 	nodes := make(map[osm.NodeID]*osm.Node)
 	ways := []*osm.Way{}
-
-	scanner := osmpbf.New(context.Background(), f, 4)
+	landmarks := []*Landmark{}
+	buildings := []*Building{}
+	buildingRelations := []*osm.Relation{}
+	restrictionRelations := []*osm.Relation{}
 
 	for scanner.Scan() {
 		obj := scanner.Object()
@@ -242,12 +259,18 @@ func ExtractObjects(fname string, save bool) *Map {
 
 		case *osm.Node:
 			nodes[o.ID] = o
+			if lm, ok := extractLandmark(o); ok {
+				landmarks = append(landmarks, lm)
+			}
 
 		case *osm.Way:
 			if o.Tags == nil {
 				continue
 			}
 			if o.Tags.HasTag("building") {
+				if b, ok := extractBuildingWay(o, nodes); ok {
+					buildings = append(buildings, b)
+				}
 				continue
 			}
 			if ok := o.Tags.HasTag("highway"); ok {
@@ -256,14 +279,54 @@ func ExtractObjects(fname string, save bool) *Map {
 					ways = append(ways, o)
 				}
 			}
+
+		case *osm.Relation:
+			if o.Tags.Find("type") == "multipolygon" && o.Tags.HasTag("building") {
+				buildingRelations = append(buildingRelations, o)
+			}
+			if o.Tags.Find("type") == "restriction" {
+				restrictionRelations = append(restrictionRelations, o)
+			}
 		}
 	}
 
 	if err := scanner.Err(); err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("failed to scan objects %w", err)
 	}
 
-	splitWays := splitAtIntersections(ways)
+	// node lists of the member ways of building multipolygons
+	wayNodes := make(map[osm.WayID][]osm.NodeID)
+	for _, r := range buildingRelations {
+		for _, member := range r.Members {
+			if member.Type == osm.TypeWay {
+				wayNodes[osm.WayID(member.Ref)] = nil
+			}
+		}
+	}
+	if len(wayNodes) > 0 {
+		members, err := open(true)
+		if err != nil {
+			return nil, err
+		}
+		defer members.Close()
+
+		for members.Scan() {
+			if w, ok := members.Object().(*osm.Way); ok {
+				if _, member := wayNodes[w.ID]; member {
+					wayNodes[w.ID] = w.Nodes.NodeIDs()
+				}
+			}
+		}
+		if err := members.Err(); err != nil {
+			return nil, fmt.Errorf("failed to scan building members %w", err)
+		}
+	}
+
+	for _, r := range buildingRelations {
+		buildings = append(buildings, extractBuildingRelation(r, wayNodes, nodes)...)
+	}
+
+	splitWays, origin := splitAtIntersections(ways)
 
 	usedNodes := make(map[osm.NodeID]bool)
 
@@ -281,10 +344,14 @@ func ExtractObjects(fname string, save bool) *Map {
 	}
 
 	out := Map{
-		Ways:  splitWays,
-		Nodes: filteredNodes,
+		Ways:      splitWays,
+		Nodes:     filteredNodes,
+		Landmarks: landmarks,
+		Buildings: buildings,
+
+		Restrictions: extractRestrictions(restrictionRelations, splitWays, origin),
 	}
-	return &out
+	return &out, nil
 }
 
 func (out *Map) SaveObjects(fname string) (string, error) {
@@ -316,7 +383,9 @@ func (m *Map) LoadObjects(fname string) error {
 	return nil
 }
 
-func splitAtIntersections(ways []*osm.Way) []*osm.Way {
+// splitAtIntersections cuts the ways at every node they share and numbers
+// the pieces from 1. origin maps each piece to the way it was cut from.
+func splitAtIntersections(ways []*osm.Way) (out []*osm.Way, origin map[osm.WayID]osm.WayID) {
 
 	count := make(map[osm.NodeID]int)
 	for _, w := range ways {
@@ -325,7 +394,7 @@ func splitAtIntersections(ways []*osm.Way) []*osm.Way {
 		}
 	}
 
-	var out []*osm.Way
+	origin = make(map[osm.WayID]osm.WayID)
 	var nextID int64 = 1
 
 	for _, w := range ways {
@@ -335,6 +404,7 @@ func splitAtIntersections(ways []*osm.Way) []*osm.Way {
 			Tags:  append(osm.Tags(nil), w.Tags...),
 			Nodes: make([]osm.WayNode, 0, len(w.Nodes)),
 		}
+		origin[current.ID] = w.ID
 		nextID++
 
 		for _, n := range w.Nodes {
@@ -354,6 +424,7 @@ func splitAtIntersections(ways []*osm.Way) []*osm.Way {
 					Tags:  append(osm.Tags(nil), w.Tags...),
 					Nodes: []osm.WayNode{n},
 				}
+				origin[current.ID] = w.ID
 				nextID++
 			}
 		}
@@ -368,7 +439,7 @@ func splitAtIntersections(ways []*osm.Way) []*osm.Way {
 		}
 	}
 
-	return out
+	return out, origin
 }
 
 // func GenerateAllWays(m *Map, grid map[string]osm.NodeID, rows, cols int) []*osm.Way {
@@ -23,11 +23,15 @@ func TestDestinationPointFromSrcGivenLatLongBearing(t *testing.T) {
 		LatDest  CoordinateDecimal
 		LongDest CoordinateDecimal
 	}{
+		// destinations to the hundredth of a second, now that they are no
+		// longer rounded to the whole second
 		{ToDecimalCoord(53, 19, 14, North), ToDecimalCoord(1, 43, 47, West),
-			ToDecimalBearing(96, 1, 18), 124.8e3, ToDecimalCoord(53, 11, 18, North), ToDecimalCoord(0, 8, 0, East)},
+			ToDecimalBearing(96, 1, 18), 124.8e3,
+			Coordinate{53, 11, 17.77, North}.ToDecimalCoord(), Coordinate{0, 7, 59.80, East}.ToDecimalCoord()},
 
 		{ToDecimalCoord(52, 19, 14, North), ToDecimalCoord(100, 43, 47, West),
-			ToDecimalBearing(31, 1, 18), 93.8e3, ToDecimalCoord(53, 2, 29, North), ToDecimalCoord(100, 0, 24, West)},
+			ToDecimalBearing(31, 1, 18), 93.8e3,
+			Coordinate{53, 2, 28.64, North}.ToDecimalCoord(), Coordinate{100, 0, 23.96, West}.ToDecimalCoord()},
 	}
 
 	for _, c := range cases {
@@ -40,6 +44,58 @@ func TestDestinationPointFromSrcGivenLatLongBearing(t *testing.T) {
 	}
 }
 
+func TestDestinationPointFullPrecision(t *testing.T) {
+	lat, lon := 44.8378, -0.5792
+
+	t.Run("no lattice", func(t *testing.T) {
+		for _, distance := range []float64{0.001, 0.01, 0.5, 3, 1234.5678} {
+			lat2, lon2 := DestinationPoint(lat, lon, 57, distance)
+			if got := HaversineDistance(lat, lon, lat2, lon2); math.Abs(got-distance) > 1e-4 {
+				t.Errorf("moved %.7fm, want %v", got, distance)
+			}
+		}
+	})
+
+	t.Run("repeated small steps", func(t *testing.T) {
+		curLat, curLon := lat, lon
+		for i := 0; i < 1000; i++ {
+			curLat, curLon = DestinationPoint(curLat, curLon, 0, 0.25)
+		}
+		if got := HaversineDistance(lat, lon, curLat, curLon); math.Abs(got-250) > 1e-4 {
+			t.Errorf("1000 steps of 25cm moved %.7fm", got)
+		}
+	})
+
+	t.Run("typed coordinates", func(t *testing.T) {
+		gotLat, gotLon := CalculateDestinationPoint(MakeCoordinateDecimal(lat, Latitude), MakeCoordinateDecimal(lon, Longitude), 250, 5000)
+		if gotLat.CoordType != Latitude || gotLon.CoordType != Longitude {
+			t.Fatalf("got types %d,%d", gotLat.CoordType, gotLon.CoordType)
+		}
+		wantLat, wantLon := DestinationPoint(lat, lon, 250, 5000)
+		if gotLat.DecimalDegree != wantLat || gotLon.DecimalDegree != wantLon {
+			t.Errorf("got %v,%v want %v,%v", gotLat.DecimalDegree, gotLon.DecimalDegree, wantLat, wantLon)
+		}
+	})
+
+	t.Run("ellipsoidal default", func(t *testing.T) {
+		SetGeodesicBackend(NewKarneyGeodesy(WGS84))
+		defer SetGeodesicBackend(SphericalGeodesy{})
+
+		lat2, lon2 := DestinationPoint(lat, lon, 123, 0.5)
+		distance, azi1, _ := NewKarneyGeodesy(WGS84).Inverse(lat, lon, lat2, lon2)
+		if math.Abs(distance-0.5) > 1e-6 || BearingDifference(azi1, 123) > 1e-3 {
+			t.Errorf("got %.9fm at %.6f", distance, azi1)
+		}
+	})
+}
+
+func TestRounded(t *testing.T) {
+	c := MakeCoordinateDecimal(-0.57923456, Longitude).Rounded(4)
+	if c.DecimalDegree != -0.5792 || c.CoordType != Longitude {
+		t.Errorf("got %v", c)
+	}
+}
+
 func TestDistanceTo(t *testing.T) {
 	distanceWant := 5000.0
 	m, grid := GenerateMap(1, 2, distanceWant, ToDecimalCoord(52, 19, 14, North), ToDecimalCoord(100, 43, 47, West))
@@ -56,7 +112,7 @@ func TestDistanceTo(t *testing.T) {
 }
 
 func coordsEqual(a, b CoordinateDecimal) bool {
-	return math.Abs(a.DecimalDegree-b.DecimalDegree) < 0.0001
+	return a.CoordType == b.CoordType && math.Abs(a.DecimalDegree-b.DecimalDegree) < 0.0001
 }
 
 func TestGenerateMap_Simple(t *testing.T) {
@@ -0,0 +1,270 @@
+package osmprocessing
+
+import (
+	"math"
+)
+
+type Ellipsoid struct {
+	A float64 // semi-major axis in metres
+	F float64 // flattening
+}
+
+var WGS84 = Ellipsoid{A: 6378137.0, F: 1 / 298.257223563}
+
+func (e Ellipsoid) B() float64 {
+	return e.A * (1 - e.F)
+}
+
+// E2 is the first eccentricity squared.
+func (e Ellipsoid) E2() float64 {
+	return e.F * (2 - e.F)
+}
+
+// N is the prime vertical radius of curvature at latitude phi (radians).
+func (e Ellipsoid) N(phi float64) float64 {
+	s := math.Sin(phi)
+	return e.A / math.Sqrt(1-e.E2()*s*s)
+}
+
+// M is the meridional radius of curvature at latitude phi (radians).
+func (e Ellipsoid) M(phi float64) float64 {
+	s := math.Sin(phi)
+	return e.A * (1 - e.E2()) / math.Pow(1-e.E2()*s*s, 1.5)
+}
+
+// MetersPerDegree returns the length of one degree of latitude and of
+// longitude at the given latitude on the WGS84 ellipsoid.
+func MetersPerDegree(lat float64) (latMeters, lonMeters float64) {
+	phi := DegToRad(lat)
+	return WGS84.M(phi) * math.Pi / 180, WGS84.N(phi) * math.Cos(phi) * math.Pi / 180
+}
+
+// Projection converts between WGS84 degrees and planar metres. X grows to
+// the east and Y to the north.
+type Projection interface {
+	Forward(lat, lon float64) (x, y float64)
+	Inverse(x, y float64) (lat, lon float64)
+}
+
+func geodeticToECEF(e Ellipsoid, lat, lon, h float64) (x, y, z float64) {
+	phi, lambda := DegToRad(lat), DegToRad(lon)
+	n := e.N(phi)
+	x = (n + h) * math.Cos(phi) * math.Cos(lambda)
+	y = (n + h) * math.Cos(phi) * math.Sin(lambda)
+	z = (n*(1-e.E2()) + h) * math.Sin(phi)
+	return x, y, z
+}
+
+func ecefToGeodetic(e Ellipsoid, x, y, z float64) (lat, lon, h float64) {
+	e2 := e.E2()
+	p := math.Hypot(x, y)
+	lambda := math.Atan2(y, x)
+	phi := math.Atan2(z, p*(1-e2))
+
+	for i := 0; i < 10; i++ {
+		n := e.N(phi)
+		if math.Abs(phi) < math.Pi/4 {
+			h = p/math.Cos(phi) - n
+		} else {
+			h = z/math.Sin(phi) - n*(1-e2)
+		}
+		next := math.Atan2(z, p*(1-e2*n/(n+h)))
+		if math.Abs(next-phi) < 1e-14 {
+			phi = next
+			break
+		}
+		phi = next
+	}
+
+	return phi * 180 / math.Pi, lambda * 180 / math.Pi, h
+}
+
+// LocalENU is the East-North-Up tangent plane touching the WGS84 ellipsoid
+// at an anchor point. Distances are true within a few mm over the extent of
+// a city, whatever the latitude.
+type LocalENU struct {
+	Lat0, Lon0 float64
+
+	x0, y0, z0                     float64
+	sinLat, cosLat, sinLon, cosLon float64
+}
+
+func NewLocalENU(lat0, lon0 float64) *LocalENU {
+	phi, lambda := DegToRad(lat0), DegToRad(lon0)
+	x0, y0, z0 := geodeticToECEF(WGS84, lat0, lon0, 0)
+
+	return &LocalENU{
+		Lat0: lat0, Lon0: lon0,
+		x0: x0, y0: y0, z0: z0,
+		sinLat: math.Sin(phi), cosLat: math.Cos(phi),
+		sinLon: math.Sin(lambda), cosLon: math.Cos(lambda),
+	}
+}
+
+func (p *LocalENU) Forward(lat, lon float64) (x, y float64) {
+	east, north, _ := p.forward3(lat, lon)
+	return east, north
+}
+
+func (p *LocalENU) forward3(lat, lon float64) (east, north, up float64) {
+	x, y, z := geodeticToECEF(WGS84, lat, lon, 0)
+	dx, dy, dz := x-p.x0, y-p.y0, z-p.z0
+
+	east = -p.sinLon*dx + p.cosLon*dy
+	north = -p.sinLat*p.cosLon*dx - p.sinLat*p.sinLon*dy + p.cosLat*dz
+	up = p.cosLat*p.cosLon*dx + p.cosLat*p.sinLon*dy + p.sinLat*dz
+	return east, north, up
+}
+
+// Inverse finds the point on the ellipsoid whose tangent plane projection
+// is (x, y), dropping the plane's height along the up axis until it meets
+// the surface.
+func (p *LocalENU) Inverse(x, y float64) (lat, lon float64) {
+	up := 0.0
+
+	for i := 0; i < 10; i++ {
+		ex := p.x0 - p.sinLon*x - p.sinLat*p.cosLon*y + p.cosLat*p.cosLon*up
+		ey := p.y0 + p.cosLon*x - p.sinLat*p.sinLon*y + p.cosLat*p.sinLon*up
+		ez := p.z0 + p.cosLat*y + p.sinLat*up
+
+		var h float64
+		lat, lon, h = ecefToGeodetic(WGS84, ex, ey, ez)
+		if math.Abs(h) < 1e-6 {
+			break
+		}
+		up -= h
+	}
+
+	return lat, lon
+}
+
+// TransverseMercator uses the Krüger series to sixth order in n, see
+// C. F. F. Karney, "Transverse Mercator with an accuracy of a few
+// nanometers", J. Geodesy 85(8), 475-485 (2011).
+type TransverseMercator struct {
+	Ellipsoid     Ellipsoid
+	Lat0, Lon0    float64 // origin in degrees
+	K0            float64 // scale on the central meridian
+	FalseEasting  float64
+	FalseNorthing float64
+
+	a         float64 // rectifying radius
+	e         float64
+	alpha     [7]float64
+	beta      [7]float64
+	northing0 float64
+}
+
+func NewTransverseMercator(e Ellipsoid, lat0, lon0, k0, falseEasting, falseNorthing float64) *TransverseMercator {
+	n := e.F / (2 - e.F)
+	n2, n3, n4, n5, n6 := n*n, n*n*n, n*n*n*n, n*n*n*n*n, n*n*n*n*n*n
+
+	tm := &TransverseMercator{
+		Ellipsoid: e, Lat0: lat0, Lon0: lon0, K0: k0,
+		FalseEasting: falseEasting, FalseNorthing: falseNorthing,
+		a: e.A / (1 + n) * (1 + n2/4 + n4/64 + n6/256),
+		e: math.Sqrt(e.E2()),
+	}
+
+	tm.alpha = [7]float64{0,
+		1.0/2*n - 2.0/3*n2 + 5.0/16*n3 + 41.0/180*n4 - 127.0/288*n5 + 7891.0/37800*n6,
+		13.0/48*n2 - 3.0/5*n3 + 557.0/1440*n4 + 281.0/630*n5 - 1983433.0/1935360*n6,
+		61.0/240*n3 - 103.0/140*n4 + 15061.0/26880*n5 + 167603.0/181440*n6,
+		49561.0/161280*n4 - 179.0/168*n5 + 6601661.0/7257600*n6,
+		34729.0/80640*n5 - 3418889.0/1995840*n6,
+		212378941.0 / 319334400 * n6,
+	}
+	tm.beta = [7]float64{0,
+		1.0/2*n - 2.0/3*n2 + 37.0/96*n3 - 1.0/360*n4 - 81.0/512*n5 + 96199.0/604800*n6,
+		1.0/48*n2 + 1.0/15*n3 - 437.0/1440*n4 + 46.0/105*n5 - 1118711.0/3870720*n6,
+		17.0/480*n3 - 37.0/840*n4 - 209.0/4480*n5 + 5569.0/90720*n6,
+		4397.0/161280*n4 - 11.0/504*n5 - 830251.0/7257600*n6,
+		4583.0/161280*n5 - 108847.0/3991680*n6,
+		20648693.0 / 638668800 * n6,
+	}
+
+	if lat0 != 0 {
+		_, tm.northing0 = tm.project(lat0, lon0)
+	}
+
+	return tm
+}
+
+// project returns easting and northing before the false origin is applied.
+func (tm *TransverseMercator) project(lat, lon float64) (x, y float64) {
+	phi := DegToRad(lat)
+	lambda := DegToRad(lon - tm.Lon0)
+	lambda = math.Remainder(lambda, 2*math.Pi)
+
+	tau := math.Tan(phi)
+	sigma := math.Sinh(tm.e * math.Atanh(tm.e*tau/math.Sqrt(1+tau*tau)))
+	tauPrime := tau*math.Sqrt(1+sigma*sigma) - sigma*math.Sqrt(1+tau*tau)
+
+	xiPrime := math.Atan2(tauPrime, math.Cos(lambda))
+	etaPrime := math.Asinh(math.Sin(lambda) / math.Sqrt(tauPrime*tauPrime+math.Cos(lambda)*math.Cos(lambda)))
+
+	xi, eta := xiPrime, etaPrime
+	for j := 1; j <= 6; j++ {
+		xi += tm.alpha[j] * math.Sin(2*float64(j)*xiPrime) * math.Cosh(2*float64(j)*etaPrime)
+		eta += tm.alpha[j] * math.Cos(2*float64(j)*xiPrime) * math.Sinh(2*float64(j)*etaPrime)
+	}
+
+	return tm.K0 * tm.a * eta, tm.K0 * tm.a * xi
+}
+
+func (tm *TransverseMercator) Forward(lat, lon float64) (x, y float64) {
+	x, y = tm.project(lat, lon)
+	return x + tm.FalseEasting, y - tm.northing0 + tm.FalseNorthing
+}
+
+func (tm *TransverseMercator) Inverse(x, y float64) (lat, lon float64) {
+	eta := (x - tm.FalseEasting) / (tm.K0 * tm.a)
+	xi := (y - tm.FalseNorthing + tm.northing0) / (tm.K0 * tm.a)
+
+	xiPrime, etaPrime := xi, eta
+	for j := 1; j <= 6; j++ {
+		xiPrime -= tm.beta[j] * math.Sin(2*float64(j)*xi) * math.Cosh(2*float64(j)*eta)
+		etaPrime -= tm.beta[j] * math.Cos(2*float64(j)*xi) * math.Sinh(2*float64(j)*eta)
+	}
+
+	sinhEtaPrime := math.Sinh(etaPrime)
+	sinXiPrime, cosXiPrime := math.Sin(xiPrime), math.Cos(xiPrime)
+
+	tauPrime := sinXiPrime / math.Sqrt(sinhEtaPrime*sinhEtaPrime+cosXiPrime*cosXiPrime)
+
+	e2 := tm.Ellipsoid.E2()
+	tau := tauPrime
+	for i := 0; i < 10; i++ {
+		sigma := math.Sinh(tm.e * math.Atanh(tm.e*tau/math.Sqrt(1+tau*tau)))
+		tauI := tau*math.Sqrt(1+sigma*sigma) - sigma*math.Sqrt(1+tau*tau)
+		delta := (tauPrime - tauI) / math.Sqrt(1+tauI*tauI) *
+			(1 + (1-e2)*tau*tau) / ((1 - e2) * math.Sqrt(1+tau*tau))
+		tau += delta
+		if math.Abs(delta) < 1e-12 {
+			break
+		}
+	}
+
+	lat = math.Atan(tau) * 180 / math.Pi
+	lon = tm.Lon0 + math.Atan2(sinhEtaPrime, cosXiPrime)*180/math.Pi
+	return lat, normalizeLon(lon)
+}
+
+// UTMZone returns the standard 6 degree zone number for a position,
+// ignoring the Norway and Svalbard exceptions.
+func UTMZone(lat, lon float64) int {
+	return int(math.Floor((normalizeLon(lon)+180)/6))%60 + 1
+}
+
+func NewUTM(zone int, north bool) *TransverseMercator {
+	falseNorthing := 0.0
+	if !north {
+		falseNorthing = 10000000
+	}
+	lon0 := float64(zone-1)*6 - 180 + 3
+	return NewTransverseMercator(WGS84, 0, lon0, 0.9996, 500000, falseNorthing)
+}
+
+func NewUTMForPoint(lat, lon float64) *TransverseMercator {
+	return NewUTM(UTMZone(lat, lon), lat >= 0)
+}
@@ -0,0 +1,119 @@
+package osmprocessing
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestLocalENU(t *testing.T) {
+	anchors := []struct {
+		name     string
+		lat, lon float64
+	}{
+		{"equator", 0, 0},
+		{"bordeaux", 44.8378, -0.5792},
+		{"tromso", 69.6496, 18.9560},
+		{"southern hemisphere", -41.2865, 174.7762},
+		{"antimeridian", 65.0, 179.999},
+	}
+
+	for _, a := range anchors {
+		t.Run(a.name, func(t *testing.T) {
+			frame := NewLocalENU(a.lat, a.lon)
+
+			x, y := frame.Forward(a.lat, a.lon)
+			if math.Hypot(x, y) > 1e-6 {
+				t.Errorf("anchor projects to %.9f,%.9f, want origin", x, y)
+			}
+
+			for _, offset := range [][2]float64{{1000, 0}, {0, 1000}, {-2500, 3000}} {
+				lat, lon := frame.Inverse(offset[0], offset[1])
+
+				gotX, gotY := frame.Forward(lat, lon)
+				if math.Hypot(gotX-offset[0], gotY-offset[1]) > 1e-6 {
+					t.Errorf("round trip of %v gave %.9f,%.9f", offset, gotX, gotY)
+				}
+
+				dist := HaversineDistance(a.lat, a.lon, lat, lon)
+				// the spherical haversine is off by up to 0.6% against the ellipsoid
+				assertWithinPercent(t, dist, math.Hypot(offset[0], offset[1]), 0.7)
+			}
+		})
+	}
+}
+
+func TestMetersPerDegree(t *testing.T) {
+	latMeters, lonMeters := MetersPerDegree(0)
+	assertWithinPercent(t, latMeters, 110574, 0.01)
+	assertWithinPercent(t, lonMeters, 111320, 0.01)
+
+	latMeters, lonMeters = MetersPerDegree(60)
+	assertWithinPercent(t, latMeters, 111412, 0.01)
+	assertWithinPercent(t, lonMeters, 55800, 0.1)
+}
+
+func TestProjectOnSegmentAgreesWithENU(t *testing.T) {
+	rng := rand.New(rand.NewSource(29))
+
+	// the distance to the segment on the tangent plane at the point
+	planar := func(plat, plon, aLat, aLon, bLat, bLon float64) float64 {
+		frame := NewLocalENU(plat, plon)
+		ax, ay := frame.Forward(aLat, aLon)
+		bx, by := frame.Forward(bLat, bLon)
+		dx, dy := bx-ax, by-ay
+		t := math.Max(0, math.Min(1, -(ax*dx+ay*dy)/(dx*dx+dy*dy)))
+		return math.Hypot(ax+t*dx, ay+t*dy)
+	}
+
+	for _, lat := range []float64{0, 44.84, -69.65} {
+		for i := 0; i < 1000; i++ {
+			plat, plon := DestinationPoint(lat, 18.96, rng.Float64()*360, rng.Float64()*20000)
+			aLat, aLon := DestinationPoint(plat, plon, rng.Float64()*360, rng.Float64()*200)
+			bLat, bLon := DestinationPoint(plat, plon, rng.Float64()*360, rng.Float64()*200)
+
+			_, got, _ := projectOnSegment(plat, plon, aLat, aLon, bLat, bLon)
+			if want := planar(plat, plon, aLat, aLon, bLat, bLon); math.Abs(got-want) > 0.01 {
+				t.Fatalf("%.6f,%.6f: %.4fm from the segment, %.4fm on the plane", plat, plon, got, want)
+			}
+		}
+	}
+}
+
+func TestUTM(t *testing.T) {
+	t.Run("zone", func(t *testing.T) {
+		if z := UTMZone(44.8378, -0.5792); z != 30 {
+			t.Errorf("bordeaux should be in zone 30, got %d", z)
+		}
+		if z := UTMZone(48.8582, 2.2945); z != 31 {
+			t.Errorf("paris should be in zone 31, got %d", z)
+		}
+	})
+
+	t.Run("central meridian is the scaled meridian arc", func(t *testing.T) {
+		// meridian arc from the equator to 45N is 4984944.378m on WGS84
+		x, y := NewUTM(31, true).Forward(45, 3)
+		if math.Abs(x-500000) > 1e-6 || math.Abs(y-0.9996*4984944.378) > 0.01 {
+			t.Errorf("got %.4f,%.4f", x, y)
+		}
+	})
+
+	t.Run("eiffel tower", func(t *testing.T) {
+		x, y := NewUTMForPoint(48.8582, 2.2945).Forward(48.8582, 2.2945)
+		if math.Abs(x-448251.8) > 0.1 || math.Abs(y-5411932.7) > 0.1 {
+			t.Errorf("got %.2f,%.2f want 448251.8,5411932.7", x, y)
+		}
+	})
+
+	t.Run("southern hemisphere round trip", func(t *testing.T) {
+		utm := NewUTMForPoint(-33.8688, 151.2093)
+		x, y := utm.Forward(-33.8688, 151.2093)
+		if y < 0 || y > 10000000 {
+			t.Errorf("northing %.2f outside the false origin range", y)
+		}
+		lat, lon := utm.Inverse(x, y)
+		if math.Abs(lat+33.8688) > 1e-9 || math.Abs(lon-151.2093) > 1e-9 {
+			t.Errorf("round trip gave %.10f,%.10f", lat, lon)
+		}
+	})
+}
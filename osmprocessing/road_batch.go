@@ -0,0 +1,283 @@
+package osmprocessing
+
+import (
+	"math"
+	"runtime"
+	"slices"
+	"sync"
+
+	"github.com/paulmach/osm"
+)
+
+// RoadMatch is the closest road to one position of a batch, as returned by
+// NearestRoad. Way is nil when OK is false, and where the likelihood field
+// answered without a search.
+type RoadMatch struct {
+	Way      *osm.Way
+	Distance float64
+	Bearing  float64
+	OK       bool
+}
+
+// RoadBatch answers NearestRoad for a whole set of positions at once, such
+// as every particle of a filter, through the map's way index. With the grid
+// positions are sorted by cell, so all those in a cell share one pick up of
+// the ways around it, and runs of cells are shared out between goroutines.
+// Another index is searched position by position, shared out the same way.
+// Buffers are kept from one call to the next, a RoadBatch is not safe for
+// concurrent use.
+type RoadBatch struct {
+	Workers int // goroutines, 0 uses GOMAXPROCS
+
+	em      *EnhancedMap
+	order   []int
+	cells   []GridCell
+	results []RoadMatch
+	scratch []*batchScratch
+}
+
+// roadBatchMinWork is the fewest positions worth a goroutine.
+const roadBatchMinWork = 256
+
+// batchSegment is a segment of a way around the cell being answered.
+type batchSegment struct {
+	way            *osm.Way
+	index          int
+	n1, n2         *osm.Node
+	x1, y1, x2, y2 float64 // on the index plane
+}
+
+// batchScratch is what one goroutine reuses from cell to cell.
+type batchScratch struct {
+	seen     map[osm.WayID]bool
+	buckets  [][]*osm.Way
+	segments []batchSegment
+	plane    []float64
+}
+
+func (em *EnhancedMap) NewRoadBatch() *RoadBatch {
+	return &RoadBatch{em: em}
+}
+
+// Query returns the closest road within maxDist of each position, in the
+// order of positions. The slice is reused by the next call.
+func (b *RoadBatch) Query(positions []LatLon, maxDist float64) []RoadMatch {
+	ways := b.em.wayIndex()
+	grid, _ := ways.(*gridWayIndex)
+
+	b.results = slices.Grow(b.results[:0], len(positions))[:len(positions)]
+	b.cells = slices.Grow(b.cells[:0], len(positions))[:len(positions)]
+	b.order = b.order[:0]
+	for i := range positions {
+		b.order = append(b.order, i)
+	}
+	if grid != nil {
+		for i, pos := range positions {
+			b.cells[i] = grid.index.getCell(pos.Lat, pos.Lon)
+		}
+		slices.SortFunc(b.order, func(i, j int) int {
+			if b.cells[i].LatIdx != b.cells[j].LatIdx {
+				return b.cells[i].LatIdx - b.cells[j].LatIdx
+			}
+			if b.cells[i].LonIdx != b.cells[j].LonIdx {
+				return b.cells[i].LonIdx - b.cells[j].LonIdx
+			}
+			return i - j
+		})
+	}
+
+	workers := b.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	workers = max(1, min(workers, len(positions)/roadBatchMinWork))
+	for len(b.scratch) < workers {
+		b.scratch = append(b.scratch, &batchScratch{seen: make(map[osm.WayID]bool)})
+	}
+
+	// each goroutine takes a share of the sorted positions, moved on to
+	// the end of a cell so no cell is picked up twice
+	var wg sync.WaitGroup
+	from := 0
+	for w := 0; w < workers; w++ {
+		to := len(b.order) * (w + 1) / workers
+		for grid != nil && to > from && to < len(b.order) && b.cells[b.order[to]] == b.cells[b.order[to-1]] {
+			to++
+		}
+		if to <= from {
+			continue
+		}
+
+		wg.Add(1)
+		go func(scratch *batchScratch, order []int) {
+			defer wg.Done()
+			if grid != nil {
+				b.queryRun(scratch, grid.index, positions, order, maxDist)
+			} else {
+				b.queryEach(ways, positions, order, maxDist)
+			}
+		}(b.scratch[w], b.order[from:to])
+		from = to
+	}
+	wg.Wait()
+
+	return b.results
+}
+
+// field is the likelihood field when it reaches maxDist, or nil.
+func (b *RoadBatch) field(maxDist float64) *LikelihoodField {
+	if field := b.em.LikelihoodField; field != nil && maxDist <= field.MaxDistance {
+		return field
+	}
+	return nil
+}
+
+// queryEach searches the way index for each position.
+func (b *RoadBatch) queryEach(ways WayIndex, positions []LatLon, order []int, maxDist float64) {
+	field := b.field(maxDist)
+	for _, i := range order {
+		pos := positions[i]
+
+		if field != nil {
+			distance, bearing, exact := field.Lookup(pos.Lat, pos.Lon)
+			if !exact {
+				b.results[i] = RoadMatch{Distance: distance, Bearing: bearing, OK: distance <= maxDist}
+				continue
+			}
+		}
+
+		b.results[i] = RoadMatch{}
+		if way, _ := ways.FindNearestWay(pos.Lat, pos.Lon, maxDist); way != nil {
+			proj, ok := ProjectOnWay(pos.Lat, pos.Lon, way, b.em.Nodes)
+			b.results[i] = RoadMatch{Way: way, Distance: proj.Distance, Bearing: proj.Bearing, OK: ok}
+		}
+	}
+}
+
+// queryRun answers positions sorted by cell of the grid index.
+func (b *RoadBatch) queryRun(scratch *batchScratch, index *SpatialIndex, positions []LatLon, order []int, maxDist float64) {
+	field := b.field(maxDist)
+
+	picked := false
+	for k, i := range order {
+		if k > 0 && b.cells[i] != b.cells[order[k-1]] {
+			picked = false
+		}
+		pos := positions[i]
+
+		if field != nil {
+			distance, bearing, exact := field.Lookup(pos.Lat, pos.Lon)
+			if !exact {
+				b.results[i] = RoadMatch{Distance: distance, Bearing: bearing, OK: distance <= maxDist}
+				continue
+			}
+		}
+
+		if !picked {
+			scratch.pick(index, b.em.Nodes, b.cells[i], maxDist)
+			picked = true
+		}
+		b.results[i] = scratch.nearest(index, pos, maxDist)
+	}
+}
+
+// nearest measures every segment on the plane first, and on the ground
+// only those that may be closest. The plane is a metre out at most over a
+// map, the same slack InsertWay allows. Ties go to the lowest way ID and
+// then the first segment, as with FindNearestWayFast and ProjectOnWay.
+func (s *batchScratch) nearest(index *SpatialIndex, pos LatLon, maxDist float64) RoadMatch {
+	x, y := index.projection.Forward(pos.Lat, pos.Lon)
+
+	s.plane = s.plane[:0]
+	closest := math.Inf(1)
+	for _, seg := range s.segments {
+		d := planeSegmentDistance(x, y, seg.x1, seg.y1, seg.x2, seg.y2)
+		s.plane = append(s.plane, d)
+		closest = math.Min(closest, d)
+	}
+
+	best := -1
+	bestDist := math.Inf(1)
+	for k, seg := range s.segments {
+		if s.plane[k] > math.Min(closest+1, maxDist+1) {
+			continue
+		}
+		_, dist, _ := projectOnSegment(pos.Lat, pos.Lon, seg.n1.Lat, seg.n1.Lon, seg.n2.Lat, seg.n2.Lon)
+		if dist > maxDist {
+			continue
+		}
+		if best < 0 || dist < bestDist || dist == bestDist && (seg.way.ID < s.segments[best].way.ID ||
+			seg.way.ID == s.segments[best].way.ID && seg.index < s.segments[best].index) {
+			best, bestDist = k, dist
+		}
+	}
+
+	if best < 0 {
+		return RoadMatch{}
+	}
+	seg := s.segments[best]
+	return RoadMatch{
+		Way:      seg.way,
+		Distance: bestDist,
+		Bearing:  CalculateBearing(seg.n1.Lat, seg.n1.Lon, seg.n2.Lat, seg.n2.Lon),
+		OK:       true,
+	}
+}
+
+// pick gathers the segments of the ways filed under cells within maxDist
+// of any point of the cell.
+func (s *batchScratch) pick(index *SpatialIndex, nodes map[osm.NodeID]*osm.Node, cell GridCell, maxDist float64) {
+	clear(s.seen)
+	s.buckets = s.buckets[:0]
+	s.segments = s.segments[:0]
+
+	cells := int(math.Ceil(maxDist/index.cellSize)) + 1
+
+	index.mu.RLock()
+	for dLat := -cells; dLat <= cells; dLat++ {
+		for dLon := -cells; dLon <= cells; dLon++ {
+			gapY := float64(max(0, dLat-1, -dLat-1)) * index.cellSize
+			gapX := float64(max(0, dLon-1, -dLon-1)) * index.cellSize
+			if math.Hypot(gapX, gapY) > maxDist {
+				continue
+			}
+			if bucket, ok := index.wayGrid[GridCell{LatIdx: cell.LatIdx + dLat, LonIdx: cell.LonIdx + dLon}]; ok {
+				s.buckets = append(s.buckets, bucket)
+			}
+		}
+	}
+	index.mu.RUnlock()
+
+	// segments further than maxDist and a metre from the cell can't be
+	// closest for any point in it
+	minX, minY := float64(cell.LonIdx)*index.cellSize, float64(cell.LatIdx)*index.cellSize
+	reach := index.cellSize*math.Sqrt2/2 + maxDist + 1
+	centerX, centerY := minX+index.cellSize/2, minY+index.cellSize/2
+
+	for _, bucket := range s.buckets {
+		for _, way := range bucket {
+			if s.seen[way.ID] {
+				continue
+			}
+			s.seen[way.ID] = true
+
+			var prev *osm.Node
+			var prevX, prevY float64
+			for i, wn := range way.Nodes {
+				node, ok := nodes[wn.ID]
+				if !ok {
+					prev = nil
+					continue
+				}
+				x, y := index.projection.Forward(node.Lat, node.Lon)
+				if prev != nil && planeSegmentDistance(centerX, centerY, prevX, prevY, x, y) <= reach {
+					s.segments = append(s.segments, batchSegment{
+						way: way, index: i - 1, n1: prev, n2: node,
+						x1: prevX, y1: prevY, x2: x, y2: y,
+					})
+				}
+				prev, prevX, prevY = node, x, y
+			}
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package osmprocessing
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func randomPositions(rng *rand.Rand, count int, lat0, lon0, span float64) []LatLon {
+	positions := make([]LatLon, count)
+	for i := range positions {
+		lat, lon := DestinationPoint(lat0, lon0, rng.Float64()*360, rng.Float64()*span)
+		positions[i] = LatLon{lat, lon}
+	}
+	return positions
+}
+
+func TestRoadBatchMatchesNearestRoad(t *testing.T) {
+	rng := rand.New(rand.NewSource(9))
+	m := randomWayMap(rng, 300, 44.84, -0.58, 2000)
+	positions := randomPositions(rng, 3000, 44.84, -0.58, 2200)
+
+	for _, run := range []struct {
+		index   IndexType
+		workers int
+	}{{GridIndex, 1}, {GridIndex, 4}, {RTreeIndex, 1}, {RTreeIndex, 4}} {
+		em := NewEnhancedMap(m, WithWayIndex(run.index))
+		workers := run.workers
+		batch := em.NewRoadBatch()
+		batch.Workers = workers
+
+		// a second, shorter call runs on the buffers of the first
+		for _, count := range []int{len(positions), 700} {
+			matches := batch.Query(positions[:count], 50)
+			if len(matches) != count {
+				t.Fatalf("got %d matches for %d positions", len(matches), count)
+			}
+
+			for i, pos := range positions[:count] {
+				way, _ := em.FindNearestWayFast(pos.Lat, pos.Lon, 50)
+				distance, bearing, ok := em.NearestRoad(pos.Lat, pos.Lon, 50)
+
+				got := matches[i]
+				if got.OK != ok {
+					t.Fatalf("%v, %d workers, %.6f,%.6f: got ok %v want %v", run.index, workers, pos.Lat, pos.Lon, got.OK, ok)
+				}
+				if !ok {
+					continue
+				}
+				if math.Abs(got.Distance-distance) > 1e-9 || got.Bearing != bearing && got.Way.ID != way.ID {
+					t.Fatalf("%v, %d workers, %.6f,%.6f: got way %d at %.6f want way %d at %.6f",
+						run.index, workers, pos.Lat, pos.Lon, got.Way.ID, got.Distance, way.ID, distance)
+				}
+			}
+		}
+	}
+}
+
+func TestRoadBatchLikelihoodField(t *testing.T) {
+	rng := rand.New(rand.NewSource(10))
+	em := NewEnhancedMap(randomWayMap(rng, 100, 44.84, -0.58, 1000))
+	em.BuildLikelihoodField(1, 30)
+	positions := randomPositions(rng, 2000, 44.84, -0.58, 1100)
+
+	batch := em.NewRoadBatch()
+	for _, maxDist := range []float64{20, 50} {
+		matches := batch.Query(positions, maxDist)
+		for i, pos := range positions {
+			distance, bearing, ok := em.NearestRoad(pos.Lat, pos.Lon, maxDist)
+			if got := matches[i]; got.OK != ok || ok && (got.Distance != distance || got.Bearing != bearing) {
+				t.Fatalf("within %v of %.6f,%.6f: got %+v want %.6f %.2f %v", maxDist, pos.Lat, pos.Lon, got, distance, bearing, ok)
+			}
+		}
+	}
+}
+
+func TestRoadBatchEmpty(t *testing.T) {
+	em := NewEnhancedMap(randomWayMap(rand.New(rand.NewSource(11)), 10, 44.84, -0.58, 500))
+	if matches := em.NewRoadBatch().Query(nil, 50); len(matches) != 0 {
+		t.Errorf("got %d matches", len(matches))
+	}
+}
+
+func BenchmarkRoadBatch(b *testing.B) {
+	rng := rand.New(rand.NewSource(12))
+	em := NewEnhancedMap(randomWayMap(rng, 5000, 44.84, -0.58, 4000))
+
+	// particles gather in clouds around a few hypotheses
+	var particles []LatLon
+	for c := 0; c < 10; c++ {
+		lat, lon := DestinationPoint(44.84, -0.58, rng.Float64()*360, rng.Float64()*3000)
+		particles = append(particles, randomPositions(rng, 1000, lat, lon, 100)...)
+	}
+
+	b.Run("one by one", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, p := range particles {
+				em.NearestRoad(p.Lat, p.Lon, 50)
+			}
+		}
+	})
+	b.Run("batch", func(b *testing.B) {
+		batch := em.NewRoadBatch()
+		for i := 0; i < b.N; i++ {
+			batch.Query(particles, 50)
+		}
+	})
+	b.Run("batch one goroutine", func(b *testing.B) {
+		batch := em.NewRoadBatch()
+		batch.Workers = 1
+		for i := 0; i < b.N; i++ {
+			batch.Query(particles, 50)
+		}
+	})
+}
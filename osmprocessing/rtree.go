@@ -0,0 +1,390 @@
+package osmprocessing
+
+import (
+	"container/heap"
+	"math"
+	"sort"
+
+	"github.com/paulmach/osm"
+)
+
+// WayIndex finds ways around a point. QueryWays may return ways further
+// than radius, callers that need exact distances measure them or use
+// WaysWithin.
+type WayIndex interface {
+	QueryWays(lat, lon, radius float64) []*osm.Way
+	FindNearestWay(lat, lon, maxDist float64) (*osm.Way, float64)
+	NearestWays(lat, lon float64, k int, maxDist float64) []WayMatch
+	WaysWithin(lat, lon, radius float64) []WayMatch
+}
+
+// gridWayIndex puts the grid SpatialIndex behind WayIndex.
+type gridWayIndex struct {
+	m     *Map
+	index *SpatialIndex
+}
+
+func NewGridWayIndex(m *Map, index *SpatialIndex) WayIndex {
+	return &gridWayIndex{m: m, index: index}
+}
+
+func (g *gridWayIndex) QueryWays(lat, lon, radius float64) []*osm.Way {
+	return g.index.QueryWays(lat, lon, radius)
+}
+
+func (g *gridWayIndex) FindNearestWay(lat, lon, maxDist float64) (*osm.Way, float64) {
+	return g.m.FindNearestWay(lat, lon, maxDist, g.index)
+}
+
+func (g *gridWayIndex) NearestWays(lat, lon float64, k int, maxDist float64) []WayMatch {
+	if k <= 0 {
+		return nil
+	}
+
+	candidates := g.m.Ways
+	if !math.IsInf(maxDist, 1) {
+		candidates = g.index.QueryWays(lat, lon, maxDist)
+	}
+
+	matches := matchWays(lat, lon, candidates, g.m.Nodes, maxDist)
+	return matches[:min(k, len(matches))]
+}
+
+func (g *gridWayIndex) WaysWithin(lat, lon, radius float64) []WayMatch {
+	return matchWays(lat, lon, g.index.QueryWays(lat, lon, radius), g.m.Nodes, radius)
+}
+
+// rtreeNodeSize is the fan out of the tree, 16 keeps a leaf scan short
+// while the tree stays shallow.
+const rtreeNodeSize = 16
+
+// rtreeMinRadius is the smallest radius of curvature of WGS84, at the
+// equator along the meridian, so distances from it never overestimate.
+const rtreeMinRadius = 6335439.0
+
+type rtreeBox struct {
+	MinLat, MinLon, MaxLat, MaxLon float64
+}
+
+func (b rtreeBox) extend(o rtreeBox) rtreeBox {
+	return rtreeBox{
+		MinLat: math.Min(b.MinLat, o.MinLat),
+		MinLon: math.Min(b.MinLon, o.MinLon),
+		MaxLat: math.Max(b.MaxLat, o.MaxLat),
+		MaxLon: math.Max(b.MaxLon, o.MaxLon),
+	}
+}
+
+// minDistance is a lower bound in metres of the distance from the point to
+// anything in the box. Both gaps are measured as the tangent plane
+// distances projectOnSegment returns, R sin of the angle.
+func (b rtreeBox) minDistance(lat, lon float64) float64 {
+	var latGap float64
+	if lat < b.MinLat {
+		latGap = b.MinLat - lat
+	} else if lat > b.MaxLat {
+		latGap = lat - b.MaxLat
+	}
+	latGap = rtreeMinRadius * math.Sin(DegToRad(math.Min(latGap, 90)))
+
+	var lonGap float64
+	east := math.Mod(b.MinLon-lon+720, 360)
+	west := math.Mod(lon-b.MaxLon+720, 360)
+	if east < 360-(b.MaxLon-b.MinLon) && west < 360-(b.MaxLon-b.MinLon) {
+		// distance to the great circle of the nearer edge meridian
+		cosLat := math.Cos(DegToRad(lat))
+		lonGap = rtreeMinRadius * cosLat * math.Min(
+			math.Abs(math.Sin(DegToRad(east))), math.Abs(math.Sin(DegToRad(west))))
+	}
+
+	return math.Max(latGap, lonGap)
+}
+
+// rtreeSegment is one segment of a way. A segment crossing the antimeridian
+// is stored twice, once on each side, so no box wraps.
+type rtreeSegment struct {
+	way   *osm.Way
+	index int
+	box   rtreeBox
+}
+
+type rtreeNode struct {
+	box      rtreeBox
+	children []*rtreeNode
+	segments []*rtreeSegment
+}
+
+// SegmentRTree is an R-tree over the individual segments of ways, bulk
+// loaded with Sort-Tile-Recursive packing. Unlike the grid it measures
+// distances to segments, so a query never scans a whole long way.
+type SegmentRTree struct {
+	root  *rtreeNode
+	nodes map[osm.NodeID]*osm.Node
+	size  int
+}
+
+func NewSegmentRTree(ways []*osm.Way, nodes map[osm.NodeID]*osm.Node) *SegmentRTree {
+	var segments []*rtreeSegment
+
+	for _, way := range ways {
+		for i := 0; i < len(way.Nodes)-1; i++ {
+			n1, ok1 := nodes[way.Nodes[i].ID]
+			n2, ok2 := nodes[way.Nodes[i+1].ID]
+			if !ok1 || !ok2 {
+				continue
+			}
+
+			minLat, maxLat := math.Min(n1.Lat, n2.Lat), math.Max(n1.Lat, n2.Lat)
+			minLon, maxLon := math.Min(n1.Lon, n2.Lon), math.Max(n1.Lon, n2.Lon)
+
+			if maxLon-minLon > 180 {
+				maxLat, minLat = segmentBulge(minLat, maxLat, 360-(maxLon-minLon))
+				segments = append(segments,
+					&rtreeSegment{way: way, index: i, box: rtreeBox{minLat, maxLon, maxLat, 180}},
+					&rtreeSegment{way: way, index: i, box: rtreeBox{minLat, -180, maxLat, minLon}})
+				continue
+			}
+			maxLat, minLat = segmentBulge(minLat, maxLat, maxLon-minLon)
+			segments = append(segments, &rtreeSegment{way: way, index: i, box: rtreeBox{minLat, minLon, maxLat, maxLon}})
+		}
+	}
+
+	return &SegmentRTree{root: packSegments(segments), nodes: nodes, size: len(segments)}
+}
+
+// segmentBulge widens the latitudes of a segment to the vertex of the great
+// circle through its ends, which lies poleward of both over a wide
+// longitude span.
+func segmentBulge(minLat, maxLat, lonSpan float64) (north, south float64) {
+	c := math.Cos(DegToRad(lonSpan / 2))
+	if c <= 0 {
+		return 90, -90
+	}
+	vertex := func(lat float64) float64 {
+		return math.Atan(math.Tan(DegToRad(lat))/c) * 180 / math.Pi
+	}
+	return math.Max(maxLat, vertex(maxLat)), math.Min(minLat, vertex(minLat))
+}
+
+func (m *Map) BuildSegmentRTree() *SegmentRTree {
+	return NewSegmentRTree(m.Ways, m.Nodes)
+}
+
+// Len is the number of segment entries in the tree.
+func (t *SegmentRTree) Len() int {
+	return t.size
+}
+
+func boxCenter(b rtreeBox) (lat, lon float64) {
+	return (b.MinLat + b.MaxLat) / 2, (b.MinLon + b.MaxLon) / 2
+}
+
+// strPack groups items Sort-Tile-Recursive: sorted into vertical slices by
+// longitude, each slice sorted by latitude and cut into full nodes.
+func strPack[T any](items []T, box func(T) rtreeBox) [][]T {
+	groups := (len(items) + rtreeNodeSize - 1) / rtreeNodeSize
+	sliceLen := int(math.Ceil(math.Sqrt(float64(groups)))) * rtreeNodeSize
+
+	sort.Slice(items, func(i, j int) bool {
+		_, lonI := boxCenter(box(items[i]))
+		_, lonJ := boxCenter(box(items[j]))
+		return lonI < lonJ
+	})
+
+	var packed [][]T
+	for start := 0; start < len(items); start += sliceLen {
+		slice := items[start:min(start+sliceLen, len(items))]
+		sort.Slice(slice, func(i, j int) bool {
+			latI, _ := boxCenter(box(slice[i]))
+			latJ, _ := boxCenter(box(slice[j]))
+			return latI < latJ
+		})
+
+		for g := 0; g < len(slice); g += rtreeNodeSize {
+			end := min(g+rtreeNodeSize, len(slice))
+			packed = append(packed, slice[g:end:end])
+		}
+	}
+	return packed
+}
+
+func packSegments(segments []*rtreeSegment) *rtreeNode {
+	if len(segments) == 0 {
+		return nil
+	}
+
+	var level []*rtreeNode
+	for _, group := range strPack(segments, func(s *rtreeSegment) rtreeBox { return s.box }) {
+		leaf := &rtreeNode{segments: group, box: group[0].box}
+		for _, s := range group {
+			leaf.box = leaf.box.extend(s.box)
+		}
+		level = append(level, leaf)
+	}
+
+	for len(level) > 1 {
+		var parents []*rtreeNode
+		for _, group := range strPack(level, func(n *rtreeNode) rtreeBox { return n.box }) {
+			parent := &rtreeNode{children: group, box: group[0].box}
+			for _, c := range group {
+				parent.box = parent.box.extend(c.box)
+			}
+			parents = append(parents, parent)
+		}
+		level = parents
+	}
+
+	return level[0]
+}
+
+func (t *SegmentRTree) segmentDistance(lat, lon float64, s *rtreeSegment) float64 {
+	n1 := t.nodes[s.way.Nodes[s.index].ID]
+	n2 := t.nodes[s.way.Nodes[s.index+1].ID]
+	_, dist, _ := projectOnSegment(lat, lon, n1.Lat, n1.Lon, n2.Lat, n2.Lon)
+	return dist
+}
+
+// rtreeItem is a node of the tree, or a segment once its exact distance is
+// known, waiting in the search queue.
+type rtreeItem struct {
+	dist    float64
+	node    *rtreeNode
+	segment *rtreeSegment
+}
+
+type rtreeQueue []rtreeItem
+
+func (q rtreeQueue) Len() int { return len(q) }
+func (q rtreeQueue) Less(i, j int) bool {
+	if q[i].dist != q[j].dist {
+		return q[i].dist < q[j].dist
+	}
+	// settle ties on segments the same way every time
+	if q[i].segment == nil || q[j].segment == nil {
+		return q[i].segment != nil
+	}
+	if q[i].segment.way.ID != q[j].segment.way.ID {
+		return q[i].segment.way.ID < q[j].segment.way.ID
+	}
+	return q[i].segment.index < q[j].segment.index
+}
+func (q rtreeQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *rtreeQueue) Push(x any)   { *q = append(*q, x.(rtreeItem)) }
+func (q *rtreeQueue) Pop() any {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
+
+// nearest visits the segments within maxDist in order of distance, best
+// first: boxes are opened in order of their lower bound, so a segment
+// leaves the queue only once nothing left can be closer. It stops when
+// visit returns false.
+func (t *SegmentRTree) nearest(lat, lon, maxDist float64, visit func(s *rtreeSegment, dist float64) bool) {
+	if t.root == nil {
+		return
+	}
+
+	queue := rtreeQueue{{dist: t.root.box.minDistance(lat, lon), node: t.root}}
+
+	for queue.Len() > 0 {
+		item := heap.Pop(&queue).(rtreeItem)
+		if item.dist > maxDist {
+			return
+		}
+		if item.segment != nil {
+			if !visit(item.segment, item.dist) {
+				return
+			}
+			continue
+		}
+
+		for _, child := range item.node.children {
+			if d := child.box.minDistance(lat, lon); d <= maxDist {
+				heap.Push(&queue, rtreeItem{dist: d, node: child})
+			}
+		}
+		for _, s := range item.node.segments {
+			if d := t.segmentDistance(lat, lon, s); d <= maxDist {
+				heap.Push(&queue, rtreeItem{dist: d, segment: s})
+			}
+		}
+	}
+}
+
+// NearestSegment is the closest segment to the point within maxDist.
+func (t *SegmentRTree) NearestSegment(lat, lon, maxDist float64) (way *osm.Way, segmentIdx int, dist float64, ok bool) {
+	dist = math.Inf(1)
+	t.nearest(lat, lon, maxDist, func(s *rtreeSegment, d float64) bool {
+		way, segmentIdx, dist, ok = s.way, s.index, d, true
+		return false
+	})
+	return way, segmentIdx, dist, ok
+}
+
+func (t *SegmentRTree) FindNearestWay(lat, lon, maxDist float64) (*osm.Way, float64) {
+	way, _, dist, ok := t.NearestSegment(lat, lon, maxDist)
+	if !ok {
+		return nil, math.Inf(1)
+	}
+	return way, dist
+}
+
+// NearestWays returns up to k ways within maxDist, closest first. A way
+// is ranked by its closest segment, the first one the search meets.
+func (t *SegmentRTree) NearestWays(lat, lon float64, k int, maxDist float64) []WayMatch {
+	if k <= 0 {
+		return nil
+	}
+
+	seen := make(map[osm.WayID]bool)
+	var matches []WayMatch
+
+	t.nearest(lat, lon, maxDist, func(s *rtreeSegment, _ float64) bool {
+		if seen[s.way.ID] {
+			return true
+		}
+		seen[s.way.ID] = true
+
+		if proj, ok := ProjectOnWay(lat, lon, s.way, t.nodes); ok {
+			matches = append(matches, WayMatch{Way: s.way, WayProjection: proj})
+		}
+		return len(matches) < k
+	})
+
+	return matches
+}
+
+func (t *SegmentRTree) WaysWithin(lat, lon, radius float64) []WayMatch {
+	return matchWays(lat, lon, t.QueryWays(lat, lon, radius), t.nodes, radius)
+}
+
+// QueryWays returns the ways with a segment within radius of the point.
+func (t *SegmentRTree) QueryWays(lat, lon, radius float64) []*osm.Way {
+	seen := make(map[osm.WayID]bool)
+	var results []*osm.Way
+
+	t.search(t.root, lat, lon, radius, func(s *rtreeSegment) {
+		if !seen[s.way.ID] {
+			seen[s.way.ID] = true
+			results = append(results, s.way)
+		}
+	})
+
+	return results
+}
+
+func (t *SegmentRTree) search(n *rtreeNode, lat, lon, radius float64, visit func(*rtreeSegment)) {
+	if n == nil || n.box.minDistance(lat, lon) > radius {
+		return
+	}
+	for _, child := range n.children {
+		t.search(child, lat, lon, radius, visit)
+	}
+	for _, s := range n.segments {
+		if t.segmentDistance(lat, lon, s) <= radius {
+			visit(s)
+		}
+	}
+}
@@ -1,74 +1,261 @@
 package osmprocessing
 
 import (
-	"github.com/paulmach/osm"
+	"fmt"
 	"math"
+	"slices"
+	"sync"
+
+	"github.com/paulmach/osm"
 )
 
 type GridCell struct {
 	LatIdx, LonIdx int
 }
 
+// SpatialIndex buckets ways and nodes into square cells of cellMeters metres
+// on the plane tangent to the earth at the map's center, so a cell covers
+// the same ground at any latitude. Cells are GridCell{LatIdx: north row,
+// LonIdx: east column}.
+//
+// It is safe for concurrent use. Buckets are copied on write and never
+// changed once published, so a reader holds the lock only to pick up the
+// buckets and then works on a snapshot of them while edits go on.
 type SpatialIndex struct {
-	wayGrid  map[GridCell][]*osm.Way
-	nodeGrid map[GridCell][]*osm.Node
-	cellSize float64 // in degrees
+	mu         sync.RWMutex
+	wayGrid    map[GridCell][]*osm.Way
+	nodeGrid   map[GridCell][]*osm.Node
+	wayCells   map[osm.WayID][]GridCell
+	nodeCells  map[osm.NodeID]GridCell
+	projection *LocalENU
+	cellSize   float64 // in metres
 }
 
-func NewSpatialIndex(cellSize float64) *SpatialIndex {
+// Cells of the grid are between minCellMeters and maxCellMeters wide. The
+// cells used to be given in degrees, a size below a metre is one of those.
+const (
+	minCellMeters = 1.0
+	maxCellMeters = 100_000.0
+)
+
+// NewSpatialIndex makes an empty grid of cells cellMeters wide around
+// lat0, lon0. It panics on a cell size out of [minCellMeters,
+// maxCellMeters].
+func NewSpatialIndex(lat0, lon0, cellMeters float64) *SpatialIndex {
+	checkCellMeters(cellMeters)
 	return &SpatialIndex{
-		wayGrid:  make(map[GridCell][]*osm.Way),
-		nodeGrid: make(map[GridCell][]*osm.Node),
-		cellSize: cellSize,
+		wayGrid:    make(map[GridCell][]*osm.Way),
+		nodeGrid:   make(map[GridCell][]*osm.Node),
+		wayCells:   make(map[osm.WayID][]GridCell),
+		nodeCells:  make(map[osm.NodeID]GridCell),
+		projection: NewLocalENU(lat0, lon0),
+		cellSize:   cellMeters,
+	}
+}
+
+func checkCellMeters(cellMeters float64) {
+	if !(cellMeters >= minCellMeters && cellMeters <= maxCellMeters) {
+		panic(fmt.Sprintf("spatial index cells of %vm, the size is in metres from %v to %v", cellMeters, minCellMeters, maxCellMeters))
+	}
+}
+
+// addToBucket appends to a copy of the bucket, readers may still hold the
+// old one.
+func addToBucket[T any](grid map[GridCell][]T, cell GridCell, item T) {
+	grid[cell] = append(slices.Clip(grid[cell]), item)
+}
+
+func removeFromBucket[T any](grid map[GridCell][]T, cell GridCell, match func(T) bool) {
+	bucket := slices.DeleteFunc(slices.Clone(grid[cell]), match)
+	if len(bucket) == 0 {
+		delete(grid, cell)
+		return
 	}
+	grid[cell] = bucket
 }
 
 func (si *SpatialIndex) getCell(lat, lon float64) GridCell {
+	x, y := si.projection.Forward(lat, lon)
+	return si.planeCell(x, y)
+}
+
+func (si *SpatialIndex) planeCell(x, y float64) GridCell {
 	return GridCell{
-		LatIdx: int(math.Floor(lat / si.cellSize)),
-		LonIdx: int(math.Floor(lon / si.cellSize)),
+		LatIdx: int(math.Floor(y / si.cellSize)),
+		LonIdx: int(math.Floor(x / si.cellSize)),
 	}
 }
 
+// cellDistance is the distance on the plane from x, y to the closest point
+// of the cell.
+func (si *SpatialIndex) cellDistance(cell GridCell, x, y float64) float64 {
+	minX, minY := float64(cell.LonIdx)*si.cellSize, float64(cell.LatIdx)*si.cellSize
+	dx := math.Max(0, math.Max(minX-x, x-(minX+si.cellSize)))
+	dy := math.Max(0, math.Max(minY-y, y-(minY+si.cellSize)))
+	return math.Hypot(dx, dy)
+}
+
+// cellsWithin visits the cells reaching within radius metres of the point.
+// The tangent plane never lengthens a distance, so they hold everything
+// within radius on the ground.
+func (si *SpatialIndex) cellsWithin(lat, lon, radius float64, visit func(GridCell)) {
+	x, y := si.projection.Forward(lat, lon)
+	center := si.planeCell(x, y)
+	cells := int(math.Ceil(radius / si.cellSize))
+
+	for dLat := -cells; dLat <= cells; dLat++ {
+		for dLon := -cells; dLon <= cells; dLon++ {
+			cell := GridCell{LatIdx: center.LatIdx + dLat, LonIdx: center.LonIdx + dLon}
+			if si.cellDistance(cell, x, y) <= radius {
+				visit(cell)
+			}
+		}
+	}
+}
+
+// InsertWay files the way under every cell one of its segments passes
+// through, so a long segment is found from its middle too. A way already
+// in the index with the same ID is replaced.
 func (si *SpatialIndex) InsertWay(way *osm.Way, nodes map[osm.NodeID]*osm.Node) {
+	cells := si.wayCellsOf(way, nodes)
+
+	si.mu.Lock()
+	defer si.mu.Unlock()
+
+	si.removeWay(way.ID)
+	for _, cell := range cells {
+		addToBucket(si.wayGrid, cell, way)
+	}
+	si.wayCells[way.ID] = cells
+}
+
+// UpdateWay refiles a way after its nodes or their positions changed, in
+// a single step for concurrent readers. Moving a node with UpdateNode does
+// not move the ways through it.
+func (si *SpatialIndex) UpdateWay(way *osm.Way, nodes map[osm.NodeID]*osm.Node) {
+	si.InsertWay(way, nodes)
+}
+
+// RemoveWay takes the way out of the index, it returns false if it wasn't
+// there.
+func (si *SpatialIndex) RemoveWay(id osm.WayID) bool {
+	si.mu.Lock()
+	defer si.mu.Unlock()
+	return si.removeWay(id)
+}
+
+func (si *SpatialIndex) removeWay(id osm.WayID) bool {
+	cells, ok := si.wayCells[id]
+	if !ok {
+		return false
+	}
+	for _, cell := range cells {
+		removeFromBucket(si.wayGrid, cell, func(w *osm.Way) bool { return w.ID == id })
+	}
+	delete(si.wayCells, id)
+	return true
+}
+
+func (si *SpatialIndex) wayCellsOf(way *osm.Way, nodes map[osm.NodeID]*osm.Node) []GridCell {
 	seen := make(map[GridCell]bool)
+	var cells []GridCell
+	add := func(cell GridCell) {
+		if !seen[cell] {
+			cells = append(cells, cell)
+			seen[cell] = true
+		}
+	}
+
+	// the segments are straight on the ground, not quite on this plane,
+	// a metre of slack covers the difference
+	reach := si.cellSize*math.Sqrt2/2 + 1
+
+	var prevX, prevY float64
+	prevOK := false
 	for _, wn := range way.Nodes {
-		if node, ok := nodes[wn.ID]; ok {
-			cell := si.getCell(node.Lat, node.Lon)
-			if !seen[cell] {
-				si.wayGrid[cell] = append(si.wayGrid[cell], way)
-				seen[cell] = true
+		node, ok := nodes[wn.ID]
+		if !ok {
+			prevOK = false
+			continue
+		}
+
+		x, y := si.projection.Forward(node.Lat, node.Lon)
+		add(si.planeCell(x, y))
+
+		if prevOK {
+			from := si.planeCell(min(prevX, x)-reach, min(prevY, y)-reach)
+			to := si.planeCell(max(prevX, x)+reach, max(prevY, y)+reach)
+			for latIdx := from.LatIdx; latIdx <= to.LatIdx; latIdx++ {
+				for lonIdx := from.LonIdx; lonIdx <= to.LonIdx; lonIdx++ {
+					cx := (float64(lonIdx) + 0.5) * si.cellSize
+					cy := (float64(latIdx) + 0.5) * si.cellSize
+					if planeSegmentDistance(cx, cy, prevX, prevY, x, y) <= reach {
+						add(GridCell{LatIdx: latIdx, LonIdx: lonIdx})
+					}
+				}
 			}
 		}
+		prevX, prevY, prevOK = x, y, true
+	}
+
+	return cells
+}
+
+func planeSegmentDistance(px, py, x1, y1, x2, y2 float64) float64 {
+	dx, dy := x2-x1, y2-y1
+	t := 0.0
+	if dx != 0 || dy != 0 {
+		t = math.Max(0, math.Min(1, ((px-x1)*dx+(py-y1)*dy)/(dx*dx+dy*dy)))
 	}
+	return math.Hypot(px-(x1+t*dx), py-(y1+t*dy))
 }
 
+// InsertNode files the node under the cell of its position, replacing a
+// node with the same ID.
 func (si *SpatialIndex) InsertNode(node *osm.Node) {
 	cell := si.getCell(node.Lat, node.Lon)
-	si.nodeGrid[cell] = append(si.nodeGrid[cell], node)
+
+	si.mu.Lock()
+	defer si.mu.Unlock()
+
+	si.removeNode(node.ID)
+	addToBucket(si.nodeGrid, cell, node)
+	si.nodeCells[node.ID] = cell
 }
 
-func (si *SpatialIndex) QueryWays(lat, lon, radius float64) []*osm.Way {
-	centerCell := si.getCell(lat, lon)
+// UpdateNode moves a node to its new position. Pass a new *osm.Node rather
+// than changing the one in the index, readers may be looking at it.
+func (si *SpatialIndex) UpdateNode(node *osm.Node) {
+	si.InsertNode(node)
+}
 
-	radiusInDegrees := radius / 111000.0 // 1 degree = 111km
-	radiusInCells := int(math.Ceil(radiusInDegrees/si.cellSize)) + 1
+func (si *SpatialIndex) RemoveNode(id osm.NodeID) bool {
+	si.mu.Lock()
+	defer si.mu.Unlock()
+	return si.removeNode(id)
+}
 
+func (si *SpatialIndex) removeNode(id osm.NodeID) bool {
+	cell, ok := si.nodeCells[id]
+	if !ok {
+		return false
+	}
+	removeFromBucket(si.nodeGrid, cell, func(n *osm.Node) bool { return n.ID == id })
+	delete(si.nodeCells, id)
+	return true
+}
+
+// QueryWays returns the ways filed under cells within radius metres, they
+// may lie a little further than radius.
+func (si *SpatialIndex) QueryWays(lat, lon, radius float64) []*osm.Way {
 	seen := make(map[osm.WayID]bool)
 	var results []*osm.Way
 
-	for dLat := -radiusInCells; dLat <= radiusInCells; dLat++ {
-		for dLon := -radiusInCells; dLon <= radiusInCells; dLon++ {
-			cell := GridCell{
-				LatIdx: centerCell.LatIdx + dLat,
-				LonIdx: centerCell.LonIdx + dLon,
-			}
-
-			for _, way := range si.wayGrid[cell] {
-				if !seen[way.ID] {
-					results = append(results, way)
-					seen[way.ID] = true
-				}
+	for _, bucket := range bucketsWithin(si, si.wayGrid, lat, lon, radius) {
+		for _, way := range bucket {
+			if !seen[way.ID] {
+				results = append(results, way)
+				seen[way.ID] = true
 			}
 		}
 	}
@@ -77,35 +264,36 @@ func (si *SpatialIndex) QueryWays(lat, lon, radius float64) []*osm.Way {
 }
 
 func (si *SpatialIndex) QueryNodes(lat, lon, radius float64) []*osm.Node {
-	centerCell := si.getCell(lat, lon)
-
-	radiusInDegrees := radius / 111000.0
-	radiusInCells := int(math.Ceil(radiusInDegrees/si.cellSize)) + 1
-
-	seen := make(map[osm.NodeID]bool)
 	var results []*osm.Node
 
-	for dLat := -radiusInCells; dLat <= radiusInCells; dLat++ {
-		for dLon := -radiusInCells; dLon <= radiusInCells; dLon++ {
-			cell := GridCell{
-				LatIdx: centerCell.LatIdx + dLat,
-				LonIdx: centerCell.LonIdx + dLon,
-			}
-
-			for _, node := range si.nodeGrid[cell] {
-				if !seen[node.ID] {
-					results = append(results, node)
-					seen[node.ID] = true
-				}
-			}
-		}
+	for _, bucket := range bucketsWithin(si, si.nodeGrid, lat, lon, radius) {
+		results = append(results, bucket...)
 	}
 
 	return results
 }
 
-func (m *Map) BuildSpatialIndex(cellSize float64) *SpatialIndex {
-	index := NewSpatialIndex(cellSize)
+// bucketsWithin picks up the buckets of the cells within radius metres,
+// holding the lock only meanwhile.
+func bucketsWithin[T any](si *SpatialIndex, grid map[GridCell][]T, lat, lon, radius float64) [][]T {
+	var buckets [][]T
+
+	si.mu.RLock()
+	defer si.mu.RUnlock()
+
+	si.cellsWithin(lat, lon, radius, func(cell GridCell) {
+		if bucket, ok := grid[cell]; ok {
+			buckets = append(buckets, bucket)
+		}
+	})
+	return buckets
+}
+
+// BuildSpatialIndex indexes the map in cells of cellMeters metres around
+// the center of its bounds, see NewSpatialIndex.
+func (m *Map) BuildSpatialIndex(cellMeters float64) *SpatialIndex {
+	lat0, lon0 := m.CalculateBounds().GetCenter()
+	index := NewSpatialIndex(lat0, lon0, cellMeters)
 
 	for _, way := range m.Ways {
 		index.InsertWay(way, m.Nodes)
@@ -0,0 +1,254 @@
+package osmprocessing
+
+import (
+	"math"
+	"math/rand"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/paulmach/osm"
+)
+
+var indexLatitudes = []struct {
+	name     string
+	lat, lon float64
+}{
+	{"equator", 0.5, 9.45},
+	{"bordeaux", 44.84, -0.58},
+	{"helsinki", 60.17, 24.94},
+	{"tromso", 69.65, 18.96},
+	{"longyearbyen", 78.22, 15.65},
+}
+
+func TestSpatialIndexCellMeters(t *testing.T) {
+	// a size in degrees, as cells were once given, is rejected
+	for _, cellMeters := range []float64{0.001, 0, -100, math.NaN(), 1e6} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("cells of %vm accepted", cellMeters)
+				}
+			}()
+			NewSpatialIndex(44.84, -0.58, cellMeters)
+		}()
+	}
+}
+
+func TestSpatialIndexRadiusAtLatitudes(t *testing.T) {
+	rng := rand.New(rand.NewSource(5))
+
+	for _, place := range indexLatitudes {
+		t.Run(place.name, func(t *testing.T) {
+			index := NewSpatialIndex(place.lat, place.lon, 100)
+			var nodes []*osm.Node
+			for i := 0; i < 2000; i++ {
+				lat, lon := DestinationPoint(place.lat, place.lon, rng.Float64()*360, rng.Float64()*1500)
+				node := &osm.Node{ID: osm.NodeID(i), Lat: lat, Lon: lon}
+				nodes = append(nodes, node)
+				index.InsertNode(node)
+			}
+
+			for i := 0; i < 50; i++ {
+				lat, lon := DestinationPoint(place.lat, place.lon, rng.Float64()*360, rng.Float64()*1500)
+				radius := []float64{30, 250}[i%2]
+
+				found := map[osm.NodeID]bool{}
+				for _, node := range index.QueryNodes(lat, lon, radius) {
+					found[node.ID] = true
+					// no further than the far corner of a cell past the radius
+					if d := HaversineDistance(lat, lon, node.Lat, node.Lon); d > radius+100*math.Sqrt2 {
+						t.Fatalf("node %d at %.1fm returned for %vm", node.ID, d, radius)
+					}
+				}
+				for _, node := range nodes {
+					if HaversineDistance(lat, lon, node.Lat, node.Lon) <= radius && !found[node.ID] {
+						t.Fatalf("node %d within %vm missed", node.ID, radius)
+					}
+				}
+			}
+
+			// a cell is 100m along both axes
+			eastLat, eastLon := DestinationPoint(place.lat, place.lon, 90, 1000)
+			from, to := index.getCell(place.lat, place.lon), index.getCell(eastLat, eastLon)
+			if cells := to.LonIdx - from.LonIdx; cells < 9 || cells > 10 {
+				t.Errorf("1km east spans %d cells", cells)
+			}
+		})
+	}
+}
+
+func TestSpatialIndexLongWays(t *testing.T) {
+	for _, place := range indexLatitudes {
+		t.Run(place.name, func(t *testing.T) {
+			m, grid := GenerateMap(2, 2, 1000,
+				MakeCoordinateDecimal(place.lat, Latitude),
+				MakeCoordinateDecimal(place.lon, Longitude))
+			index := m.BuildSpatialIndex(100)
+
+			// 20m north of the middle of a 1km street, 5 cells from its ends
+			a, b := m.Nodes[grid["1,0"]], m.Nodes[grid["1,1"]]
+			lat, lon := DestinationPoint((a.Lat+b.Lat)/2, (a.Lon+b.Lon)/2, 0, 20)
+
+			way, dist := m.FindNearestWay(lat, lon, 30, index)
+			if way == nil || math.Abs(dist-20) > 0.5 {
+				t.Errorf("got %v at %.2f, want the street 20m away", way, dist)
+			}
+		})
+	}
+}
+
+func TestSpatialIndexEdits(t *testing.T) {
+	m, grid := GenerateMap(2, 2, 200,
+		ToDecimalCoord(46, 0, 0, North),
+		ToDecimalCoord(7, 0, 0, East))
+	index := m.BuildSpatialIndex(100)
+
+	a, b := m.Nodes[grid["0,0"]], m.Nodes[grid["0,1"]]
+	lat, lon := (a.Lat+b.Lat)/2, (a.Lon+b.Lon)/2
+	street := m.Ways[0]
+
+	has := func(ways []*osm.Way, id osm.WayID) bool {
+		return slices.ContainsFunc(ways, func(w *osm.Way) bool { return w.ID == id })
+	}
+
+	// inserting again replaces
+	index.InsertWay(street, m.Nodes)
+	count := 0
+	for _, way := range index.QueryWays(lat, lon, 10) {
+		if way.ID == street.ID {
+			count++
+		}
+	}
+	if count != 1 {
+		t.Errorf("street returned %d times", count)
+	}
+
+	if !index.RemoveWay(street.ID) || index.RemoveWay(street.ID) {
+		t.Error("removing reported the wrong result")
+	}
+	if has(index.QueryWays(lat, lon, 10), street.ID) {
+		t.Error("removed street still found")
+	}
+
+	// move the street 1km north with new nodes
+	nodes := map[osm.NodeID]*osm.Node{}
+	for _, wn := range street.Nodes {
+		n := *m.Nodes[wn.ID]
+		n.Lat, n.Lon = DestinationPoint(n.Lat, n.Lon, 0, 1000)
+		nodes[n.ID] = &n
+	}
+	index.UpdateWay(street, nodes)
+	northLat, northLon := DestinationPoint(lat, lon, 0, 1000)
+	if !has(index.QueryWays(northLat, northLon, 10), street.ID) || has(index.QueryWays(lat, lon, 10), street.ID) {
+		t.Error("street not moved")
+	}
+
+	moved := *a
+	moved.Lat, moved.Lon = northLat, northLon
+	index.UpdateNode(&moved)
+	if got := index.QueryNodes(northLat, northLon, 1); len(got) != 1 || got[0].ID != a.ID {
+		t.Errorf("moved node not found, got %v", got)
+	}
+	for _, node := range index.QueryNodes(a.Lat, a.Lon, 1) {
+		if node.ID == a.ID {
+			t.Error("moved node still at its old position")
+		}
+	}
+	if !index.RemoveNode(a.ID) || len(index.QueryNodes(northLat, northLon, 1)) != 0 {
+		t.Error("node not removed")
+	}
+}
+
+// TestSpatialIndexConcurrent runs queries alongside edits, it is meant for
+// go test -race.
+func TestSpatialIndexConcurrent(t *testing.T) {
+	m, grid := GenerateMap(6, 6, 100,
+		ToDecimalCoord(46, 0, 0, North),
+		ToDecimalCoord(7, 0, 0, East))
+	index := m.BuildSpatialIndex(100)
+	bounds := m.CalculateBounds()
+
+	// streets of the first row stay put, the writer moves the avenues
+	// about and removes and restores nodes
+	var streets, avenues []*osm.Way
+	for _, way := range m.Ways {
+		if way.Tags.Find("name") == "Street 0" {
+			streets = append(streets, way)
+		} else if way.Tags.Find("name")[:6] == "Avenue" {
+			avenues = append(avenues, way)
+		}
+	}
+	origin := m.Nodes[grid["0,0"]]
+
+	done := make(chan struct{})
+	errs := make(chan string, 8)
+	var wg sync.WaitGroup
+
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				for _, street := range streets {
+					n1, n2 := m.Nodes[street.Nodes[0].ID], m.Nodes[street.Nodes[1].ID]
+					if !slices.Contains(index.QueryWays((n1.Lat+n2.Lat)/2, (n1.Lon+n2.Lon)/2, 5), street) {
+						errs <- "static street missing"
+						return
+					}
+				}
+				index.QueryNodes(origin.Lat, origin.Lon, 300)
+				for range m.WaysInPolygon(bounds.ToPolygon(), index) {
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 200; i++ {
+		avenue := avenues[i%len(avenues)]
+		nodes := map[osm.NodeID]*osm.Node{}
+		for _, wn := range avenue.Nodes {
+			n := *m.Nodes[wn.ID]
+			n.Lat, n.Lon = DestinationPoint(n.Lat, n.Lon, 90, float64(i%7))
+			nodes[n.ID] = &n
+			index.UpdateNode(&n)
+		}
+		index.UpdateWay(avenue, nodes)
+		if i%3 == 0 {
+			index.RemoveWay(avenue.ID)
+			index.RemoveNode(avenue.Nodes[0].ID)
+		}
+	}
+
+	// put everything back and compare with a fresh index
+	for _, way := range m.Ways {
+		index.InsertWay(way, m.Nodes)
+	}
+	for _, node := range m.Nodes {
+		index.InsertNode(node)
+	}
+	close(done)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	fresh := m.BuildSpatialIndex(100)
+	for _, node := range m.Nodes {
+		got := wayIDs(index.QueryWays(node.Lat, node.Lon, 150))
+		want := wayIDs(fresh.QueryWays(node.Lat, node.Lon, 150))
+		if !slices.Equal(got, want) {
+			t.Fatalf("around node %d got ways %v want %v", node.ID, got, want)
+		}
+		if len(index.QueryNodes(node.Lat, node.Lon, 150)) != len(fresh.QueryNodes(node.Lat, node.Lon, 150)) {
+			t.Fatalf("around node %d the nodes differ", node.ID)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package osmprocessing
+
+import (
+	"iter"
+	"math"
+
+	"github.com/paulmach/osm"
+)
+
+// polygonRange is a polygon ready for exact tests against nodes and
+// segments. Its rings are unwrapped so no edge jumps across the
+// antimeridian, and longitudes are moved next to them before a test.
+type polygonRange struct {
+	rings          [][]LatLon // outer ring first
+	minLat, maxLat float64
+	minLon, maxLon float64 // maxLon may be past 180
+}
+
+func newPolygonRange(p Polygon) *polygonRange {
+	r := &polygonRange{
+		minLat: math.Inf(1), maxLat: math.Inf(-1),
+		minLon: math.Inf(1), maxLon: math.Inf(-1),
+	}
+
+	for i, ring := range p.Rings() {
+		unwrapped := make([]LatLon, len(ring))
+		for j, pt := range ring {
+			if j > 0 {
+				pt.Lon = unwrapped[j-1].Lon + normalizeLon(pt.Lon-ring[j-1].Lon)
+			} else if i > 0 && len(r.rings[0]) > 0 {
+				// holes start next to the outer ring
+				pt.Lon = r.rings[0][0].Lon + normalizeLon(pt.Lon-r.rings[0][0].Lon)
+			}
+			unwrapped[j] = pt
+
+			if i == 0 {
+				r.minLat, r.maxLat = math.Min(r.minLat, pt.Lat), math.Max(r.maxLat, pt.Lat)
+				r.minLon, r.maxLon = math.Min(r.minLon, pt.Lon), math.Max(r.maxLon, pt.Lon)
+			}
+		}
+		r.rings = append(r.rings, unwrapped)
+	}
+
+	return r
+}
+
+// shift brings lon between minLon and minLon+360.
+func (r *polygonRange) shift(lon float64) float64 {
+	return r.minLon + math.Mod(math.Mod(lon-r.minLon, 360)+360, 360)
+}
+
+// contains takes a longitude already next to the rings.
+func (r *polygonRange) contains(lat, lon float64) bool {
+	if len(r.rings) == 0 || !ringContains(r.rings[0], lat, lon) {
+		return false
+	}
+	for _, hole := range r.rings[1:] {
+		if ringContains(hole, lat, lon) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *polygonRange) containsNode(node *osm.Node) bool {
+	return r.contains(node.Lat, r.shift(node.Lon))
+}
+
+// intersectsSegment is true when any part of the segment lies in the
+// polygon, an end inside or a crossing with an edge of any ring. Like the
+// rings, the segment is a straight line in degrees.
+func (r *polygonRange) intersectsSegment(lat1, lon1, lat2, lon2 float64) bool {
+	lon1, lon2 = r.shift(lon1), r.shift(lon1)+normalizeLon(lon2-lon1)
+
+	// a segment starting just under minLon+360 may end back over the
+	// polygon's western side
+	for _, offset := range []float64{0, -360} {
+		a := LatLon{lat1, lon1 + offset}
+		b := LatLon{lat2, lon2 + offset}
+
+		if r.contains(a.Lat, a.Lon) || r.contains(b.Lat, b.Lon) {
+			return true
+		}
+		for _, ring := range r.rings {
+			for i := 0; i < len(ring)-1; i++ {
+				if segmentsIntersect(a, b, ring[i], ring[i+1]) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func (r *polygonRange) intersectsWay(way *osm.Way, nodes map[osm.NodeID]*osm.Node) bool {
+	var prev *osm.Node
+	for _, wn := range way.Nodes {
+		node, ok := nodes[wn.ID]
+		if !ok {
+			prev = nil
+			continue
+		}
+		if prev != nil && r.intersectsSegment(prev.Lat, prev.Lon, node.Lat, node.Lon) {
+			return true
+		}
+		if len(way.Nodes) == 1 && r.containsNode(node) {
+			return true
+		}
+		prev = node
+	}
+	return false
+}
+
+// orientation is positive when c is left of a to b, negative when right
+// and 0 when the three are aligned.
+func orientation(a, b, c LatLon) float64 {
+	return (b.Lon-a.Lon)*(c.Lat-a.Lat) - (b.Lat-a.Lat)*(c.Lon-a.Lon)
+}
+
+// onSegment is true when c, aligned with a and b, lies between them.
+func onSegment(a, b, c LatLon) bool {
+	return math.Min(a.Lon, b.Lon) <= c.Lon && c.Lon <= math.Max(a.Lon, b.Lon) &&
+		math.Min(a.Lat, b.Lat) <= c.Lat && c.Lat <= math.Max(a.Lat, b.Lat)
+}
+
+// segmentsIntersect is true when the segments cross or touch.
+func segmentsIntersect(a, b, c, d LatLon) bool {
+	o1, o2 := orientation(a, b, c), orientation(a, b, d)
+	o3, o4 := orientation(c, d, a), orientation(c, d, b)
+
+	if ((o1 > 0 && o2 < 0) || (o1 < 0 && o2 > 0)) && ((o3 > 0 && o4 < 0) || (o3 < 0 && o4 > 0)) {
+		return true
+	}
+
+	return (o1 == 0 && onSegment(a, b, c)) || (o2 == 0 && onSegment(a, b, d)) ||
+		(o3 == 0 && onSegment(c, d, a)) || (o4 == 0 && onSegment(c, d, b))
+}
+
+// rangeCells yields the buckets of the cells the polygon may reach. The
+// plane is only usable for a polygon that fits well inside a hemisphere,
+// for a larger one, or one covering more cells than are filled, it walks
+// the filled cells. The buckets are picked up under the read lock and
+// yielded after it is released, so the caller may edit the index.
+func rangeCells[T any](si *SpatialIndex, grid map[GridCell][]T, r *polygonRange) iter.Seq[[]T] {
+	return func(yield func([]T) bool) {
+		for _, bucket := range collectCells(si, grid, r) {
+			if !yield(bucket) {
+				return
+			}
+		}
+	}
+}
+
+func collectCells[T any](si *SpatialIndex, grid map[GridCell][]T, r *polygonRange) [][]T {
+	var buckets [][]T
+
+	si.mu.RLock()
+	defer si.mu.RUnlock()
+
+	if r.maxLat-r.minLat > 90 || r.maxLon-r.minLon > 90 {
+		for _, bucket := range grid {
+			buckets = append(buckets, bucket)
+		}
+		return buckets
+	}
+
+	// edges are straight in degrees but curve on the plane, so sample
+	// them and pad with a cell
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	outer := r.rings[0]
+	for i := 0; i < len(outer)-1; i++ {
+		for s := 0; s <= 16; s++ {
+			f := float64(s) / 16
+			x, y := si.projection.Forward(
+				outer[i].Lat+f*(outer[i+1].Lat-outer[i].Lat),
+				outer[i].Lon+f*(outer[i+1].Lon-outer[i].Lon))
+			minX, minY = math.Min(minX, x), math.Min(minY, y)
+			maxX, maxY = math.Max(maxX, x), math.Max(maxY, y)
+		}
+	}
+	from := si.planeCell(minX-si.cellSize, minY-si.cellSize)
+	to := si.planeCell(maxX+si.cellSize, maxY+si.cellSize)
+
+	if (to.LatIdx-from.LatIdx+1)*(to.LonIdx-from.LonIdx+1) > len(grid) {
+		for cell, bucket := range grid {
+			if cell.LatIdx >= from.LatIdx && cell.LatIdx <= to.LatIdx &&
+				cell.LonIdx >= from.LonIdx && cell.LonIdx <= to.LonIdx {
+				buckets = append(buckets, bucket)
+			}
+		}
+		return buckets
+	}
+
+	for latIdx := from.LatIdx; latIdx <= to.LatIdx; latIdx++ {
+		for lonIdx := from.LonIdx; lonIdx <= to.LonIdx; lonIdx++ {
+			if bucket, ok := grid[GridCell{LatIdx: latIdx, LonIdx: lonIdx}]; ok {
+				buckets = append(buckets, bucket)
+			}
+		}
+	}
+	return buckets
+}
+
+// WaysInPolygon yields every way with a part inside the polygon, once each
+// and in no particular order. Polygon edges are straight lines in degrees,
+// as for Polygon.Contains. A nil index scans all ways.
+func (m *Map) WaysInPolygon(p Polygon, index *SpatialIndex) iter.Seq[*osm.Way] {
+	r := newPolygonRange(p)
+
+	return func(yield func(*osm.Way) bool) {
+		if len(r.rings) == 0 || len(r.rings[0]) == 0 {
+			return
+		}
+
+		if index == nil {
+			for _, way := range m.Ways {
+				if r.intersectsWay(way, m.Nodes) && !yield(way) {
+					return
+				}
+			}
+			return
+		}
+
+		seen := make(map[osm.WayID]bool)
+		for bucket := range rangeCells(index, index.wayGrid, r) {
+			for _, way := range bucket {
+				if seen[way.ID] {
+					continue
+				}
+				seen[way.ID] = true
+				if r.intersectsWay(way, m.Nodes) && !yield(way) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// NodesInPolygon yields every node inside the polygon. A nil index scans
+// all nodes.
+func (m *Map) NodesInPolygon(p Polygon, index *SpatialIndex) iter.Seq[*osm.Node] {
+	r := newPolygonRange(p)
+
+	return func(yield func(*osm.Node) bool) {
+		if len(r.rings) == 0 || len(r.rings[0]) == 0 {
+			return
+		}
+
+		if index == nil {
+			for _, node := range m.Nodes {
+				if r.containsNode(node) && !yield(node) {
+					return
+				}
+			}
+			return
+		}
+
+		for bucket := range rangeCells(index, index.nodeGrid, r) {
+			for _, node := range bucket {
+				if r.containsNode(node) && !yield(node) {
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package osmprocessing
+
+import (
+	"math/rand"
+	"slices"
+	"testing"
+
+	"github.com/paulmach/osm"
+)
+
+func wayIDs(ways []*osm.Way) []osm.WayID {
+	ids := make([]osm.WayID, 0, len(ways))
+	for _, way := range ways {
+		ids = append(ids, way.ID)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+func TestWaysInPolygon(t *testing.T) {
+	rng := rand.New(rand.NewSource(6))
+	m := randomWayMap(rng, 400, 44.84, -0.58, 2000)
+	em := NewEnhancedMap(m)
+
+	// a concave L shape with a hole in its corner
+	lShape := Polygon{
+		Outer: []LatLon{
+			{44.830, -0.595}, {44.830, -0.570}, {44.836, -0.570}, {44.836, -0.588},
+			{44.848, -0.588}, {44.848, -0.595}, {44.830, -0.595},
+		},
+		Holes: [][]LatLon{{
+			{44.832, -0.593}, {44.834, -0.593}, {44.834, -0.590}, {44.832, -0.590}, {44.832, -0.593},
+		}},
+	}
+	polygons := []Polygon{
+		lShape,
+		Bounds{MinLat: 44.835, MaxLat: 44.845, MinLon: -0.59, MaxLon: -0.57}.ToPolygon(),
+		Bounds{MinLat: 44.8401, MaxLat: 44.8402, MinLon: -0.5801, MaxLon: -0.5800}.ToPolygon(),
+		Bounds{MinLat: 40, MaxLat: 50, MinLon: -5, MaxLon: 5}.ToPolygon(),
+	}
+
+	for i, p := range polygons {
+		got := wayIDs(slices.Collect(em.RangeWays(p)))
+		want := wayIDs(slices.Collect(m.WaysInPolygon(p, nil)))
+		if !slices.Equal(got, want) {
+			t.Errorf("polygon %d: index found %d ways, scan %d", i, len(got), len(want))
+		}
+
+		var nodes []osm.NodeID
+		for node := range em.RangeNodes(p) {
+			nodes = append(nodes, node.ID)
+		}
+		var wantNodes []osm.NodeID
+		for _, node := range m.Nodes {
+			if p.Contains(node.Lat, node.Lon) {
+				wantNodes = append(wantNodes, node.ID)
+			}
+		}
+		slices.Sort(nodes)
+		slices.Sort(wantNodes)
+		if !slices.Equal(nodes, wantNodes) {
+			t.Errorf("polygon %d: found %d nodes, want %d", i, len(nodes), len(wantNodes))
+		}
+	}
+
+	if len(em.WaysInBounds(polygons[3].Bounds())) != len(m.Ways) {
+		t.Error("a box around the whole map misses ways")
+	}
+
+	// stopping early
+	count := 0
+	for range em.RangeWays(polygons[3]) {
+		count++
+		break
+	}
+	if count != 1 {
+		t.Errorf("iterated %d ways after break", count)
+	}
+}
+
+func TestWaysInPolygonSegments(t *testing.T) {
+	m := &Map{Nodes: map[osm.NodeID]*osm.Node{
+		// crosses the box with both ends outside
+		1: {ID: 1, Lat: 44.835, Lon: -0.60},
+		2: {ID: 2, Lat: 44.845, Lon: -0.56},
+		// inside the hole
+		3: {ID: 3, Lat: 44.8395, Lon: -0.5805},
+		4: {ID: 4, Lat: 44.8405, Lon: -0.5795},
+		// from the hole out into the polygon
+		5: {ID: 5, Lat: 44.840, Lon: -0.580},
+		6: {ID: 6, Lat: 44.840, Lon: -0.575},
+		// outside, parallel to an edge
+		7: {ID: 7, Lat: 44.8301, Lon: -0.59},
+		8: {ID: 8, Lat: 44.8301, Lon: -0.57},
+	}}
+	m.Ways = []*osm.Way{
+		{ID: 1, Nodes: osm.WayNodes{{ID: 1}, {ID: 2}}},
+		{ID: 2, Nodes: osm.WayNodes{{ID: 3}, {ID: 4}}},
+		{ID: 3, Nodes: osm.WayNodes{{ID: 5}, {ID: 6}}},
+		{ID: 4, Nodes: osm.WayNodes{{ID: 7}, {ID: 8}}},
+	}
+	index := m.BuildSpatialIndex(100)
+
+	p := Bounds{MinLat: 44.831, MaxLat: 44.849, MinLon: -0.59, MaxLon: -0.57}.ToPolygon()
+	p.Holes = [][]LatLon{{{44.839, -0.581}, {44.839, -0.579}, {44.841, -0.579}, {44.841, -0.581}, {44.839, -0.581}}}
+
+	got := wayIDs(slices.Collect(m.WaysInPolygon(p, index)))
+	if want := []osm.WayID{1, 3}; !slices.Equal(got, want) {
+		t.Errorf("got ways %v want %v", got, want)
+	}
+}
+
+func TestWaysInBoundsAcrossAntimeridian(t *testing.T) {
+	m, grid := antimeridianMap(t)
+	em := NewEnhancedMap(m)
+
+	// a thin box on the antimeridian only crosses the streets through it
+	west, east := m.Nodes[grid["0,0"]], m.Nodes[grid["2,1"]]
+	box := Bounds{MinLat: west.Lat - 0.001, MaxLat: east.Lat + 0.001, MinLon: 179.9999, MaxLon: -179.9999}
+
+	ways := em.WaysInBounds(box)
+	if len(ways) != 3 {
+		t.Fatalf("got %d ways, want the 3 streets", len(ways))
+	}
+	for _, way := range ways {
+		if name := way.Tags.Find("name"); name[:6] != "Street" {
+			t.Errorf("got %s", name)
+		}
+	}
+
+	nodes := em.NodesInBounds(Bounds{MinLat: west.Lat - 0.001, MaxLat: east.Lat + 0.001, MinLon: west.Lon - 0.001, MaxLon: east.Lon + 0.001})
+	if len(nodes) != 6 {
+		t.Errorf("got %d nodes, want the 6 of the first two columns", len(nodes))
+	}
+}
@@ -0,0 +1,266 @@
+package osmprocessing
+
+import (
+	"math"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/paulmach/osm"
+)
+
+// streets scoring below this are not a match for a query
+const streetMatchMinScore = 0.7
+
+// Street is a road name with the ways that carry it.
+type Street struct {
+	Name string // as tagged on the first way found
+	Key  string // NormalizeStreetName of Name
+	Ways []*osm.Way
+}
+
+// StreetMatch is a street found for a query, Score is 1 for the same
+// normalized name and lower the further the name is from the query.
+type StreetMatch struct {
+	*Street
+	Score float64
+}
+
+// StreetSegment is a segment of a way of a street, from node Index of the
+// way to the next.
+type StreetSegment struct {
+	Way      *osm.Way
+	Index    int
+	From, To LatLon
+	Length   float64 // metres
+}
+
+// StreetIndex finds the ways by their name tag, ignoring case, accents and
+// punctuation, and forgiving typing mistakes.
+type StreetIndex struct {
+	streets map[string]*Street // by key
+}
+
+func NewStreetIndex() *StreetIndex {
+	return &StreetIndex{streets: make(map[string]*Street)}
+}
+
+func (m *Map) BuildStreetIndex() *StreetIndex {
+	si := NewStreetIndex()
+	for _, way := range m.Ways {
+		si.insert(way)
+	}
+	return si
+}
+
+func (si *StreetIndex) insert(way *osm.Way) {
+	name := way.Tags.Find("name")
+	key := NormalizeStreetName(name)
+	if key == "" {
+		return
+	}
+	street, ok := si.streets[key]
+	if !ok {
+		street = &Street{Name: name, Key: key}
+		si.streets[key] = street
+	}
+	street.Ways = append(street.Ways, way)
+}
+
+// remove takes the way, found by pointer, off its street.
+func (si *StreetIndex) remove(way *osm.Way) {
+	key := NormalizeStreetName(way.Tags.Find("name"))
+	street, ok := si.streets[key]
+	if !ok {
+		return
+	}
+	street.Ways = slices.DeleteFunc(slices.Clone(street.Ways), func(w *osm.Way) bool { return w == way })
+	if len(street.Ways) == 0 {
+		delete(si.streets, key)
+	}
+}
+
+// Lookup returns the street with the same normalized name, or nil.
+func (si *StreetIndex) Lookup(name string) *Street {
+	return si.streets[NormalizeStreetName(name)]
+}
+
+// Search returns up to limit streets whose name is close to the query,
+// best first, or all of them for a limit of 0 or less. Each query word is
+// matched against the closest word of the name too, so "intendance" finds
+// "Cours de l'Intendance". Names are scanned one by one, which is fast
+// enough for the few thousand streets of a city.
+func (si *StreetIndex) Search(query string, limit int) []StreetMatch {
+	q := NormalizeStreetName(query)
+	if q == "" {
+		return nil
+	}
+
+	var matches []StreetMatch
+	for key, street := range si.streets {
+		if score := streetScore(q, key); score >= streetMatchMinScore {
+			matches = append(matches, StreetMatch{Street: street, Score: score})
+		}
+	}
+
+	slices.SortFunc(matches, func(a, b StreetMatch) int {
+		if a.Score != b.Score {
+			if a.Score > b.Score {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a.Key, b.Key)
+	})
+	if limit > 0 && len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches
+}
+
+// streetScore compares normalized names, the whole of them or word by
+// word. A match on words only scores at most 0.9, below a close match of
+// the whole name.
+func streetScore(q, key string) float64 {
+	if q == key {
+		return 1
+	}
+	score := similarity(q, key)
+
+	words := strings.Fields(key)
+	total := 0.0
+	for _, w := range strings.Fields(q) {
+		best := 0.0
+		for _, kw := range words {
+			best = math.Max(best, similarity(w, kw))
+		}
+		total += best
+	}
+	return math.Max(score, 0.9*total/float64(len(strings.Fields(q))))
+}
+
+// similarity is 1 less the edit distance between a and b over the length
+// of the longer.
+func similarity(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	longest := max(len(ra), len(rb))
+	if longest == 0 {
+		return 1
+	}
+	return 1 - float64(levenshtein(ra, rb))/float64(longest)
+}
+
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// accentFolds maps the lower case letters with diacritics of the Latin
+// alphabets to plain ASCII.
+var accentFolds = func() map[rune]string {
+	folds := make(map[rune]string)
+	for letters, plain := range map[string]string{
+		"àáâãäåāăą": "a", "çćĉċč": "c", "ďđð": "d", "èéêëēĕėęě": "e",
+		"ĝğġģ": "g", "ĥħ": "h", "ìíîïĩīĭįı": "i", "ĵ": "j", "ķ": "k",
+		"ĺļľŀł": "l", "ñńņňŉ": "n", "òóôõöøōŏő": "o", "ŕŗř": "r",
+		"śŝşšș": "s", "ţťŧț": "t", "ùúûüũūŭůűų": "u", "ŵ": "w",
+		"ýÿŷ": "y", "źżž": "z", "æ": "ae", "œ": "oe", "ß": "ss", "þ": "th",
+	} {
+		for _, r := range letters {
+			folds[r] = plain
+		}
+	}
+	return folds
+}()
+
+// NormalizeStreetName lower cases the name, drops its accents and turns
+// any run of punctuation or spaces into a single space, so "Cours de
+// l'Intendance" is "cours de l intendance".
+func NormalizeStreetName(name string) string {
+	var b strings.Builder
+	gap := false
+	letter := func(r rune) {
+		if gap && b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		gap = false
+		b.WriteRune(r)
+	}
+
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			// a combining accent
+		case accentFolds[r] != "":
+			for _, f := range accentFolds[r] {
+				letter(f)
+			}
+		case unicode.IsLetter(r), unicode.IsDigit(r):
+			letter(r)
+		default:
+			gap = true
+		}
+	}
+	return b.String()
+}
+
+// FindStreets searches the street index, see StreetIndex.Search.
+func (em *EnhancedMap) FindStreets(query string, limit int) []StreetMatch {
+	return em.StreetIndex.Search(query, limit)
+}
+
+// StreetGeometry returns the street best matching the name and every
+// segment of it, to spread position hypotheses along, or nil when no
+// street matches.
+func (em *EnhancedMap) StreetGeometry(name string) (*Street, []StreetSegment) {
+	matches := em.StreetIndex.Search(name, 1)
+	if len(matches) == 0 {
+		return nil, nil
+	}
+	street := matches[0].Street
+
+	var segments []StreetSegment
+	for _, way := range street.Ways {
+		for i := 0; i < len(way.Nodes)-1; i++ {
+			n1, ok1 := em.Nodes[way.Nodes[i].ID]
+			n2, ok2 := em.Nodes[way.Nodes[i+1].ID]
+			if !ok1 || !ok2 {
+				continue
+			}
+			segments = append(segments, StreetSegment{
+				Way:    way,
+				Index:  i,
+				From:   LatLon{n1.Lat, n1.Lon},
+				To:     LatLon{n2.Lat, n2.Lon},
+				Length: HaversineDistance(n1.Lat, n1.Lon, n2.Lat, n2.Lon),
+			})
+		}
+	}
+	return street, segments
+}
+
+// ReverseGeocode returns the name of the nearest named way within maxDist
+// metres of the point, and how far it is.
+func (em *EnhancedMap) ReverseGeocode(lat, lon, maxDist float64) (name string, dist float64, ok bool) {
+	for _, m := range em.WaysWithin(lat, lon, maxDist) {
+		if name := m.Way.Tags.Find("name"); name != "" {
+			return name, m.Distance, true
+		}
+	}
+	return "", 0, false
+}
@@ -0,0 +1,156 @@
+package osmprocessing
+
+import (
+	"math"
+	"testing"
+
+	"github.com/paulmach/osm"
+)
+
+func TestNormalizeStreetName(t *testing.T) {
+	tests := []struct {
+		name, want string
+	}{
+		{"Cours de l'Intendance", "cours de l intendance"},
+		{"Rue Sainte-Catherine", "rue sainte catherine"},
+		{"Place de la Comédie", "place de la comedie"},
+		{"Place de la Comédie", "place de la comedie"},
+		{"  Hauptstraße ", "hauptstrasse"},
+		{"Quai des Œuvres, 2", "quai des oeuvres 2"},
+		{"Łódź", "lodz"},
+		{"--", ""},
+	}
+
+	for _, tt := range tests {
+		if got := NormalizeStreetName(tt.name); got != tt.want {
+			t.Errorf("%q: got %q want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// streetMap is GenerateMap with row 1 named Cours de l'Intendance and
+// column 2 Rue Sainte-Catherine.
+func streetMap(opts ...Option) (*EnhancedMap, map[string]osm.NodeID) {
+	m, grid := GenerateMap(3, 3, 200, ToDecimalCoord(44, 50, 0, North), ToDecimalCoord(0, 35, 0, West))
+	for _, way := range m.Ways {
+		for i, tag := range way.Tags {
+			switch tag {
+			case osm.Tag{Key: "name", Value: "Street 1"}:
+				way.Tags[i].Value = "Cours de l'Intendance"
+			case osm.Tag{Key: "name", Value: "Avenue 2"}:
+				way.Tags[i].Value = "Rue Sainte-Catherine"
+			}
+		}
+	}
+	return NewEnhancedMap(m, opts...), grid
+}
+
+func TestFindStreets(t *testing.T) {
+	em, _ := streetMap()
+
+	tests := []struct {
+		query, want string
+	}{
+		{"Cours de l'Intendance", "Cours de l'Intendance"},
+		{"cours de lintendance", "Cours de l'Intendance"},
+		{"COURS DE L’INTENDANCE", "Cours de l'Intendance"},
+		{"intendance", "Cours de l'Intendance"},
+		{"rue ste catherine", "Rue Sainte-Catherine"},
+		{"Street 2", "Street 2"},
+		{"Streat 3", "Street 3"},
+		{"Avenu 0", "Avenue 0"},
+	}
+	for _, tt := range tests {
+		matches := em.FindStreets(tt.query, 3)
+		if len(matches) == 0 || matches[0].Name != tt.want {
+			t.Errorf("%q: got %v want %q", tt.query, matches, tt.want)
+			continue
+		}
+		if len(matches[0].Ways) != 3 {
+			t.Errorf("%q: %d ways", tt.query, len(matches[0].Ways))
+		}
+	}
+
+	if matches := em.FindStreets("Boulevard du Président Wilson", 3); len(matches) != 0 {
+		t.Errorf("got %v", matches)
+	}
+	if m := em.FindStreets("Street 2", 1); len(m) != 1 || m[0].Score != 1 {
+		t.Errorf("got %v", m)
+	}
+	// no limit returns every match, Street 0, 2 and 3, a negative one too
+	if m := em.FindStreets("street", 2); len(m) != 2 {
+		t.Errorf("got %d streets for a limit of 2", len(m))
+	}
+	all := em.FindStreets("street", 0)
+	if len(all) != 3 {
+		t.Errorf("got %d streets without a limit", len(all))
+	}
+	if m := em.FindStreets("street", -1); len(m) != len(all) {
+		t.Errorf("got %d streets for a negative limit, want %d", len(m), len(all))
+	}
+	if s := em.StreetIndex.Lookup("cours de l intendance"); s == nil || s.Name != "Cours de l'Intendance" {
+		t.Errorf("looked up %v", s)
+	}
+
+	em, _ = streetMap(WithAuxIndexes(AuxJunctions))
+	if matches := em.FindStreets("Street 2", 1); len(matches) != 0 {
+		t.Errorf("found %v without a street index", matches)
+	}
+}
+
+func TestStreetGeometry(t *testing.T) {
+	em, grid := streetMap()
+
+	street, segments := em.StreetGeometry("Cours de l'Intendance")
+	if street == nil || len(segments) != 3 {
+		t.Fatalf("got %v with %d segments", street, len(segments))
+	}
+	start := em.Nodes[grid["1,0"]]
+	length := 0.0
+	for _, s := range segments {
+		length += s.Length
+	}
+	if segments[0].From != (LatLon{start.Lat, start.Lon}) || math.Abs(length-600) > 0.5 {
+		t.Errorf("starts at %v, %.2fm long", segments[0].From, length)
+	}
+
+	if street, segments := em.StreetGeometry("nowhere"); street != nil || segments != nil {
+		t.Errorf("got %v", street)
+	}
+}
+
+func TestReverseGeocode(t *testing.T) {
+	em, grid := streetMap()
+
+	// 10m north of the middle of the second block
+	node := em.Nodes[grid["1,1"]]
+	lat, lon := DestinationPoint(node.Lat, node.Lon, 90, 100)
+	lat, lon = DestinationPoint(lat, lon, 0, 10)
+
+	name, dist, ok := em.ReverseGeocode(lat, lon, 50)
+	if !ok || name != "Cours de l'Intendance" || math.Abs(dist-10) > 0.1 {
+		t.Errorf("got %q at %.2fm", name, dist)
+	}
+	if _, _, ok := em.ReverseGeocode(lat, lon, 5); ok {
+		t.Error("found a street 5m away")
+	}
+}
+
+func TestEditingStreetNames(t *testing.T) {
+	em, _ := streetMap()
+	street := em.StreetIndex.Lookup("Street 0")
+
+	if err := em.RetagWay(street.Ways[0].ID, osm.Tags{{Key: "highway", Value: "residential"}, {Key: "name", Value: "Allée de Tourny"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := em.DeleteWay(street.Ways[1].ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if s := em.StreetIndex.Lookup("Street 0"); s == nil || len(s.Ways) != 1 {
+		t.Errorf("street 0 is %v", s)
+	}
+	if m := em.FindStreets("allee de tourny", 1); len(m) != 1 || len(m[0].Ways) != 1 || em.WaysByID[m[0].Ways[0].ID] != m[0].Ways[0] {
+		t.Errorf("got %v", m)
+	}
+}
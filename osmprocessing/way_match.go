@@ -0,0 +1,47 @@
+package osmprocessing
+
+import (
+	"sort"
+
+	"github.com/paulmach/osm"
+)
+
+// WayMatch is a way near a query point with where the point falls on it.
+type WayMatch struct {
+	Way *osm.Way
+	WayProjection
+}
+
+// matchWays projects the point on each way and keeps those within radius,
+// closest first. Ways at the same distance, as at a junction, are ordered
+// by ID.
+func matchWays(lat, lon float64, ways []*osm.Way, nodes map[osm.NodeID]*osm.Node, radius float64) []WayMatch {
+	var matches []WayMatch
+
+	for _, way := range ways {
+		proj, ok := ProjectOnWay(lat, lon, way, nodes)
+		if ok && proj.Distance <= radius {
+			matches = append(matches, WayMatch{Way: way, WayProjection: proj})
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Distance != matches[j].Distance {
+			return matches[i].Distance < matches[j].Distance
+		}
+		return matches[i].Way.ID < matches[j].Way.ID
+	})
+
+	return matches
+}
+
+// NearestWays returns up to k ways within maxDist of the point, closest
+// first, for map matching that keeps several hypotheses.
+func (em *EnhancedMap) NearestWays(lat, lon float64, k int, maxDist float64) []WayMatch {
+	return em.wayIndex().NearestWays(lat, lon, k, maxDist)
+}
+
+// WaysWithin returns every way within radius of the point, closest first.
+func (em *EnhancedMap) WaysWithin(lat, lon, radius float64) []WayMatch {
+	return em.wayIndex().WaysWithin(lat, lon, radius)
+}
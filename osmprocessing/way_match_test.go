@@ -0,0 +1,88 @@
+package osmprocessing
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestNearestWaysRanked(t *testing.T) {
+	rng := rand.New(rand.NewSource(4))
+	m := randomWayMap(rng, 300, 44.84, -0.58, 2000)
+	em := NewEnhancedMap(m)
+	grid := NewGridWayIndex(m, em.SpatialIndex)
+
+	for i := 0; i < 100; i++ {
+		lat, lon := DestinationPoint(44.84, -0.58, rng.Float64()*360, rng.Float64()*2000)
+		all := matchWays(lat, lon, m.Ways, m.Nodes, math.Inf(1))
+
+		for _, index := range []WayIndex{em.WayIndex, grid} {
+			got := index.NearestWays(lat, lon, 5, math.Inf(1))
+			if len(got) != 5 {
+				t.Fatalf("%T: got %d matches", index, len(got))
+			}
+			for j, match := range got {
+				if match.Way.ID != all[j].Way.ID || math.Abs(match.Distance-all[j].Distance) > 1e-9 {
+					t.Fatalf("%T: rank %d is way %d at %.3f, want way %d at %.3f",
+						index, j, match.Way.ID, match.Distance, all[j].Way.ID, all[j].Distance)
+				}
+			}
+
+			within := index.WaysWithin(lat, lon, 120)
+			want := 0
+			for want < len(all) && all[want].Distance <= 120 {
+				want++
+			}
+			if len(within) != want {
+				t.Fatalf("%T: %d ways within 120m, want %d", index, len(within), want)
+			}
+			for j, match := range within {
+				if match.Way.ID != all[j].Way.ID {
+					t.Fatalf("%T: rank %d is way %d, want %d", index, j, match.Way.ID, all[j].Way.ID)
+				}
+			}
+		}
+	}
+}
+
+func TestNearestWaysProjection(t *testing.T) {
+	m, grid := GenerateMap(3, 3, 200,
+		ToDecimalCoord(46, 0, 0, North),
+		ToDecimalCoord(7, 0, 0, East))
+	em := NewEnhancedMap(m)
+
+	// 60m north of a junction and 5m east of it, the avenue leans slightly
+	// so it is not exactly 5m away
+	junction := m.Nodes[grid["1,1"]]
+	lat, lon := DestinationPoint(junction.Lat, junction.Lon, 0, 60)
+	lat, lon = DestinationPoint(lat, lon, 90, 5)
+
+	matches := em.NearestWays(lat, lon, 3, 100)
+	if len(matches) != 3 {
+		t.Fatalf("got %d matches", len(matches))
+	}
+
+	avenue := matches[0]
+	if math.Abs(avenue.Distance-5) > 0.05 || math.Abs(avenue.CrossTrack-avenue.Distance) > 1e-9 {
+		t.Errorf("avenue at %.3f cross track %.3f, want 5m on the right", avenue.Distance, avenue.CrossTrack)
+	}
+	if math.Abs(avenue.AlongTrack-60) > 0.1 || avenue.SegmentIndex != 0 {
+		t.Errorf("avenue offset %.3f on segment %d, want 60", avenue.AlongTrack, avenue.SegmentIndex)
+	}
+	if math.Abs(BearingDifference(avenue.Bearing, 0)) > 0.1 {
+		t.Errorf("avenue bearing %.3f", avenue.Bearing)
+	}
+
+	for i := 1; i < len(matches); i++ {
+		if matches[i].Distance < matches[i-1].Distance {
+			t.Errorf("match %d at %.3f is closer than the one before", i, matches[i].Distance)
+		}
+	}
+
+	if got := em.WaysWithin(lat, lon, 10); len(got) != 1 || got[0].Way.ID != avenue.Way.ID {
+		t.Errorf("got %d ways within 10m", len(got))
+	}
+	if got := em.NearestWays(lat, lon, 0, 100); got != nil {
+		t.Errorf("k of 0 gave %d matches", len(got))
+	}
+}
@@ -0,0 +1,56 @@
+package osmprocessing
+
+import (
+	"math"
+
+	"github.com/paulmach/osm"
+)
+
+// WayProjection is the closest point of a way to a query point.
+type WayProjection struct {
+	Lat, Lon     float64 // closest point on the way
+	SegmentIndex int     // segment from way.Nodes[SegmentIndex] to way.Nodes[SegmentIndex+1]
+	T            float64 // position along the segment, 0 to 1
+	Distance     float64 // metres from the query point
+	AlongTrack   float64 // metres from the start of the way to the closest point
+	CrossTrack   float64 // Distance signed positive right of the way direction
+	Bearing      float64 // bearing of the segment
+}
+
+// ProjectOnWay finds the closest point of the way in a single pass over its
+// segments. Segments with missing nodes are skipped and don't count
+// towards AlongTrack, like in GetWayLength. ok is false when the way has no
+// usable segment.
+func ProjectOnWay(lat, lon float64, way *osm.Way, nodes map[osm.NodeID]*osm.Node) (proj WayProjection, ok bool) {
+	proj.Distance = math.Inf(1)
+	travelled := 0.0
+
+	for i := 0; i < len(way.Nodes)-1; i++ {
+		node1, ok1 := nodes[way.Nodes[i].ID]
+		node2, ok2 := nodes[way.Nodes[i+1].ID]
+
+		if !ok1 || !ok2 {
+			continue
+		}
+
+		segLength := HaversineDistance(node1.Lat, node1.Lon, node2.Lat, node2.Lon)
+		t, dist, cross := projectOnSegment(lat, lon, node1.Lat, node1.Lon, node2.Lat, node2.Lon)
+
+		if dist < proj.Distance {
+			proj = WayProjection{
+				Lat:          node1.Lat + t*(node2.Lat-node1.Lat),
+				Lon:          node1.Lon + t*(node2.Lon-node1.Lon),
+				SegmentIndex: i,
+				T:            t,
+				Distance:     dist,
+				AlongTrack:   travelled + t*segLength,
+				CrossTrack:   cross,
+				Bearing:      CalculateBearing(node1.Lat, node1.Lon, node2.Lat, node2.Lon),
+			}
+			ok = true
+		}
+		travelled += segLength
+	}
+
+	return proj, ok
+}
@@ -0,0 +1,95 @@
+package osmprocessing
+
+import (
+	"math"
+	"testing"
+
+	"github.com/paulmach/osm"
+)
+
+func TestProjectOnWay(t *testing.T) {
+	m, grid := GenerateMap(1, 2, 100,
+		ToDecimalCoord(46, 0, 0, North),
+		ToDecimalCoord(7, 0, 0, East))
+
+	// east along the bottom row, then north
+	way := &osm.Way{ID: 1, Nodes: osm.WayNodes{
+		{ID: grid["0,0"]}, {ID: grid["0,1"]}, {ID: grid["0,2"]}, {ID: grid["1,2"]},
+	}}
+
+	t.Run("left of the eastbound leg", func(t *testing.T) {
+		n1, n2 := m.Nodes[grid["0,1"]], m.Nodes[grid["0,2"]]
+		lat, lon := DestinationPoint((n1.Lat+n2.Lat)/2, (n1.Lon+n2.Lon)/2, 0, 30)
+
+		proj, ok := ProjectOnWay(lat, lon, way, m.Nodes)
+		if !ok {
+			t.Fatal("expected a projection")
+		}
+		if proj.SegmentIndex != 1 {
+			t.Errorf("segment %d, want 1", proj.SegmentIndex)
+		}
+		if math.Abs(proj.T-0.5) > 0.01 {
+			t.Errorf("t %.4f, want 0.5", proj.T)
+		}
+		assertWithinPercent(t, proj.AlongTrack, 150, 0.5)
+		assertWithinPercent(t, proj.CrossTrack, -30, 0.5)
+		assertWithinPercent(t, proj.Distance, 30, 0.5)
+		if math.Abs(BearingDifference(proj.Bearing, 90)) > 0.1 {
+			t.Errorf("bearing %.2f, want 90", proj.Bearing)
+		}
+		if HaversineDistance(proj.Lat, proj.Lon, lat, lon) > 30.1 {
+			t.Errorf("closest point %.6f,%.6f too far", proj.Lat, proj.Lon)
+		}
+	})
+
+	t.Run("right of the northbound leg", func(t *testing.T) {
+		n := m.Nodes[grid["1,2"]]
+		lat, lon := DestinationPoint(n.Lat, n.Lon, 180, 20)
+		lat, lon = DestinationPoint(lat, lon, 90, 10)
+
+		proj, _ := ProjectOnWay(lat, lon, way, m.Nodes)
+		if proj.SegmentIndex != 2 {
+			t.Errorf("segment %d, want 2", proj.SegmentIndex)
+		}
+		assertWithinPercent(t, proj.CrossTrack, 10, 0.5)
+		assertWithinPercent(t, proj.AlongTrack, GetWayLength(way, m.Nodes)-20, 0.5)
+		if math.Abs(BearingDifference(proj.Bearing, 0)) > 0.1 {
+			t.Errorf("bearing %.2f, want 0", proj.Bearing)
+		}
+	})
+
+	t.Run("before the start clamps to the first node", func(t *testing.T) {
+		n := m.Nodes[grid["0,0"]]
+		lat, lon := DestinationPoint(n.Lat, n.Lon, 270, 40)
+
+		proj, _ := ProjectOnWay(lat, lon, way, m.Nodes)
+		if proj.SegmentIndex != 0 || proj.T != 0 || proj.AlongTrack != 0 {
+			t.Errorf("got segment %d t %.4f along %.4f", proj.SegmentIndex, proj.T, proj.AlongTrack)
+		}
+		if proj.Lat != n.Lat || proj.Lon != n.Lon {
+			t.Errorf("closest point %.7f,%.7f is not the first node", proj.Lat, proj.Lon)
+		}
+		assertWithinPercent(t, proj.Distance, 40, 0.5)
+	})
+
+	t.Run("agrees with the distance and heading helpers", func(t *testing.T) {
+		lat, lon := m.Nodes[grid["1,1"]].Lat, m.Nodes[grid["1,1"]].Lon
+		proj, _ := ProjectOnWay(lat, lon, way, m.Nodes)
+		if d := DistanceToWay(lat, lon, way, m.Nodes); d != proj.Distance {
+			t.Errorf("DistanceToWay %.4f, projection %.4f", d, proj.Distance)
+		}
+		if h := GetWayHeadingAtPoint(way, lat, lon, m.Nodes); h != proj.Bearing {
+			t.Errorf("GetWayHeadingAtPoint %.4f, projection %.4f", h, proj.Bearing)
+		}
+	})
+
+	t.Run("no usable segment", func(t *testing.T) {
+		broken := &osm.Way{ID: 2, Nodes: osm.WayNodes{{ID: grid["0,0"]}, {ID: 999}}}
+		if _, ok := ProjectOnWay(0, 0, broken, m.Nodes); ok {
+			t.Error("expected no projection")
+		}
+		if d := DistanceToWay(0, 0, broken, m.Nodes); !math.IsInf(d, 1) {
+			t.Errorf("DistanceToWay %v, want +Inf", d)
+		}
+	})
+}
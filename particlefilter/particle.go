@@ -11,6 +11,11 @@ type VOReading struct {
 	Distance, Angle float64
 }
 
+type LandmarkObservation struct {
+	Type            osmprocessing.LandmarkType
+	Distance, Angle float64
+}
+
 type Particle struct {
 	Lat     float64
 	Lon     float64
@@ -22,6 +27,9 @@ type ParticleFilter struct {
 	Particles []Particle
 	Map       *osmprocessing.EnhancedMap
 	rng       *rand.Rand
+
+	roads     *osmprocessing.RoadBatch
+	positions []osmprocessing.LatLon
 }
 
 func NewParticleFilter(numberOfParticles int, EnhancedMap *osmprocessing.EnhancedMap) *ParticleFilter {
@@ -34,15 +42,18 @@ func NewParticleFilter(numberOfParticles int, EnhancedMap *osmprocessing.Enhance
 
 func (pf *ParticleFilter) InitParticles(initLat, initLon float64, errorMeter float64) {
 
+	frame := osmprocessing.NewLocalENU(initLat, initLon)
+
 	for i := 0; i < len(pf.Particles); i++ {
 
-		latNoise, lonNoise := pf.rng.NormFloat64()*errorMeter/111320.0, rand.NormFloat64()*errorMeter/111320.0
+		eastNoise, northNoise := pf.rng.NormFloat64()*errorMeter, pf.rng.NormFloat64()*errorMeter
+		lat, lon := frame.Inverse(eastNoise, northNoise)
 
 		pf.Particles[i] = Particle{
 			Heading: pf.rng.Float64() * 360,
 			Weight:  1 / float64(len(pf.Particles)),
-			Lat:     initLat + latNoise,
-			Lon:     initLon + lonNoise,
+			Lat:     lat,
+			Lon:     lon,
 		}
 	}
 }
@@ -155,11 +166,24 @@ func (pf *ParticleFilter) ParticleUpdateWeigh() {
 
 	totalWeigh := 0.0
 
-	for i, particle := range pf.Particles {
+	if pf.roads == nil {
+		pf.roads = pf.Map.NewRoadBatch()
+	}
+	pf.positions = pf.positions[:0]
+	for _, particle := range pf.Particles {
+		pf.positions = append(pf.positions, osmprocessing.LatLon{Lat: particle.Lat, Lon: particle.Lon})
+	}
+	radius := pf.Map.SearchRadius
+	if radius <= 0 {
+		// a map built by hand rather than by NewEnhancedMap
+		radius = osmprocessing.DefaultSearchRadius
+	}
+	roads := pf.roads.Query(pf.positions, radius)
 
-		nearestWay, distance := pf.Map.FindNearestWayFast(particle.Lat, particle.Lon, 50.0)
+	for i, particle := range pf.Particles {
 
-		if nearestWay == nil {
+		distance, bearing, ok := roads[i].Distance, roads[i].Bearing, roads[i].OK
+		if !ok {
 			pf.Particles[i].Weight = 0.001
 			totalWeigh += pf.Particles[i].Weight
 			continue
@@ -167,9 +191,8 @@ func (pf *ParticleFilter) ParticleUpdateWeigh() {
 
 		probabilityBasedOnDistance := gaussianProbability(0, 2.0, distance)
 
-		wayBearing := osmprocessing.GetWayHeadingAtPoint(nearestWay, pf.Particles[i].Lat, pf.Particles[i].Lon, pf.Map.Nodes)
-		bearingDiff := math.Abs(osmprocessing.BearingDifference(particle.Heading, wayBearing))
-		probabilityBasedOnBearing := gaussianProbability(0, 15, bearingDiff)
+		headingModel := osmprocessing.NewVonMisesFromStdDev(osmprocessing.BearingDecimal(bearing), 15)
+		probabilityBasedOnBearing := headingModel.Likelihood(osmprocessing.BearingDecimal(particle.Heading))
 
 		pf.Particles[i].Weight = probabilityBasedOnBearing * probabilityBasedOnDistance
 
@@ -186,6 +209,75 @@ func (pf *ParticleFilter) ParticleUpdateWeigh() {
 
 }
 
+const (
+	// how far in metres from where a particle expects the landmark to look
+	// for one of the observed type
+	landmarkSearchRadius = 30.0
+	// standard deviation in metres of where an observed landmark lies
+	landmarkSigma = 5.0
+)
+
+func (pf *ParticleFilter) LandmarkUpdateWeigh(observation LandmarkObservation) {
+
+	totalWeigh := 0.0
+
+	for i, particle := range pf.Particles {
+
+		expectedLat, expectedLon := pf.Map.Destination(particle.Lat, particle.Lon,
+			osmprocessing.NormalizeBearing(particle.Heading+observation.Angle), observation.Distance)
+
+		landmark, distance := pf.Map.FindNearestLandmark(expectedLat, expectedLon, landmarkSearchRadius, observation.Type)
+
+		probabilityBasedOnLandmark := 0.001
+		if landmark != nil {
+			probabilityBasedOnLandmark = math.Max(gaussianProbability(0, landmarkSigma, distance), 0.001)
+		}
+
+		pf.Particles[i].Weight *= probabilityBasedOnLandmark
+		totalWeigh += pf.Particles[i].Weight
+	}
+	if totalWeigh > 0 {
+		for i := range pf.Particles {
+			pf.Particles[i].Weight /= totalWeigh
+		}
+	}
+}
+
+// Estimate returns the weighted mean of the particles. The heading is a
+// circular mean, so particles at 359° and 1° give 0°.
+func (pf *ParticleFilter) Estimate() Particle {
+	if len(pf.Particles) == 0 {
+		return Particle{}
+	}
+
+	headings := make([]osmprocessing.BearingDecimal, len(pf.Particles))
+	weights := make([]float64, len(pf.Particles))
+	var lat, lon, total float64
+
+	// longitudes relative to the first particle, in case of the antimeridian,
+	// BearingDifference wraps them the same way as bearings
+	refLon := pf.Particles[0].Lon
+	for i, p := range pf.Particles {
+		headings[i] = osmprocessing.BearingDecimal(p.Heading)
+		weights[i] = p.Weight
+		lat += p.Weight * p.Lat
+		lon += p.Weight * osmprocessing.BearingDifference(refLon, p.Lon)
+		total += p.Weight
+	}
+	if total <= 0 {
+		return Particle{}
+	}
+
+	heading, _ := osmprocessing.CircularMean(headings, weights)
+
+	return Particle{
+		Lat:     lat / total,
+		Lon:     osmprocessing.MakeCoordinateDecimal(refLon+lon/total, osmprocessing.Longitude).DecimalDegree,
+		Heading: float64(heading),
+		Weight:  total,
+	}
+}
+
 // Systematic resampling https://people.isy.liu.se/rt/schon/Publications/HolSG2006.pdf
 func (pf *ParticleFilter) Resample() {
 	newParticles := make([]Particle, len(pf.Particles))
@@ -225,15 +317,8 @@ func (pf *ParticleFilter) MoveParticles(voReading VOReading) {
 		pf.Particles[i].Heading = osmprocessing.NormalizeBearing(pf.Particles[i].Heading)
 
 		if voReading.Distance > 0 {
-			newLat, newLon := osmprocessing.CalculateDestinationPoint(
-				osmprocessing.MakeCoordinateDecimal(pf.Particles[i].Lat, osmprocessing.Latitude),
-				osmprocessing.MakeCoordinateDecimal(pf.Particles[i].Lon, osmprocessing.Longitude),
-				osmprocessing.BearingDecimal(pf.Particles[i].Heading),
-				voReading.Distance,
-			)
-
-			pf.Particles[i].Lat = newLat.DecimalDegree
-			pf.Particles[i].Lon = newLon.DecimalDegree
+			pf.Particles[i].Lat, pf.Particles[i].Lon = pf.Map.Destination(
+				pf.Particles[i].Lat, pf.Particles[i].Lon, pf.Particles[i].Heading, voReading.Distance)
 		}
 	}
 }
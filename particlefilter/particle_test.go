@@ -1,6 +1,7 @@
 package particlefilter
 
 import (
+	"math"
 	"roboticsproject/osmprocessing"
 	"testing"
 )
@@ -86,6 +87,19 @@ func TestMoveParticles(t *testing.T) {
 		}
 	})
 
+	t.Run("small steps accumulate", func(t *testing.T) {
+		pf.Particles[0] = Particle{Lat: startNode.Lat, Lon: startNode.Lon, Heading: 90, Weight: 1.0 / 50}
+
+		for i := 0; i < 100; i++ {
+			pf.MoveParticles(VOReading{Distance: 0.5, Angle: 0})
+		}
+
+		dist := osmprocessing.HaversineDistance(startNode.Lat, startNode.Lon, pf.Particles[0].Lat, pf.Particles[0].Lon)
+		if dist < 49.999 || dist > 50.001 {
+			t.Errorf("100 steps of 50cm moved %.4fm", dist)
+		}
+	})
+
 	t.Run("zero distance does not move particles", func(t *testing.T) {
 		pf.InitParticles(startNode.Lat, startNode.Lon, 1.0)
 
@@ -224,3 +238,76 @@ func TestMotionUpdate_Turning(t *testing.T) {
 		}
 	})
 }
+
+func TestEstimate(t *testing.T) {
+	pf := NewParticleFilter(4, nil)
+	pf.Particles = []Particle{
+		{Lat: 46.0, Lon: 179.999, Heading: 358, Weight: 0.25},
+		{Lat: 46.0, Lon: -179.999, Heading: 2, Weight: 0.25},
+		{Lat: 46.001, Lon: 179.999, Heading: 359, Weight: 0.25},
+		{Lat: 46.001, Lon: -179.999, Heading: 1, Weight: 0.25},
+	}
+
+	estimate := pf.Estimate()
+
+	if math.Abs(osmprocessing.BearingDifference(estimate.Heading, 0)) > 1e-9 {
+		t.Errorf("heading %.6f, want 0", estimate.Heading)
+	}
+	if math.Abs(estimate.Lat-46.0005) > 1e-9 {
+		t.Errorf("lat %.7f, want 46.0005", estimate.Lat)
+	}
+	if math.Abs(math.Abs(estimate.Lon)-180) > 1e-9 {
+		t.Errorf("lon %.7f, want 180", estimate.Lon)
+	}
+}
+
+func TestHeadingWeighAcrossNorth(t *testing.T) {
+	m, grid := osmprocessing.GenerateMap(2, 2, 200,
+		osmprocessing.ToDecimalCoord(46, 0, 0, osmprocessing.North),
+		osmprocessing.ToDecimalCoord(7, 0, 0, osmprocessing.East))
+	em := osmprocessing.NewEnhancedMap(m)
+
+	// on a northbound avenue, 5 degrees either side of north
+	n1, n2 := m.Nodes[grid["0,1"]], m.Nodes[grid["1,1"]]
+	lat, lon := (n1.Lat+n2.Lat)/2, (n1.Lon+n2.Lon)/2
+
+	pf := NewParticleFilter(3, em)
+	pf.Particles = []Particle{
+		{Lat: lat, Lon: lon, Heading: 355, Weight: 1.0 / 3},
+		{Lat: lat, Lon: lon, Heading: 5, Weight: 1.0 / 3},
+		{Lat: lat, Lon: lon, Heading: 45, Weight: 1.0 / 3},
+	}
+	pf.ParticleUpdateWeigh()
+
+	// the avenue is within a hundredth of a degree of north
+	if math.Abs(pf.Particles[0].Weight-pf.Particles[1].Weight) > 1e-3 {
+		t.Errorf("355 and 5 weigh %.6f and %.6f", pf.Particles[0].Weight, pf.Particles[1].Weight)
+	}
+	if pf.Particles[2].Weight >= pf.Particles[0].Weight {
+		t.Errorf("45 weighs %.6f, more than 355 at %.6f", pf.Particles[2].Weight, pf.Particles[0].Weight)
+	}
+}
+
+func TestRoadWeighWithoutSearchRadius(t *testing.T) {
+	m, grid := osmprocessing.GenerateMap(2, 2, 200,
+		osmprocessing.ToDecimalCoord(46, 0, 0, osmprocessing.North),
+		osmprocessing.ToDecimalCoord(7, 0, 0, osmprocessing.East))
+	em := osmprocessing.NewEnhancedMap(m)
+	em.SearchRadius = 0
+
+	n1, n2 := m.Nodes[grid["0,1"]], m.Nodes[grid["1,1"]]
+	lat, lon := (n1.Lat+n2.Lat)/2, (n1.Lon+n2.Lon)/2
+	offLat, offLon := em.Destination(lat, lon, 90, 3)
+
+	pf := NewParticleFilter(2, em)
+	pf.Particles = []Particle{
+		{Lat: lat, Lon: lon, Heading: 0, Weight: 0.5},
+		{Lat: offLat, Lon: offLon, Heading: 0, Weight: 0.5},
+	}
+	pf.ParticleUpdateWeigh()
+
+	// the particle on the avenue outweighs the one 3m off it
+	if pf.Particles[0].Weight <= pf.Particles[1].Weight {
+		t.Errorf("on the road %.6f, 3m off %.6f", pf.Particles[0].Weight, pf.Particles[1].Weight)
+	}
+}